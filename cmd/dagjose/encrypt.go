@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ceramicnetwork/go-dag-jose/dagjose"
+)
+
+const (
+	encryptUsage = "-key jwk-file [-key jwk-file]... [-enc enc] [-include-cid] [-o file] [file]"
+	decryptUsage = "-key jwk-file [-key jwk-file]... [-format dag-cbor|dag-json] [-o file] [file]"
+)
+
+func runEncrypt(args []string, s streams) error {
+	fs := newFlagSet(s, "encrypt", encryptUsage)
+	var keyFiles stringList
+	fs.Var(&keyFiles, "key", "recipient JWK, may be repeated for several recipients")
+	enc := fs.String("enc", dagjose.A256GCM, "content encryption algorithm")
+	includeCID := fs.Bool("include-cid", false, "add the CID of the cleartext block to the protected header")
+	out := fs.String("o", "", "file to write the JWE block to, stdout if empty")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(keyFiles) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	jwks, err := readJWKs(keyFiles)
+	if err != nil {
+		return err
+	}
+	recipients := make([]dagjose.RecipientKey, len(jwks))
+	for idx, jwk := range jwks {
+		recipients[idx] = dagjose.RecipientKey{Key: jwk}
+	}
+	block, err := readInput(fs, s.stdin)
+	if err != nil {
+		return err
+	} else if _, err := ipld.Decode(block, dagcbor.Decode); err != nil {
+		return fmt.Errorf("input is not a dag-cbor block: %v", err)
+	}
+	blockCid, err := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}.Sum(block)
+	if err != nil {
+		return err
+	}
+	cfg := dagjose.EncryptOptions{ContentEncryption: *enc, IncludeCID: *includeCID}
+	if jwe, err := cfg.EncryptBlock(block, blockCid, recipients...); err != nil {
+		return err
+	} else if jweCid, jweBlock, err := encodeBlock(jwe); err != nil {
+		return err
+	} else {
+		return writeBlock(s, *out, jweCid, jweBlock)
+	}
+}
+
+func runDecrypt(args []string, s streams) error {
+	fs := newFlagSet(s, "decrypt", decryptUsage)
+	var keyFiles stringList
+	fs.Var(&keyFiles, "key", "private JWK to decrypt with, may be repeated")
+	format := fs.String("format", "dag-cbor", "output format of the cleartext block")
+	out := fs.String("o", "", "file to write the cleartext to, stdout if empty")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(keyFiles) == 0 || (*format != "dag-cbor" && *format != "dag-json") {
+		fs.Usage()
+		return errUsage
+	}
+	cfg := dagjose.DecryptOptions{}
+	if jwks, err := readJWKs(keyFiles); err != nil {
+		return err
+	} else {
+		for _, jwk := range jwks {
+			cfg.Keys = append(cfg.Keys, jwk)
+		}
+	}
+	data, err := readInput(fs, s.stdin)
+	if err != nil {
+		return err
+	}
+	jwe, err := ipld.Decode(data, dagjose.Decode)
+	if err != nil {
+		return err
+	} else if _, err := jwe.LookupByString("ciphertext"); err != nil {
+		return errors.New("block is a JWS, not a JWE")
+	}
+	// The cleartext is decoded with the codec of its `cid` protected header parameter, if any, and re-encoded in the
+	// requested format, so that a block of another codec is not written as dag-cbor
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := cfg.Decrypt(jwe, nb); err != nil {
+		return err
+	}
+	encoder := dagcbor.Encode
+	if *format == "dag-json" {
+		encoder = dagjson.Encode
+	}
+	if cleartext, err := ipld.Encode(nb.Build(), encoder); err != nil {
+		return err
+	} else {
+		return writeOutput(s, *out, cleartext)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ceramicnetwork/go-dag-jose/dagjose"
+)
+
+func TestEncryptAndDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwk := gojose.JSONWebKey{Key: ecKey, KeyID: "key-1"}
+	privateFile := writeJSON(t, dir, "private.jwk", jwk)
+	publicFile := writeJSON(t, dir, "public.jwk", jwk.Public())
+	n := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("hello").AssignString("world")
+	})
+	block, err := ipld.Encode(n, dagcbor.Encode)
+	require.NoError(t, err)
+
+	jweBlock, err := runCommand(t, block, "encrypt", "-key", publicFile, "-include-cid")
+	require.NoError(t, err)
+	jwe, err := ipld.Decode([]byte(jweBlock), dagjose.Decode)
+	require.NoError(t, err)
+	decoded, err := dagjose.AsJWE(jwe)
+	require.NoError(t, err)
+	// The header parameters of a single recipient are protected
+	require.Contains(t, string(decoded.Protected), `"kid":"key-1"`)
+	require.Contains(t, string(decoded.Protected), `"cid":`)
+
+	out, err := runCommand(t, []byte(jweBlock), "decrypt", "-key", privateFile)
+	require.NoError(t, err)
+	require.Equal(t, block, []byte(out))
+	expected, err := ipld.Encode(n, dagjson.Encode)
+	require.NoError(t, err)
+	out, err = runCommand(t, []byte(jweBlock), "decrypt", "-key", privateFile, "-format", "dag-json")
+	require.NoError(t, err)
+	require.Equal(t, string(expected), out)
+
+	// A cleartext of another codec is converted to the requested format
+	jsonCid, err := cid.Prefix{Version: 1, Codec: cid.DagJSON, MhType: multihash.SHA2_256, MhLength: -1}.Sum(expected)
+	require.NoError(t, err)
+	jsonJWE, err := dagjose.EncryptOptions{IncludeCID: true}.EncryptBlock(expected, jsonCid, dagjose.RecipientKey{Key: &ecKey.PublicKey})
+	require.NoError(t, err)
+	jsonJWEBlock, err := ipld.Encode(jsonJWE, dagjose.Encode)
+	require.NoError(t, err)
+	out, err = runCommand(t, jsonJWEBlock, "decrypt", "-key", privateFile)
+	require.NoError(t, err)
+	require.Equal(t, block, []byte(out))
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherFile := writeJSON(t, dir, "other.jwk", gojose.JSONWebKey{Key: otherKey})
+	_, err = runCommand(t, []byte(jweBlock), "decrypt", "-key", otherFile)
+	require.Error(t, err)
+
+	_, err = runCommand(t, []byte("not a block"), "encrypt", "-key", publicFile)
+	require.ErrorContains(t, err, "not a dag-cbor block")
+	_, err = runCommand(t, testJWSBlock(t), "decrypt", "-key", privateFile)
+	require.ErrorContains(t, err, "not a JWE")
+	_, err = runCommand(t, block, "encrypt")
+	require.ErrorIs(t, err, errUsage)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+
+	"github.com/ceramicnetwork/go-dag-jose/dagjose"
+)
+
+const (
+	inspectUsage = "[-format dag-json|general|flattened|compact|none] [file]"
+	convertUsage = "-format dag-json|general|flattened|compact [file]"
+)
+
+// block is a decoded dag-jose block along with its CID.
+type block struct {
+	cid  cid.Cid
+	node datamodel.Node
+	jws  *dagjose.JWS
+	jwe  *dagjose.JWE
+}
+
+// decodeBlock decodes a dag-jose block, accepting JWS with a detached payload, and computes its CID using the default
+// dag-jose link prototype. The `link` field is not added so that the node matches the block as stored.
+func decodeBlock(data []byte) (*block, error) {
+	b := &block{}
+	var err error
+	if b.cid, err = dagjose.LinkPrototype.Sum(data); err != nil {
+		return nil, err
+	} else if b.node, err = ipld.Decode(data, dagjose.DecodeOptions{DetachedPayload: true}.Decode); err != nil {
+		return nil, err
+	} else if _, err := b.node.LookupByString("ciphertext"); err == nil {
+		b.jwe, err = dagjose.AsJWE(b.node)
+		return b, err
+	} else {
+		b.jws, err = dagjose.AsJWS(b.node)
+		return b, err
+	}
+}
+
+// formats are the serializations a block can be written in.
+var formats = map[string]bool{"dag-json": true, "general": true, "flattened": true, "compact": true}
+
+// writeFormat writes the block in the given serialization, followed by a newline.
+func (b *block) writeFormat(w io.Writer, format string) error {
+	var out []byte
+	var err error
+	switch format {
+	case "dag-json":
+		out, err = ipld.Encode(b.node, dagjson.Encode)
+	case "general":
+		out, err = dagjose.MarshalGeneralJSON(b.node)
+	case "flattened":
+		out, err = dagjose.MarshalFlattenedJSON(b.node)
+	case "compact":
+		var compact string
+		if b.jwe != nil {
+			compact, err = dagjose.CompactJWE(b.node)
+		} else {
+			compact, err = dagjose.CompactJWS(b.node)
+		}
+		out = []byte(compact)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// writeSummary writes the CID and type of the block, and its protected headers pretty-printed.
+func (b *block) writeSummary(w io.Writer) error {
+	fmt.Fprintf(w, "CID: %s\n", b.cid)
+	if b.jwe != nil {
+		fmt.Fprintln(w, "Type: JWE")
+		fmt.Fprintf(w, "Recipients: %d\n", len(b.jwe.Recipients))
+		return writeProtected(w, "Protected header", b.jwe.Protected)
+	}
+	fmt.Fprintln(w, "Type: JWS")
+	if b.jws.Payload.Defined() {
+		fmt.Fprintf(w, "Payload: %s\n", b.jws.Payload)
+	} else {
+		fmt.Fprintln(w, "Payload: detached")
+	}
+	fmt.Fprintf(w, "Signatures: %d\n", len(b.jws.Signatures))
+	for idx, signature := range b.jws.Signatures {
+		if err := writeProtected(w, fmt.Sprintf("Signature %d protected header", idx), signature.Protected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProtected pretty-prints a JSON-encoded protected header, if present.
+func writeProtected(w io.Writer, title string, protected []byte) error {
+	if protected == nil {
+		return nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, protected, "", "  "); err != nil {
+		return fmt.Errorf("%s is not valid JSON: %v", title, err)
+	}
+	_, err := fmt.Fprintf(w, "%s:\n%s\n", title, indented.Bytes())
+	return err
+}
+
+func runInspect(args []string, s streams) error {
+	fs := newFlagSet(s, "inspect", inspectUsage)
+	format := fs.String("format", "dag-json", "serialization to print after the summary, or none")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	} else if *format != "none" && !formats[*format] {
+		return fmt.Errorf("unknown format %q", *format)
+	} else if data, err := readInput(fs, s.stdin); err != nil {
+		return err
+	} else if b, err := decodeBlock(data); err != nil {
+		return err
+	} else if err := b.writeSummary(s.stdout); err != nil {
+		return err
+	} else if *format != "none" {
+		fmt.Fprintln(s.stdout)
+		return b.writeFormat(s.stdout, *format)
+	}
+	return nil
+}
+
+func runConvert(args []string, s streams) error {
+	fs := newFlagSet(s, "convert", convertUsage)
+	format := fs.String("format", "", "serialization to print")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	} else if !formats[*format] {
+		fs.Usage()
+		return errUsage
+	} else if data, err := readInput(fs, s.stdin); err != nil {
+		return err
+	} else if b, err := decodeBlock(data); err != nil {
+		return err
+	} else {
+		return b.writeFormat(s.stdout, *format)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	gojose "github.com/go-jose/go-jose/v4"
+)
+
+// readJWK reads a JSON Web Key from the named file.
+func readJWK(name string) (*gojose.JSONWebKey, error) {
+	jwk := &gojose.JSONWebKey{}
+	if data, err := os.ReadFile(name); err != nil {
+		return nil, err
+	} else if err := jwk.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return jwk, nil
+}
+
+// readJWKs reads a JSON Web Key from each of the named files.
+func readJWKs(names []string) ([]*gojose.JSONWebKey, error) {
+	jwks := make([]*gojose.JSONWebKey, len(names))
+	for idx, name := range names {
+		var err error
+		if jwks[idx], err = readJWK(name); err != nil {
+			return nil, err
+		}
+	}
+	return jwks, nil
+}
+
+// readJWKSet reads a JSON Web Key Set from the named file.
+func readJWKSet(name string) (*gojose.JSONWebKeySet, error) {
+	keySet := &gojose.JSONWebKeySet{}
+	if data, err := os.ReadFile(name); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, keySet); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return keySet, nil
+}
@@ -0,0 +1,175 @@
+// Command dagjose inspects, converts, signs, verifies, encrypts and decrypts dag-jose blocks.
+//
+// Usage:
+//
+//	dagjose <command> [flags] [file]
+//
+// Blocks are read from the given file, or from stdin if no file or "-" is given. Run `dagjose help` for a list of
+// commands.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking/cid"
+
+	"github.com/ceramicnetwork/go-dag-jose/dagjose"
+)
+
+// command is a subcommand of the tool, run with the arguments following its name.
+type command struct {
+	usage string
+	run   func(args []string, s streams) error
+}
+
+// streams are the standard streams of the tool.
+type streams struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = map[string]command{
+	"inspect": {inspectUsage, runInspect},
+	"convert": {convertUsage, runConvert},
+	"sign":    {signUsage, runSign},
+	"verify":  {verifyUsage, runVerify},
+	"encrypt": {encryptUsage, runEncrypt},
+	"decrypt": {decryptUsage, runDecrypt},
+}
+
+// errUsage is returned when the command line is invalid, after the usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); errors.Is(err, errUsage) {
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "dagjose:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return errUsage
+	} else if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		printUsage(stdout)
+		return nil
+	} else if cmd, found := commands[args[0]]; !found {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		printUsage(stderr)
+		return errUsage
+	} else {
+		return cmd.run(args[1:], streams{stdin, stdout, stderr})
+	}
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  dagjose %s %s\n", name, commands[name].usage)
+	}
+}
+
+// newFlagSet returns a flag set for the named command that reports errors to stderr instead of exiting.
+func newFlagSet(s streams, name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet("dagjose "+name, flag.ContinueOnError)
+	fs.SetOutput(s.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: dagjose %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the arguments of a command, returning errUsage for invalid flags after the flag set has reported
+// them.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+// readInput reads the whole of the file named by the only remaining argument, or stdin if there is none or it is "-".
+func readInput(fs *flag.FlagSet, stdin io.Reader) ([]byte, error) {
+	switch fs.NArg() {
+	case 0:
+		return io.ReadAll(stdin)
+	case 1:
+		if fs.Arg(0) == "-" {
+			return io.ReadAll(stdin)
+		}
+		return os.ReadFile(fs.Arg(0))
+	default:
+		fs.Usage()
+		return nil, errUsage
+	}
+}
+
+// writeOutput writes data to the named file, or to stdout if the name is empty or "-".
+func writeOutput(s streams, name string, data []byte) error {
+	if name == "" || name == "-" {
+		_, err := s.stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(name, data, 0o644)
+}
+
+// encodeBlock encodes a dag-jose object the way services storing it through a LinkSystem do, i.e. with the encoder
+// registered for the dag-jose multicodec and the default dag-jose link prototype, and returns the block with its CID.
+func encodeBlock(n datamodel.Node) (cid.Cid, []byte, error) {
+	var block bytes.Buffer
+	linkSystem := cidlink.DefaultLinkSystem()
+	linkSystem.StorageWriteOpener = func(ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		return &block, func(ipld.Link) error { return nil }, nil
+	}
+	if lnk, err := dagjose.StoreJOSE(ipld.LinkContext{}, n, linkSystem); err != nil {
+		return cid.Undef, nil, err
+	} else {
+		return lnk.(cidlink.Link).Cid, block.Bytes(), nil
+	}
+}
+
+// writeBlock writes a block to the named file and its CID to stdout, or the block to stdout and its CID to stderr if
+// the name is empty or "-", so that the block can be piped.
+func writeBlock(s streams, name string, blockCid cid.Cid, block []byte) error {
+	if err := writeOutput(s, name, block); err != nil {
+		return err
+	} else if name == "" || name == "-" {
+		_, err = fmt.Fprintln(s.stderr, blockCid)
+		return err
+	} else {
+		_, err = fmt.Fprintln(s.stdout, blockCid)
+		return err
+	}
+}
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/ceramicnetwork/go-dag-jose/dagjose"
+)
+
+func testPayload(t *testing.T) cid.Cid {
+	payload, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("payload"))
+	require.NoError(t, err)
+	return payload
+}
+
+func testPayloadOther(t *testing.T) cid.Cid {
+	payload, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("other"))
+	require.NoError(t, err)
+	return payload
+}
+
+func testJWSBlock(t *testing.T) []byte {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := dagjose.SignJWS(testPayload(t), dagjose.SigningKey{Key: edKey, KeyID: "key-1"})
+	require.NoError(t, err)
+	block, err := ipld.Encode(jws, dagjose.Encode)
+	require.NoError(t, err)
+	return block
+}
+
+func runCommand(t *testing.T, stdin []byte, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, bytes.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestInspectJWS(t *testing.T) {
+	block := testJWSBlock(t)
+	blockCid, err := dagjose.LinkPrototype.Sum(block)
+	require.NoError(t, err)
+	out, err := runCommand(t, block, "inspect")
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		"CID: " + blockCid.String(),
+		"Type: JWS",
+		"Payload: " + testPayload(t).String(),
+		"Signatures: 1",
+		"Signature 0 protected header:",
+		"{",
+		`  "alg": "EdDSA",`,
+		`  "kid": "key-1"`,
+		"}",
+		"",
+		"",
+	}, "\n"), out[:strings.Index(out, "{\"payload\"")])
+	require.True(t, strings.HasPrefix(blockCid.String(), "bagcq"))
+}
+
+func TestInspectJWE(t *testing.T) {
+	jwe, err := dagjose.Encrypt(
+		fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("hello").AssignString("world")
+		}),
+		"A256GCM",
+		dagjose.RecipientKey{Key: make([]byte, 32)},
+	)
+	require.NoError(t, err)
+	block, err := ipld.Encode(jwe, dagjose.Encode)
+	require.NoError(t, err)
+	out, err := runCommand(t, block, "inspect", "-format", "none")
+	require.NoError(t, err)
+	require.Contains(t, out, "Type: JWE\nRecipients: 0\nProtected header:\n{\n  \"alg\": \"dir\",\n")
+}
+
+func TestConvert(t *testing.T) {
+	block := testJWSBlock(t)
+	n, err := ipld.Decode(block, dagjose.DecodeOptions{}.Decode)
+	require.NoError(t, err)
+
+	general, err := dagjose.MarshalGeneralJSON(n)
+	require.NoError(t, err)
+	out, err := runCommand(t, block, "convert", "-format", "general")
+	require.NoError(t, err)
+	require.Equal(t, string(general)+"\n", out)
+
+	flattened, err := dagjose.MarshalFlattenedJSON(n)
+	require.NoError(t, err)
+	out, err = runCommand(t, block, "convert", "-format", "flattened")
+	require.NoError(t, err)
+	require.Equal(t, string(flattened)+"\n", out)
+
+	compact, err := dagjose.CompactJWS(n)
+	require.NoError(t, err)
+	out, err = runCommand(t, block, "convert", "-format", "compact", "-")
+	require.NoError(t, err)
+	require.Equal(t, compact+"\n", out)
+
+	// The dag-json output decodes to the same node
+	out, err = runCommand(t, block, "convert", "-format", "dag-json")
+	require.NoError(t, err)
+	fromJSON, err := ipld.Decode([]byte(out), dagjson.Decode)
+	require.NoError(t, err)
+	reencoded, err := ipld.Encode(fromJSON, dagjose.Encode)
+	require.NoError(t, err)
+	require.Equal(t, block, reencoded)
+}
+
+func TestUsageErrors(t *testing.T) {
+	_, err := runCommand(t, nil)
+	require.ErrorIs(t, err, errUsage)
+	_, err = runCommand(t, nil, "frobnicate")
+	require.ErrorIs(t, err, errUsage)
+	_, err = runCommand(t, nil, "convert")
+	require.ErrorIs(t, err, errUsage)
+	_, err = runCommand(t, nil, "inspect", "a", "b")
+	require.ErrorIs(t, err, errUsage)
+	_, err = runCommand(t, nil, "inspect", "-format", "yaml")
+	require.ErrorContains(t, err, "unknown format")
+	_, err = runCommand(t, []byte("not a block"), "inspect")
+	require.Error(t, err)
+}
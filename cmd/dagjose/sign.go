@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+
+	"github.com/ceramicnetwork/go-dag-jose/dagjose"
+)
+
+const (
+	signUsage   = "-key jwk-file [-key jwk-file]... [-alg alg] [-unencoded] [-o file] cid"
+	verifyUsage = "[-key jwk-file]... [-jwks jwk-set-file] [-did did:key]... [-payload cid] [file]"
+)
+
+func runSign(args []string, s streams) error {
+	fs := newFlagSet(s, "sign", signUsage)
+	var keyFiles stringList
+	fs.Var(&keyFiles, "key", "private JWK to sign with, may be repeated for several signatures")
+	alg := fs.String("alg", "", "signature algorithm, inferred from each key if empty")
+	unencoded := fs.Bool("unencoded", false, "sign the raw CID bytes with b64: false (RFC 7797)")
+	out := fs.String("o", "", "file to write the JWS block to, stdout if empty")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(keyFiles) == 0 || fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	payload, err := cid.Decode(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid payload CID: %v", err)
+	}
+	jwks, err := readJWKs(keyFiles)
+	if err != nil {
+		return err
+	}
+	signingKeys := make([]dagjose.SigningKey, len(jwks))
+	for idx, jwk := range jwks {
+		signingKeys[idx] = dagjose.SigningKey{Key: jwk, Algorithm: *alg, UnencodedPayload: *unencoded}
+	}
+	if jws, err := dagjose.SignJWS(payload, signingKeys...); err != nil {
+		return err
+	} else if blockCid, block, err := encodeBlock(jws); err != nil {
+		return err
+	} else {
+		return writeBlock(s, *out, blockCid, block)
+	}
+}
+
+func runVerify(args []string, s streams) error {
+	fs := newFlagSet(s, "verify", verifyUsage)
+	var keyFiles, dids stringList
+	fs.Var(&keyFiles, "key", "public JWK to verify with, may be repeated")
+	fs.Var(&dids, "did", "did:key identifier to verify with, may be repeated; did:key `kid` parameters must be one of them")
+	jwksFile := fs.String("jwks", "", "JWK set to verify with, keys being selected by `kid`")
+	payloadFlag := fs.String("payload", "", "payload CID of a JWS with a detached payload")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	var cfg dagjose.VerifyOptions
+	if jwks, err := readJWKs(keyFiles); err != nil {
+		return err
+	} else {
+		for _, jwk := range jwks {
+			cfg.Keys = append(cfg.Keys, jwk)
+		}
+	}
+	trusted := make(map[string]bool, len(dids))
+	for _, did := range dids {
+		if key, err := dagjose.ParseDIDKey(did); err != nil {
+			return err
+		} else {
+			cfg.Keys = append(cfg.Keys, key)
+			trusted[stripFragment(did)] = true
+		}
+	}
+	if len(dids) > 0 {
+		// A did:key `kid` carries its own key, so it is only resolved when it is one of the given identifiers,
+		// otherwise anyone could sign a block that verifies
+		cfg.Resolver = dagjose.KeyResolverFunc(func(kid string) ([]interface{}, error) {
+			if !strings.HasPrefix(kid, "did:key:") {
+				return nil, nil
+			} else if !trusted[stripFragment(kid)] {
+				return nil, fmt.Errorf("%s is not one of the given did:key identifiers", stripFragment(kid))
+			}
+			return dagjose.DIDKeyResolver{}.ResolveKey(kid)
+		})
+	}
+	if *jwksFile != "" {
+		var err error
+		if cfg.KeySet, err = readJWKSet(*jwksFile); err != nil {
+			return err
+		}
+	}
+	data, err := readInput(fs, s.stdin)
+	if err != nil {
+		return err
+	}
+	results, err := verifyBlock(cfg, data, *payloadFlag)
+	if err != nil {
+		return err
+	}
+	invalid := 0
+	for _, result := range results {
+		fmt.Fprintf(s.stdout, "signature %d: alg=%s kid=%s: ", result.Index, result.Algorithm, result.KeyID)
+		if result.Valid() {
+			fmt.Fprintln(s.stdout, "valid")
+		} else {
+			fmt.Fprintf(s.stdout, "invalid: %v\n", result.Err)
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d signatures could not be verified", invalid, len(results))
+	}
+	return nil
+}
+
+// stripFragment returns a DID URL without its fragment, i.e. the DID it refers to.
+func stripFragment(didURL string) string {
+	if fragment := strings.IndexByte(didURL, '#'); fragment >= 0 {
+		return didURL[:fragment]
+	}
+	return didURL
+}
+
+// verifyBlock decodes a JWS block and verifies its signatures. The payload CID must be given for a JWS with a detached
+// payload, and must match the payload of any other JWS.
+func verifyBlock(cfg dagjose.VerifyOptions, data []byte, payloadFlag string) ([]dagjose.SignatureResult, error) {
+	n, err := ipld.Decode(data, dagjose.DecodeOptions{DetachedPayload: payloadFlag != ""}.Decode)
+	if err != nil {
+		return nil, err
+	} else if _, err := n.LookupByString("ciphertext"); err == nil {
+		return nil, errors.New("block is a JWE, not a JWS")
+	}
+	jws, err := dagjose.AsJWS(n)
+	if err != nil {
+		return nil, err
+	} else if payloadFlag == "" {
+		return cfg.Verify(n)
+	}
+	payload, err := cid.Decode(payloadFlag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload CID: %v", err)
+	} else if jws.Payload.Defined() && !jws.Payload.Equals(payload) {
+		return nil, fmt.Errorf("JWS payload %s does not match %s", jws.Payload, payload)
+	}
+	return cfg.VerifyDetached(payload, n)
+}
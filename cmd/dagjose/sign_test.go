@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ceramicnetwork/go-dag-jose/dagjose"
+)
+
+// writeJSON writes the JSON encoding of v to a new file in dir and returns its name.
+func writeJSON(t *testing.T, dir string, name string, v interface{}) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	name = filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+	return name
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jwk := gojose.JSONWebKey{Key: edKey, KeyID: "key-1"}
+	privateFile := writeJSON(t, dir, "private.jwk", jwk)
+	publicFile := writeJSON(t, dir, "public.jwk", jwk.Public())
+	payload := testPayload(t)
+
+	// The block is identical to the one encoded through the dag-jose multicodec, since ed25519 signatures are
+	// deterministic
+	blockFile := filepath.Join(dir, "block.jose")
+	out, err := runCommand(t, nil, "sign", "-key", privateFile, "-o", blockFile, payload.String())
+	require.NoError(t, err)
+	block, err := os.ReadFile(blockFile)
+	require.NoError(t, err)
+	jws, err := dagjose.SignJWS(payload, dagjose.SigningKey{Key: edKey, KeyID: "key-1"})
+	require.NoError(t, err)
+	expected, err := ipld.Encode(jws, dagjose.Encode)
+	require.NoError(t, err)
+	require.Equal(t, expected, block)
+	blockCid, err := dagjose.LinkPrototype.Sum(block)
+	require.NoError(t, err)
+	require.Equal(t, blockCid.String()+"\n", out)
+
+	// Without -o, the block is written to stdout and the CID to stderr
+	var stdout, stderr bytes.Buffer
+	require.NoError(t, run([]string{"sign", "-key", privateFile, payload.String()}, nil, &stdout, &stderr))
+	require.Equal(t, block, stdout.Bytes())
+	require.Equal(t, blockCid.String()+"\n", stderr.String())
+
+	out, err = runCommand(t, block, "verify", "-key", publicFile)
+	require.NoError(t, err)
+	require.Equal(t, "signature 0: alg=EdDSA kid=key-1: valid\n", out)
+	keySetFile := writeJSON(t, dir, "keys.jwks", gojose.JSONWebKeySet{Keys: []gojose.JSONWebKey{jwk.Public()}})
+	out, err = runCommand(t, nil, "verify", "-jwks", keySetFile, blockFile)
+	require.NoError(t, err)
+	require.Equal(t, "signature 0: alg=EdDSA kid=key-1: valid\n", out)
+
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	otherFile := writeJSON(t, dir, "other.jwk", gojose.JSONWebKey{Key: otherKey.Public()})
+	out, err = runCommand(t, block, "verify", "-key", otherFile)
+	require.ErrorContains(t, err, "1 of 1 signatures could not be verified")
+	require.Equal(t, "signature 0: alg=EdDSA kid=key-1: invalid: invalid signature\n", out)
+	_, err = runCommand(t, block, "verify", "-key", publicFile, "-payload", testPayloadOther(t).String())
+	require.ErrorContains(t, err, "does not match")
+}
+
+// Signatures are verified against did:key identifiers, and a did:key `kid` only against the given identifiers
+func TestVerifyDIDKey(t *testing.T) {
+	dir := t.TempDir()
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	did, err := dagjose.DIDKey(edKey.Public())
+	require.NoError(t, err)
+	privateFile := writeJSON(t, dir, "private.jwk", gojose.JSONWebKey{Key: edKey})
+
+	block, err := runCommand(t, nil, "sign", "-key", privateFile, testPayload(t).String())
+	require.NoError(t, err)
+	out, err := runCommand(t, []byte(block), "verify", "-did", did)
+	require.NoError(t, err)
+	require.Equal(t, "signature 0: alg=EdDSA kid=: valid\n", out)
+
+	jws, err := dagjose.SignJWS(testPayload(t), dagjose.SigningKey{Key: edKey, KeyID: did + "#" + did[len("did:key:"):]})
+	require.NoError(t, err)
+	didBlock, err := ipld.Encode(jws, dagjose.Encode)
+	require.NoError(t, err)
+	_, err = runCommand(t, didBlock, "verify", "-did", did)
+	require.NoError(t, err)
+
+	// A self-signed did:key JWS must not verify without a key, nor with another did:key identifier
+	_, err = runCommand(t, didBlock, "verify")
+	require.ErrorContains(t, err, "1 of 1 signatures could not be verified")
+	otherDID, err := dagjose.DIDKey(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize)).Public())
+	require.NoError(t, err)
+	out, err = runCommand(t, didBlock, "verify", "-did", otherDID)
+	require.ErrorContains(t, err, "1 of 1 signatures could not be verified")
+	require.Contains(t, out, did+" is not one of the given did:key identifiers")
+
+	// A JWS with a detached payload is verified against the given payload CID
+	detached, err := ipld.Encode(jws, dagjose.EncodeOptions{DetachedPayload: true}.Encode)
+	require.NoError(t, err)
+	_, err = runCommand(t, detached, "verify", "-did", did)
+	require.ErrorIs(t, err, dagjose.ErrInvalidJWS)
+	_, err = runCommand(t, detached, "verify", "-did", did, "-payload", testPayload(t).String())
+	require.NoError(t, err)
+	_, err = runCommand(t, detached, "verify", "-did", did, "-payload", testPayloadOther(t).String())
+	require.ErrorContains(t, err, "1 of 1 signatures could not be verified")
+}
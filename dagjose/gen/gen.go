@@ -15,32 +15,40 @@ func main() {
 
 	// -- Common types -->
 
+	ts.Accumulate(schema.SpawnBool("Bool"))
 	ts.Accumulate(schema.SpawnString("String"))
 	ts.Accumulate(schema.SpawnBytes("Bytes"))
 	ts.Accumulate(schema.SpawnInt("Int"))
 	ts.Accumulate(schema.SpawnFloat("Float"))
 	ts.Accumulate(schema.SpawnLink("Link"))
-	ts.Accumulate(schema.SpawnMap("Map", "String", "Any", false))
-	ts.Accumulate(schema.SpawnList("List", "Any", false))
+	// Map values and list elements are nullable so that, together with the `Any` union, every data model kind can be
+	// represented in headers. The generated `_Any__ReprAssembler.AssignNull` has a (surgical) modification that accepts
+	// null where the union is nullable, which the generator does not support for kinded unions.
+	ts.Accumulate(schema.SpawnMap("Map", "String", "Any", true))
+	ts.Accumulate(schema.SpawnList("List", "Any", true))
 
 	// The `Any` union represents a wildcard nested type that can contain any type of scalar or recursive information
 	// including itself (as map values or list elements).
 	ts.Accumulate(schema.SpawnUnion("Any",
 		[]schema.TypeName{
+			"Bool",
 			"String",
 			"Bytes",
 			"Int",
 			"Float",
 			"Map",
 			"List",
+			"Link",
 		},
 		schema.SpawnUnionRepresentationKinded(map[datamodel.Kind]schema.TypeName{
+			datamodel.Kind_Bool:   "Bool",
 			datamodel.Kind_String: "String",
 			datamodel.Kind_Bytes:  "Bytes",
 			datamodel.Kind_Int:    "Int",
 			datamodel.Kind_Float:  "Float",
 			datamodel.Kind_Map:    "Map",
 			datamodel.Kind_List:   "List",
+			datamodel.Kind_Link:   "Link",
 		}),
 	))
 
@@ -47,12 +47,14 @@ func (m MaybeAny) Must() Any {
 }
 
 var (
+	memberName__Any_Bool   = _String{"Bool"}
 	memberName__Any_String = _String{"String"}
 	memberName__Any_Bytes  = _String{"Bytes"}
 	memberName__Any_Int    = _String{"Int"}
 	memberName__Any_Float  = _String{"Float"}
 	memberName__Any_Map    = _String{"Map"}
 	memberName__Any_List   = _String{"List"}
+	memberName__Any_Link   = _String{"Link"}
 )
 var _ datamodel.Node = (Any)(&_Any{})
 var _ schema.TypedNode = (Any)(&_Any{})
@@ -62,6 +64,12 @@ func (Any) Kind() datamodel.Kind {
 }
 func (n Any) LookupByString(key string) (datamodel.Node, error) {
 	switch key {
+	case "Bool":
+		if n2, ok := n.x.(Bool); ok {
+			return n2, nil
+		} else {
+			return nil, datamodel.ErrNotExists{Segment: datamodel.PathSegmentOfString(key)}
+		}
 	case "String":
 		if n2, ok := n.x.(String); ok {
 			return n2, nil
@@ -98,6 +106,12 @@ func (n Any) LookupByString(key string) (datamodel.Node, error) {
 		} else {
 			return nil, datamodel.ErrNotExists{Segment: datamodel.PathSegmentOfString(key)}
 		}
+	case "Link":
+		if n2, ok := n.x.(Link); ok {
+			return n2, nil
+		} else {
+			return nil, datamodel.ErrNotExists{Segment: datamodel.PathSegmentOfString(key)}
+		}
 	default:
 		return nil, schema.ErrNoSuchField{Type: nil /*TODO*/, Field: datamodel.PathSegmentOfString(key)}
 	}
@@ -129,6 +143,8 @@ func (itr *_Any__MapItr) Next() (k datamodel.Node, v datamodel.Node, _ error) {
 		return nil, nil, datamodel.ErrIteratorOverread{}
 	}
 	switch n2 := itr.n.x.(type) {
+	case Bool:
+		k, v = &memberName__Any_Bool, n2
 	case String:
 		k, v = &memberName__Any_String, n2
 	case Bytes:
@@ -141,6 +157,8 @@ func (itr *_Any__MapItr) Next() (k datamodel.Node, v datamodel.Node, _ error) {
 		k, v = &memberName__Any_Map, n2
 	case List:
 		k, v = &memberName__Any_List, n2
+	case Link:
+		k, v = &memberName__Any_Link, n2
 	default:
 		panic("unreachable")
 	}
@@ -215,17 +233,21 @@ type _Any__Assembler struct {
 	state maState
 
 	cm  schema.Maybe
-	ca1 *_String__Assembler
+	ca1 *_Bool__Assembler
+
+	ca2 *_String__Assembler
+
+	ca3 *_Bytes__Assembler
 
-	ca2 *_Bytes__Assembler
+	ca4 *_Int__Assembler
 
-	ca3 *_Int__Assembler
+	ca5 *_Float__Assembler
 
-	ca4 *_Float__Assembler
+	ca6 *_Map__Assembler
 
-	ca5 *_Map__Assembler
+	ca7 *_List__Assembler
 
-	ca6 *_List__Assembler
+	ca8 *_Link__Assembler
 	ca  uint
 }
 
@@ -251,6 +273,12 @@ func (na *_Any__Assembler) reset() {
 
 	case 6:
 		na.ca6.reset()
+
+	case 7:
+		na.ca7.reset()
+
+	case 8:
+		na.ca8.reset()
 	default:
 		panic("unreachable")
 	}
@@ -374,72 +402,94 @@ func (ma *_Any__Assembler) AssembleEntry(k string) (datamodel.NodeAssembler, err
 		return nil, schema.ErrNotUnionStructure{TypeName: "dagjose.Any", Detail: "cannot add another entry -- a union can only contain one thing!"}
 	}
 	switch k {
-	case "String":
+	case "Bool":
 		ma.state = maState_midValue
 		ma.ca = 1
-		x := &_String{}
+		x := &_Bool{}
 		ma.w.x = x
 		if ma.ca1 == nil {
-			ma.ca1 = &_String__Assembler{}
+			ma.ca1 = &_Bool__Assembler{}
 		}
 		ma.ca1.w = x
 		ma.ca1.m = &ma.cm
 		return ma.ca1, nil
-	case "Bytes":
+	case "String":
 		ma.state = maState_midValue
 		ma.ca = 2
-		x := &_Bytes{}
+		x := &_String{}
 		ma.w.x = x
 		if ma.ca2 == nil {
-			ma.ca2 = &_Bytes__Assembler{}
+			ma.ca2 = &_String__Assembler{}
 		}
 		ma.ca2.w = x
 		ma.ca2.m = &ma.cm
 		return ma.ca2, nil
-	case "Int":
+	case "Bytes":
 		ma.state = maState_midValue
 		ma.ca = 3
-		x := &_Int{}
+		x := &_Bytes{}
 		ma.w.x = x
 		if ma.ca3 == nil {
-			ma.ca3 = &_Int__Assembler{}
+			ma.ca3 = &_Bytes__Assembler{}
 		}
 		ma.ca3.w = x
 		ma.ca3.m = &ma.cm
 		return ma.ca3, nil
-	case "Float":
+	case "Int":
 		ma.state = maState_midValue
 		ma.ca = 4
-		x := &_Float{}
+		x := &_Int{}
 		ma.w.x = x
 		if ma.ca4 == nil {
-			ma.ca4 = &_Float__Assembler{}
+			ma.ca4 = &_Int__Assembler{}
 		}
 		ma.ca4.w = x
 		ma.ca4.m = &ma.cm
 		return ma.ca4, nil
-	case "Map":
+	case "Float":
 		ma.state = maState_midValue
 		ma.ca = 5
-		x := &_Map{}
+		x := &_Float{}
 		ma.w.x = x
 		if ma.ca5 == nil {
-			ma.ca5 = &_Map__Assembler{}
+			ma.ca5 = &_Float__Assembler{}
 		}
 		ma.ca5.w = x
 		ma.ca5.m = &ma.cm
 		return ma.ca5, nil
-	case "List":
+	case "Map":
 		ma.state = maState_midValue
 		ma.ca = 6
-		x := &_List{}
+		x := &_Map{}
 		ma.w.x = x
 		if ma.ca6 == nil {
-			ma.ca6 = &_List__Assembler{}
+			ma.ca6 = &_Map__Assembler{}
 		}
 		ma.ca6.w = x
 		ma.ca6.m = &ma.cm
 		return ma.ca6, nil
+	case "List":
+		ma.state = maState_midValue
+		ma.ca = 7
+		x := &_List{}
+		ma.w.x = x
+		if ma.ca7 == nil {
+			ma.ca7 = &_List__Assembler{}
+		}
+		ma.ca7.w = x
+		ma.ca7.m = &ma.cm
+		return ma.ca7, nil
+	case "Link":
+		ma.state = maState_midValue
+		ma.ca = 8
+		x := &_Link{}
+		ma.w.x = x
+		if ma.ca8 == nil {
+			ma.ca8 = &_Link__Assembler{}
+		}
+		ma.ca8.w = x
+		ma.ca8.m = &ma.cm
+		return ma.ca8, nil
 	}
 	return nil, schema.ErrInvalidKey{TypeName: "dagjose.Any", Key: &_String{k}}
 }
@@ -477,59 +527,77 @@ func (ma *_Any__Assembler) AssembleValue() datamodel.NodeAssembler {
 	ma.state = maState_midValue
 	switch ma.ca {
 	case 1:
-		x := &_String{}
+		x := &_Bool{}
 		ma.w.x = x
 		if ma.ca1 == nil {
-			ma.ca1 = &_String__Assembler{}
+			ma.ca1 = &_Bool__Assembler{}
 		}
 		ma.ca1.w = x
 		ma.ca1.m = &ma.cm
 		return ma.ca1
 	case 2:
-		x := &_Bytes{}
+		x := &_String{}
 		ma.w.x = x
 		if ma.ca2 == nil {
-			ma.ca2 = &_Bytes__Assembler{}
+			ma.ca2 = &_String__Assembler{}
 		}
 		ma.ca2.w = x
 		ma.ca2.m = &ma.cm
 		return ma.ca2
 	case 3:
-		x := &_Int{}
+		x := &_Bytes{}
 		ma.w.x = x
 		if ma.ca3 == nil {
-			ma.ca3 = &_Int__Assembler{}
+			ma.ca3 = &_Bytes__Assembler{}
 		}
 		ma.ca3.w = x
 		ma.ca3.m = &ma.cm
 		return ma.ca3
 	case 4:
-		x := &_Float{}
+		x := &_Int{}
 		ma.w.x = x
 		if ma.ca4 == nil {
-			ma.ca4 = &_Float__Assembler{}
+			ma.ca4 = &_Int__Assembler{}
 		}
 		ma.ca4.w = x
 		ma.ca4.m = &ma.cm
 		return ma.ca4
 	case 5:
-		x := &_Map{}
+		x := &_Float{}
 		ma.w.x = x
 		if ma.ca5 == nil {
-			ma.ca5 = &_Map__Assembler{}
+			ma.ca5 = &_Float__Assembler{}
 		}
 		ma.ca5.w = x
 		ma.ca5.m = &ma.cm
 		return ma.ca5
 	case 6:
-		x := &_List{}
+		x := &_Map{}
 		ma.w.x = x
 		if ma.ca6 == nil {
-			ma.ca6 = &_List__Assembler{}
+			ma.ca6 = &_Map__Assembler{}
 		}
 		ma.ca6.w = x
 		ma.ca6.m = &ma.cm
 		return ma.ca6
+	case 7:
+		x := &_List{}
+		ma.w.x = x
+		if ma.ca7 == nil {
+			ma.ca7 = &_List__Assembler{}
+		}
+		ma.ca7.w = x
+		ma.ca7.m = &ma.cm
+		return ma.ca7
+	case 8:
+		x := &_Link{}
+		ma.w.x = x
+		if ma.ca8 == nil {
+			ma.ca8 = &_Link__Assembler{}
+		}
+		ma.ca8.w = x
+		ma.ca8.m = &ma.cm
+		return ma.ca8
 	default:
 		panic("unreachable")
 	}
@@ -561,6 +629,8 @@ func (ma *_Any__Assembler) KeyPrototype() datamodel.NodePrototype {
 }
 func (ma *_Any__Assembler) ValuePrototype(k string) datamodel.NodePrototype {
 	switch k {
+	case "Bool":
+		return _Bool__Prototype{}
 	case "String":
 		return _String__Prototype{}
 	case "Bytes":
@@ -573,6 +643,8 @@ func (ma *_Any__Assembler) ValuePrototype(k string) datamodel.NodePrototype {
 		return _Map__Prototype{}
 	case "List":
 		return _List__Prototype{}
+	case "Link":
+		return _Link__Prototype{}
 	default:
 		return nil
 	}
@@ -606,30 +678,38 @@ func (ka *_Any__KeyAssembler) AssignString(k string) error {
 		return schema.ErrNotUnionStructure{TypeName: "dagjose.Any", Detail: "cannot add another entry -- a union can only contain one thing!"}
 	}
 	switch k {
-	case "String":
+	case "Bool":
 		ka.ca = 1
 		ka.state = maState_expectValue
 		return nil
-	case "Bytes":
+	case "String":
 		ka.ca = 2
 		ka.state = maState_expectValue
 		return nil
-	case "Int":
+	case "Bytes":
 		ka.ca = 3
 		ka.state = maState_expectValue
 		return nil
-	case "Float":
+	case "Int":
 		ka.ca = 4
 		ka.state = maState_expectValue
 		return nil
-	case "Map":
+	case "Float":
 		ka.ca = 5
 		ka.state = maState_expectValue
 		return nil
-	case "List":
+	case "Map":
 		ka.ca = 6
 		ka.state = maState_expectValue
 		return nil
+	case "List":
+		ka.ca = 7
+		ka.state = maState_expectValue
+		return nil
+	case "Link":
+		ka.ca = 8
+		ka.state = maState_expectValue
+		return nil
 	}
 	return schema.ErrInvalidKey{TypeName: "dagjose.Any", Key: &_String{k}} // TODO: error quality: ErrInvalidUnionDiscriminant ?
 }
@@ -662,6 +742,8 @@ var _ datamodel.Node = &_Any__Repr{}
 
 func (n *_Any__Repr) Kind() datamodel.Kind {
 	switch n.x.(type) {
+	case Bool:
+		return datamodel.Kind_Bool
 	case String:
 		return datamodel.Kind_String
 	case Bytes:
@@ -674,6 +756,8 @@ func (n *_Any__Repr) Kind() datamodel.Kind {
 		return datamodel.Kind_Map
 	case List:
 		return datamodel.Kind_List
+	case Link:
+		return datamodel.Kind_Link
 	default:
 		panic("unreachable")
 	}
@@ -747,7 +831,12 @@ func (n *_Any__Repr) IsNull() bool {
 	return false
 }
 func (n *_Any__Repr) AsBool() (bool, error) {
-	return false, datamodel.ErrWrongKind{TypeName: "dagjose.Any.Repr", MethodName: "AsBool", AppropriateKind: datamodel.KindSet_JustBool, ActualKind: n.Kind()}
+	switch n2 := n.x.(type) {
+	case Bool:
+		return n2.Representation().AsBool()
+	default:
+		return false, datamodel.ErrWrongKind{TypeName: "dagjose.Any.Repr", MethodName: "AsBool", AppropriateKind: datamodel.KindSet_JustBool, ActualKind: n.Kind()}
+	}
 }
 func (n *_Any__Repr) AsInt() (int64, error) {
 	switch n2 := n.x.(type) {
@@ -782,7 +871,12 @@ func (n *_Any__Repr) AsBytes() ([]byte, error) {
 	}
 }
 func (n *_Any__Repr) AsLink() (datamodel.Link, error) {
-	return nil, datamodel.ErrWrongKind{TypeName: "dagjose.Any.Repr", MethodName: "AsLink", AppropriateKind: datamodel.KindSet_JustLink, ActualKind: n.Kind()}
+	switch n2 := n.x.(type) {
+	case Link:
+		return n2.Representation().AsLink()
+	default:
+		return nil, datamodel.ErrWrongKind{TypeName: "dagjose.Any.Repr", MethodName: "AsLink", AppropriateKind: datamodel.KindSet_JustLink, ActualKind: n.Kind()}
+	}
 }
 func (_Any__Repr) Prototype() datamodel.NodePrototype {
 	return _Any__ReprPrototype{}
@@ -815,12 +909,14 @@ func (nb *_Any__ReprBuilder) Reset() {
 type _Any__ReprAssembler struct {
 	w   *_Any
 	m   *schema.Maybe
-	ca1 *_String__ReprAssembler
-	ca2 *_Bytes__ReprAssembler
-	ca3 *_Int__ReprAssembler
-	ca4 *_Float__ReprAssembler
-	ca5 *_Map__ReprAssembler
-	ca6 *_List__ReprAssembler
+	ca1 *_Bool__ReprAssembler
+	ca2 *_String__ReprAssembler
+	ca3 *_Bytes__ReprAssembler
+	ca4 *_Int__ReprAssembler
+	ca5 *_Float__ReprAssembler
+	ca6 *_Map__ReprAssembler
+	ca7 *_List__ReprAssembler
+	ca8 *_Link__ReprAssembler
 	ca  uint
 }
 
@@ -840,6 +936,10 @@ func (na *_Any__ReprAssembler) reset() {
 		na.ca5.reset()
 	case 6:
 		na.ca6.reset()
+	case 7:
+		na.ca7.reset()
+	case 8:
+		na.ca8.reset()
 	default:
 		panic("unreachable")
 	}
@@ -855,15 +955,15 @@ func (na *_Any__ReprAssembler) BeginMap(sizeHint int64) (datamodel.MapAssembler,
 	if na.w == nil {
 		na.w = &_Any{}
 	}
-	na.ca = 5
+	na.ca = 6
 	x := &_Map{}
 	na.w.x = x
-	if na.ca5 == nil {
-		na.ca5 = &_Map__ReprAssembler{}
+	if na.ca6 == nil {
+		na.ca6 = &_Map__ReprAssembler{}
 	}
-	na.ca5.w = x
-	na.ca5.m = na.m
-	return na.ca5.BeginMap(sizeHint)
+	na.ca6.w = x
+	na.ca6.m = na.m
+	return na.ca6.BeginMap(sizeHint)
 }
 func (na *_Any__ReprAssembler) BeginList(sizeHint int64) (datamodel.ListAssembler, error) {
 	switch *na.m {
@@ -875,18 +975,21 @@ func (na *_Any__ReprAssembler) BeginList(sizeHint int64) (datamodel.ListAssemble
 	if na.w == nil {
 		na.w = &_Any{}
 	}
-	na.ca = 6
+	na.ca = 7
 	x := &_List{}
 	na.w.x = x
-	if na.ca6 == nil {
-		na.ca6 = &_List__ReprAssembler{}
+	if na.ca7 == nil {
+		na.ca7 = &_List__ReprAssembler{}
 	}
-	na.ca6.w = x
-	na.ca6.m = na.m
-	return na.ca6.BeginList(sizeHint)
+	na.ca7.w = x
+	na.ca7.m = na.m
+	return na.ca7.BeginList(sizeHint)
 }
 func (na *_Any__ReprAssembler) AssignNull() error {
 	switch *na.m {
+	case allowNull:
+		*na.m = schema.Maybe_Null
+		return nil
 	case schema.Maybe_Value, schema.Maybe_Null:
 		panic("invalid state: cannot assign into assembler that's already finished")
 	case midvalue:
@@ -901,7 +1004,18 @@ func (na *_Any__ReprAssembler) AssignBool(v bool) error {
 	case midvalue:
 		panic("invalid state: cannot assign into assembler that's already working on a larger structure!")
 	}
-	return schema.ErrNotUnionStructure{TypeName: "dagjose.Any.Repr", Detail: "AssignBool called but is not valid for any of the kinds that are valid members of this union"}
+	if na.w == nil {
+		na.w = &_Any{}
+	}
+	na.ca = 1
+	x := &_Bool{}
+	na.w.x = x
+	if na.ca1 == nil {
+		na.ca1 = &_Bool__ReprAssembler{}
+	}
+	na.ca1.w = x
+	na.ca1.m = na.m
+	return na.ca1.AssignBool(v)
 }
 func (na *_Any__ReprAssembler) AssignInt(v int64) error {
 	switch *na.m {
@@ -913,15 +1027,15 @@ func (na *_Any__ReprAssembler) AssignInt(v int64) error {
 	if na.w == nil {
 		na.w = &_Any{}
 	}
-	na.ca = 3
+	na.ca = 4
 	x := &_Int{}
 	na.w.x = x
-	if na.ca3 == nil {
-		na.ca3 = &_Int__ReprAssembler{}
+	if na.ca4 == nil {
+		na.ca4 = &_Int__ReprAssembler{}
 	}
-	na.ca3.w = x
-	na.ca3.m = na.m
-	return na.ca3.AssignInt(v)
+	na.ca4.w = x
+	na.ca4.m = na.m
+	return na.ca4.AssignInt(v)
 }
 func (na *_Any__ReprAssembler) AssignFloat(v float64) error {
 	switch *na.m {
@@ -933,15 +1047,15 @@ func (na *_Any__ReprAssembler) AssignFloat(v float64) error {
 	if na.w == nil {
 		na.w = &_Any{}
 	}
-	na.ca = 4
+	na.ca = 5
 	x := &_Float{}
 	na.w.x = x
-	if na.ca4 == nil {
-		na.ca4 = &_Float__ReprAssembler{}
+	if na.ca5 == nil {
+		na.ca5 = &_Float__ReprAssembler{}
 	}
-	na.ca4.w = x
-	na.ca4.m = na.m
-	return na.ca4.AssignFloat(v)
+	na.ca5.w = x
+	na.ca5.m = na.m
+	return na.ca5.AssignFloat(v)
 }
 func (na *_Any__ReprAssembler) AssignString(v string) error {
 	switch *na.m {
@@ -953,15 +1067,15 @@ func (na *_Any__ReprAssembler) AssignString(v string) error {
 	if na.w == nil {
 		na.w = &_Any{}
 	}
-	na.ca = 1
+	na.ca = 2
 	x := &_String{}
 	na.w.x = x
-	if na.ca1 == nil {
-		na.ca1 = &_String__ReprAssembler{}
+	if na.ca2 == nil {
+		na.ca2 = &_String__ReprAssembler{}
 	}
-	na.ca1.w = x
-	na.ca1.m = na.m
-	return na.ca1.AssignString(v)
+	na.ca2.w = x
+	na.ca2.m = na.m
+	return na.ca2.AssignString(v)
 }
 func (na *_Any__ReprAssembler) AssignBytes(v []byte) error {
 	switch *na.m {
@@ -973,15 +1087,15 @@ func (na *_Any__ReprAssembler) AssignBytes(v []byte) error {
 	if na.w == nil {
 		na.w = &_Any{}
 	}
-	na.ca = 2
+	na.ca = 3
 	x := &_Bytes{}
 	na.w.x = x
-	if na.ca2 == nil {
-		na.ca2 = &_Bytes__ReprAssembler{}
+	if na.ca3 == nil {
+		na.ca3 = &_Bytes__ReprAssembler{}
 	}
-	na.ca2.w = x
-	na.ca2.m = na.m
-	return na.ca2.AssignBytes(v)
+	na.ca3.w = x
+	na.ca3.m = na.m
+	return na.ca3.AssignBytes(v)
 }
 func (na *_Any__ReprAssembler) AssignLink(v datamodel.Link) error {
 	switch *na.m {
@@ -990,7 +1104,18 @@ func (na *_Any__ReprAssembler) AssignLink(v datamodel.Link) error {
 	case midvalue:
 		panic("invalid state: cannot assign into assembler that's already working on a larger structure!")
 	}
-	return schema.ErrNotUnionStructure{TypeName: "dagjose.Any.Repr", Detail: "AssignLink called but is not valid for any of the kinds that are valid members of this union"}
+	if na.w == nil {
+		na.w = &_Any{}
+	}
+	na.ca = 8
+	x := &_Link{}
+	na.w.x = x
+	if na.ca8 == nil {
+		na.ca8 = &_Link__ReprAssembler{}
+	}
+	na.ca8.w = x
+	na.ca8.m = na.m
+	return na.ca8.AssignLink(v)
 }
 func (na *_Any__ReprAssembler) AssignNode(v datamodel.Node) error {
 	if v.IsNull() {
@@ -1074,6 +1199,211 @@ func (na *_Any__ReprAssembler) Prototype() datamodel.NodePrototype {
 	return _Any__ReprPrototype{}
 }
 
+func (n Bool) Bool() bool {
+	return n.x
+}
+func (_Bool__Prototype) FromBool(v bool) (Bool, error) {
+	n := _Bool{v}
+	return &n, nil
+}
+
+type _Bool__Maybe struct {
+	m schema.Maybe
+	v _Bool
+}
+type MaybeBool = *_Bool__Maybe
+
+func (m MaybeBool) IsNull() bool {
+	return m.m == schema.Maybe_Null
+}
+func (m MaybeBool) IsAbsent() bool {
+	return m.m == schema.Maybe_Absent
+}
+func (m MaybeBool) Exists() bool {
+	return m.m == schema.Maybe_Value
+}
+func (m MaybeBool) AsNode() datamodel.Node {
+	switch m.m {
+	case schema.Maybe_Absent:
+		return datamodel.Absent
+	case schema.Maybe_Null:
+		return datamodel.Null
+	case schema.Maybe_Value:
+		return &m.v
+	default:
+		panic("unreachable")
+	}
+}
+func (m MaybeBool) Must() Bool {
+	if !m.Exists() {
+		panic("unbox of a maybe rejected")
+	}
+	return &m.v
+}
+
+var _ datamodel.Node = (Bool)(&_Bool{})
+var _ schema.TypedNode = (Bool)(&_Bool{})
+
+func (Bool) Kind() datamodel.Kind {
+	return datamodel.Kind_Bool
+}
+func (Bool) LookupByString(string) (datamodel.Node, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.LookupByString("")
+}
+func (Bool) LookupByNode(datamodel.Node) (datamodel.Node, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.LookupByNode(nil)
+}
+func (Bool) LookupByIndex(idx int64) (datamodel.Node, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.LookupByIndex(0)
+}
+func (Bool) LookupBySegment(seg datamodel.PathSegment) (datamodel.Node, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.LookupBySegment(seg)
+}
+func (Bool) MapIterator() datamodel.MapIterator {
+	return nil
+}
+func (Bool) ListIterator() datamodel.ListIterator {
+	return nil
+}
+func (Bool) Length() int64 {
+	return -1
+}
+func (Bool) IsAbsent() bool {
+	return false
+}
+func (Bool) IsNull() bool {
+	return false
+}
+func (n Bool) AsBool() (bool, error) {
+	return n.x, nil
+}
+func (Bool) AsInt() (int64, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.AsInt()
+}
+func (Bool) AsFloat() (float64, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.AsFloat()
+}
+func (Bool) AsString() (string, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.AsString()
+}
+func (Bool) AsBytes() ([]byte, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.AsBytes()
+}
+func (Bool) AsLink() (datamodel.Link, error) {
+	return mixins.Bool{TypeName: "dagjose.Bool"}.AsLink()
+}
+func (Bool) Prototype() datamodel.NodePrototype {
+	return _Bool__Prototype{}
+}
+
+type _Bool__Prototype struct{}
+
+func (_Bool__Prototype) NewBuilder() datamodel.NodeBuilder {
+	var nb _Bool__Builder
+	nb.Reset()
+	return &nb
+}
+
+type _Bool__Builder struct {
+	_Bool__Assembler
+}
+
+func (nb *_Bool__Builder) Build() datamodel.Node {
+	if *nb.m != schema.Maybe_Value {
+		panic("invalid state: cannot call Build on an assembler that's not finished")
+	}
+	return nb.w
+}
+func (nb *_Bool__Builder) Reset() {
+	var w _Bool
+	var m schema.Maybe
+	*nb = _Bool__Builder{_Bool__Assembler{w: &w, m: &m}}
+}
+
+type _Bool__Assembler struct {
+	w *_Bool
+	m *schema.Maybe
+}
+
+func (na *_Bool__Assembler) reset() {}
+func (_Bool__Assembler) BeginMap(sizeHint int64) (datamodel.MapAssembler, error) {
+	return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.BeginMap(0)
+}
+func (_Bool__Assembler) BeginList(sizeHint int64) (datamodel.ListAssembler, error) {
+	return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.BeginList(0)
+}
+func (na *_Bool__Assembler) AssignNull() error {
+	switch *na.m {
+	case allowNull:
+		*na.m = schema.Maybe_Null
+		return nil
+	case schema.Maybe_Absent:
+		return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.AssignNull()
+	case schema.Maybe_Value, schema.Maybe_Null:
+		panic("invalid state: cannot assign into assembler that's already finished")
+	}
+	panic("unreachable")
+}
+func (na *_Bool__Assembler) AssignBool(v bool) error {
+	switch *na.m {
+	case schema.Maybe_Value, schema.Maybe_Null:
+		panic("invalid state: cannot assign into assembler that's already finished")
+	}
+	na.w.x = v
+	*na.m = schema.Maybe_Value
+	return nil
+}
+func (_Bool__Assembler) AssignInt(int64) error {
+	return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.AssignInt(0)
+}
+func (_Bool__Assembler) AssignFloat(float64) error {
+	return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.AssignFloat(0)
+}
+func (_Bool__Assembler) AssignString(string) error {
+	return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.AssignString("")
+}
+func (_Bool__Assembler) AssignBytes([]byte) error {
+	return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.AssignBytes(nil)
+}
+func (_Bool__Assembler) AssignLink(datamodel.Link) error {
+	return mixins.BoolAssembler{TypeName: "dagjose.Bool"}.AssignLink(nil)
+}
+func (na *_Bool__Assembler) AssignNode(v datamodel.Node) error {
+	if v.IsNull() {
+		return na.AssignNull()
+	}
+	if v2, ok := v.(*_Bool); ok {
+		switch *na.m {
+		case schema.Maybe_Value, schema.Maybe_Null:
+			panic("invalid state: cannot assign into assembler that's already finished")
+		}
+		*na.w = *v2
+		*na.m = schema.Maybe_Value
+		return nil
+	}
+	if v2, err := v.AsBool(); err != nil {
+		return err
+	} else {
+		return na.AssignBool(v2)
+	}
+}
+func (_Bool__Assembler) Prototype() datamodel.NodePrototype {
+	return _Bool__Prototype{}
+}
+func (Bool) Type() schema.Type {
+	return nil /*TODO:typelit*/
+}
+func (n Bool) Representation() datamodel.Node {
+	return (*_Bool__Repr)(n)
+}
+
+type _Bool__Repr = _Bool
+
+var _ datamodel.Node = &_Bool__Repr{}
+
+type _Bool__ReprPrototype = _Bool__Prototype
+type _Bool__ReprAssembler = _Bool__Assembler
+
 func (n Bytes) Bytes() []byte {
 	return n.x
 }
@@ -13168,17 +13498,17 @@ func (n *_List) Lookup(idx int64) Any {
 		return nil
 	}
 	v := &n.x[idx]
-	return v
+	if v.m == schema.Maybe_Null {
+		return nil
+	}
+	return v.v
 }
 func (n *_List) LookupMaybe(idx int64) MaybeAny {
 	if n.Length() <= idx {
 		return nil
 	}
 	v := &n.x[idx]
-	return &_Any__Maybe{
-		m: schema.Maybe_Value,
-		v: v,
-	}
+	return v
 }
 
 var _List__valueAbsent = _Any__Maybe{m: schema.Maybe_Absent}
@@ -13192,7 +13522,7 @@ type List__Itr struct {
 	idx int
 }
 
-func (itr *List__Itr) Next() (idx int64, v Any) {
+func (itr *List__Itr) Next() (idx int64, v MaybeAny) {
 	if itr.idx >= len(itr.n.x) {
 		return -1, nil
 	}
@@ -13260,7 +13590,10 @@ func (n List) LookupByIndex(idx int64) (datamodel.Node, error) {
 		return nil, datamodel.ErrNotExists{Segment: datamodel.PathSegmentOfInt(idx)}
 	}
 	v := &n.x[idx]
-	return v, nil
+	if v.m == schema.Maybe_Null {
+		return datamodel.Null, nil
+	}
+	return v.v, nil
 }
 func (n List) LookupBySegment(seg datamodel.PathSegment) (datamodel.Node, error) {
 	i, err := seg.Index()
@@ -13287,7 +13620,12 @@ func (itr *_List__ListItr) Next() (idx int64, v datamodel.Node, _ error) {
 	}
 	idx = int64(itr.idx)
 	x := &itr.n.x[itr.idx]
-	v = x
+	switch x.m {
+	case schema.Maybe_Null:
+		v = datamodel.Null
+	case schema.Maybe_Value:
+		v = x.v
+	}
 	itr.idx++
 	return
 }
@@ -13355,7 +13693,6 @@ type _List__Assembler struct {
 	m     *schema.Maybe
 	state laState
 
-	cm schema.Maybe
 	va _Any__Assembler
 }
 
@@ -13378,7 +13715,7 @@ func (na *_List__Assembler) BeginList(sizeHint int64) (datamodel.ListAssembler,
 		sizeHint = 0
 	}
 	if sizeHint > 0 {
-		na.w.x = make([]_Any, 0, sizeHint)
+		na.w.x = make([]_Any__Maybe, 0, sizeHint)
 	}
 	return na, nil
 }
@@ -13448,10 +13785,13 @@ func (_List__Assembler) Prototype() datamodel.NodePrototype {
 	return _List__Prototype{}
 }
 func (la *_List__Assembler) valueFinishTidy() bool {
-	switch la.cm {
+	row := &la.w.x[len(la.w.x)-1]
+	switch row.m {
 	case schema.Maybe_Value:
+		row.v = la.va.w
 		la.va.w = nil
-		la.cm = schema.Maybe_Absent
+		fallthrough
+	case schema.Maybe_Null:
 		la.state = laState_initial
 		la.va.reset()
 		return true
@@ -13470,11 +13810,11 @@ func (la *_List__Assembler) AssembleValue() datamodel.NodeAssembler {
 	case laState_finished:
 		panic("invalid state: AssembleValue cannot be called on an assembler that's already finished")
 	}
-	la.w.x = append(la.w.x, _Any{})
+	la.w.x = append(la.w.x, _Any__Maybe{})
 	la.state = laState_midValue
 	row := &la.w.x[len(la.w.x)-1]
-	la.va.w = row
-	la.va.m = &la.cm
+	la.va.m = &row.m
+	row.m = allowNull
 	return &la.va
 }
 func (la *_List__Assembler) Finish() error {
@@ -13613,7 +13953,6 @@ type _List__ReprAssembler struct {
 	m     *schema.Maybe
 	state laState
 
-	cm schema.Maybe
 	va _Any__ReprAssembler
 }
 
@@ -13636,7 +13975,7 @@ func (na *_List__ReprAssembler) BeginList(sizeHint int64) (datamodel.ListAssembl
 		sizeHint = 0
 	}
 	if sizeHint > 0 {
-		na.w.x = make([]_Any, 0, sizeHint)
+		na.w.x = make([]_Any__Maybe, 0, sizeHint)
 	}
 	return na, nil
 }
@@ -13706,10 +14045,13 @@ func (_List__ReprAssembler) Prototype() datamodel.NodePrototype {
 	return _List__ReprPrototype{}
 }
 func (la *_List__ReprAssembler) valueFinishTidy() bool {
-	switch la.cm {
+	row := &la.w.x[len(la.w.x)-1]
+	switch row.m {
 	case schema.Maybe_Value:
+		row.v = la.va.w
 		la.va.w = nil
-		la.cm = schema.Maybe_Absent
+		fallthrough
+	case schema.Maybe_Null:
 		la.state = laState_initial
 		la.va.reset()
 		return true
@@ -13728,11 +14070,11 @@ func (la *_List__ReprAssembler) AssembleValue() datamodel.NodeAssembler {
 	case laState_finished:
 		panic("invalid state: AssembleValue cannot be called on an assembler that's already finished")
 	}
-	la.w.x = append(la.w.x, _Any{})
+	la.w.x = append(la.w.x, _Any__Maybe{})
 	la.state = laState_midValue
 	row := &la.w.x[len(la.w.x)-1]
-	la.va.w = row
-	la.va.m = &la.cm
+	la.va.m = &row.m
+	row.m = allowNull
 	return &la.va
 }
 func (la *_List__ReprAssembler) Finish() error {
@@ -13759,17 +14101,17 @@ func (n *_Map) Lookup(k String) Any {
 	if !exists {
 		return nil
 	}
-	return v
+	if v.m == schema.Maybe_Null {
+		return nil
+	}
+	return v.v
 }
 func (n *_Map) LookupMaybe(k String) MaybeAny {
 	v, exists := n.m[*k]
 	if !exists {
 		return &_Map__valueAbsent
 	}
-	return &_Any__Maybe{
-		m: schema.Maybe_Value,
-		v: v,
-	}
+	return v
 }
 
 var _Map__valueAbsent = _Any__Maybe{m: schema.Maybe_Absent}
@@ -13783,7 +14125,7 @@ type Map__Itr struct {
 	idx int
 }
 
-func (itr *Map__Itr) Next() (k String, v Any) {
+func (itr *Map__Itr) Next() (k String, v MaybeAny) {
 	if itr.idx >= len(itr.n.t) {
 		return nil, nil
 	}
@@ -13846,7 +14188,10 @@ func (n Map) LookupByString(k string) (datamodel.Node, error) {
 	if !exists {
 		return nil, datamodel.ErrNotExists{Segment: datamodel.PathSegmentOfString(k)}
 	}
-	return v, nil
+	if v.m == schema.Maybe_Null {
+		return datamodel.Null, nil
+	}
+	return v.v, nil
 }
 func (n Map) LookupByNode(k datamodel.Node) (datamodel.Node, error) {
 	k2, ok := k.(String)
@@ -13858,7 +14203,10 @@ func (n Map) LookupByNode(k datamodel.Node) (datamodel.Node, error) {
 	if !exists {
 		return nil, datamodel.ErrNotExists{Segment: datamodel.PathSegmentOfString(k2.String())}
 	}
-	return v, nil
+	if v.m == schema.Maybe_Null {
+		return datamodel.Null, nil
+	}
+	return v.v, nil
 }
 func (Map) LookupByIndex(idx int64) (datamodel.Node, error) {
 	return mixins.Map{TypeName: "dagjose.Map"}.LookupByIndex(0)
@@ -13881,7 +14229,12 @@ func (itr *_Map__MapItr) Next() (k datamodel.Node, v datamodel.Node, _ error) {
 	}
 	x := &itr.n.t[itr.idx]
 	k = &x.k
-	v = &x.v
+	switch x.v.m {
+	case schema.Maybe_Null:
+		v = datamodel.Null
+	case schema.Maybe_Value:
+		v = x.v.v
+	}
 	itr.idx++
 	return
 }
@@ -13973,7 +14326,7 @@ func (na *_Map__Assembler) BeginMap(sizeHint int64) (datamodel.MapAssembler, err
 	if sizeHint < 0 {
 		sizeHint = 0
 	}
-	na.w.m = make(map[_String]*_Any, sizeHint)
+	na.w.m = make(map[_String]MaybeAny, sizeHint)
 	na.w.t = make([]_Map__entry, 0, sizeHint)
 	return na, nil
 }
@@ -14056,8 +14409,8 @@ func (ma *_Map__Assembler) keyFinishTidy() bool {
 		ma.cm = schema.Maybe_Absent
 		ma.state = maState_expectValue
 		ma.w.m[tz.k] = &tz.v
-		ma.va.w = &tz.v
-		ma.va.m = &ma.cm
+		ma.va.m = &tz.v.m
+		tz.v.m = allowNull
 		ma.ka.reset()
 		return true
 	default:
@@ -14065,10 +14418,15 @@ func (ma *_Map__Assembler) keyFinishTidy() bool {
 	}
 }
 func (ma *_Map__Assembler) valueFinishTidy() bool {
-	switch ma.cm {
+	tz := &ma.w.t[len(ma.w.t)-1]
+	switch tz.v.m {
+	case schema.Maybe_Null:
+		ma.state = maState_initial
+		ma.va.reset()
+		return true
 	case schema.Maybe_Value:
+		tz.v.v = ma.va.w
 		ma.va.w = nil
-		ma.cm = schema.Maybe_Absent
 		ma.state = maState_initial
 		ma.va.reset()
 		return true
@@ -14104,8 +14462,8 @@ func (ma *_Map__Assembler) AssembleEntry(k string) (datamodel.NodeAssembler, err
 	ma.state = maState_midValue
 
 	ma.w.m[k2] = &tz.v
-	ma.va.w = &tz.v
-	ma.va.m = &ma.cm
+	ma.va.m = &tz.v.m
+	tz.v.m = allowNull
 	return &ma.va, nil
 }
 func (ma *_Map__Assembler) AssembleKey() datamodel.NodeAssembler {
@@ -14307,7 +14665,7 @@ func (na *_Map__ReprAssembler) BeginMap(sizeHint int64) (datamodel.MapAssembler,
 	if sizeHint < 0 {
 		sizeHint = 0
 	}
-	na.w.m = make(map[_String]*_Any, sizeHint)
+	na.w.m = make(map[_String]MaybeAny, sizeHint)
 	na.w.t = make([]_Map__entry, 0, sizeHint)
 	return na, nil
 }
@@ -14390,8 +14748,8 @@ func (ma *_Map__ReprAssembler) keyFinishTidy() bool {
 		ma.cm = schema.Maybe_Absent
 		ma.state = maState_expectValue
 		ma.w.m[tz.k] = &tz.v
-		ma.va.w = &tz.v
-		ma.va.m = &ma.cm
+		ma.va.m = &tz.v.m
+		tz.v.m = allowNull
 		ma.ka.reset()
 		return true
 	default:
@@ -14399,10 +14757,15 @@ func (ma *_Map__ReprAssembler) keyFinishTidy() bool {
 	}
 }
 func (ma *_Map__ReprAssembler) valueFinishTidy() bool {
-	switch ma.cm {
+	tz := &ma.w.t[len(ma.w.t)-1]
+	switch tz.v.m {
+	case schema.Maybe_Null:
+		ma.state = maState_initial
+		ma.va.reset()
+		return true
 	case schema.Maybe_Value:
+		tz.v.v = ma.va.w
 		ma.va.w = nil
-		ma.cm = schema.Maybe_Absent
 		ma.state = maState_initial
 		ma.va.reset()
 		return true
@@ -14438,8 +14801,8 @@ func (ma *_Map__ReprAssembler) AssembleEntry(k string) (datamodel.NodeAssembler,
 	ma.state = maState_midValue
 
 	ma.w.m[k2] = &tz.v
-	ma.va.w = &tz.v
-	ma.va.m = &ma.cm
+	ma.va.m = &tz.v.m
+	tz.v.m = allowNull
 	return &ma.va, nil
 }
 func (ma *_Map__ReprAssembler) AssembleKey() datamodel.NodeAssembler {
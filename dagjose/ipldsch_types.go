@@ -11,12 +11,11 @@ var _ datamodel.Node = nil // suppress errors when this dependency is not refere
 // One of its major uses is to start the construction of a value.
 // You can use it like this:
 //
-// 		dagjose.Type.YourTypeName.NewBuilder().BeginMap() //...
+//	dagjose.Type.YourTypeName.NewBuilder().BeginMap() //...
 //
 // and:
 //
-// 		dagjose.Type.OtherTypeName.NewBuilder().AssignString("x") // ...
-//
+//	dagjose.Type.OtherTypeName.NewBuilder().AssignString("x") // ...
 var Type typeSlab
 
 type typeSlab struct {
@@ -24,6 +23,8 @@ type typeSlab struct {
 	Any__Repr               _Any__ReprPrototype
 	Base64Url               _Base64Url__Prototype
 	Base64Url__Repr         _Base64Url__ReprPrototype
+	Bool                    _Bool__Prototype
+	Bool__Repr              _Bool__ReprPrototype
 	Bytes                   _Bytes__Prototype
 	Bytes__Repr             _Bytes__ReprPrototype
 	DecodedJWE              _DecodedJWE__Prototype
@@ -78,12 +79,18 @@ type _Any__iface interface {
 	_Any__member()
 }
 
+func (_Bool) _Any__member()   {}
 func (_String) _Any__member() {}
 func (_Bytes) _Any__member()  {}
 func (_Int) _Any__member()    {}
 func (_Float) _Any__member()  {}
 func (_Map) _Any__member()    {}
 func (_List) _Any__member()   {}
+func (_Link) _Any__member()   {}
+
+// Bool matches the IPLD Schema type "Bool".  It has bool kind.
+type Bool = *_Bool
+type _Bool struct{ x bool }
 
 // Bytes matches the IPLD Schema type "Bytes".  It has bytes kind.
 type Bytes = *_Bytes
@@ -197,18 +204,18 @@ type _Link struct{ x datamodel.Link }
 // List matches the IPLD Schema type "List".  It has list kind.
 type List = *_List
 type _List struct {
-	x []_Any
+	x []_Any__Maybe
 }
 
 // Map matches the IPLD Schema type "Map".  It has map kind.
 type Map = *_Map
 type _Map struct {
-	m map[_String]*_Any
+	m map[_String]MaybeAny
 	t []_Map__entry
 }
 type _Map__entry struct {
 	k _String
-	v _Any
+	v _Any__Maybe
 }
 
 // String matches the IPLD Schema type "String".  It has string kind.
@@ -0,0 +1,124 @@
+package dagjose
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// ReifyPayload presents a JWS as its payload once every signature of the JWS has been verified with the configured
+// keys. It fits the ipld.NodeReifier function interface, so it can be set as the NodeReifier of a LinkSystem, which
+// makes path lookups and selectors go straight through signature envelopes, or registered in its KnownReifiers for use
+// with ExploreInterpretAs.
+//
+// The payload block is loaded with the given LinkSystem, and is itself reified by its NodeReifier. Nodes that are not a
+// JWS, i.e. that cannot be assembled into a DecodedJWS and do not have a detached payload, are returned as-is. An error
+// is returned, failing the traversal, if the JWS has no signatures, a detached payload, or a signature that could not
+// be verified. In the latter case the error wraps the SignatureResult error, e.g. ErrInvalidSignature.
+func (cfg VerifyOptions) ReifyPayload(linkContext ipld.LinkContext, n datamodel.Node, linkSystem *ipld.LinkSystem) (datamodel.Node, error) {
+	jws, err := asDecodedJWS(n)
+	if (err != nil) && isDetached(n) {
+		return nil, errors.New("JWS has a detached payload")
+	} else if err != nil {
+		return n, nil
+	}
+	results, err := cfg.Verify(jws)
+	if err != nil {
+		return nil, err
+	} else if len(results) == 0 {
+		return nil, errors.New("JWS has no signatures")
+	}
+	for _, result := range results {
+		if !result.Valid() {
+			return nil, fmt.Errorf("signature %d: %w", result.Index, result.Err)
+		}
+	}
+	payload, err := cid.Cast([]byte(jws.payload.x))
+	if err != nil {
+		return nil, fmt.Errorf("payload is not a valid CID: %v", err)
+	}
+	return linkSystem.Load(linkContext, cidlink.Link{Cid: payload}, basicnode.Prototype.Any)
+}
+
+// PayloadReifier returns a reifier presenting each JWS as its payload once its signatures have been verified with the
+// given public keys. See VerifyOptions.ReifyPayload.
+func PayloadReifier(keys ...interface{}) ipld.NodeReifier {
+	return VerifyOptions{Keys: keys}.ReifyPayload
+}
+
+// ReifyCleartext presents a JWE as the node decoded from its cleartext block, decrypting it with the configured keys.
+// It fits the ipld.NodeReifier function interface, so it can be set as the NodeReifier of a LinkSystem or registered
+// in its KnownReifiers, like VerifyOptions.ReifyPayload. The cleartext node is in turn reified by the NodeReifier of the
+// given LinkSystem, if any, e.g. to verify a JWS that was encrypted.
+//
+// Nodes that are not a JWE, i.e. that cannot be assembled into a DecodedJWE, are returned as-is. A JWE for which no key
+// is available results in a LockedError: either none of the keys can be used with any recipient, or none of them
+// decrypts the JWE and none is identified as the key of a recipient, by the key ID of a JWK or through the Resolver.
+// A JWE that fails to decrypt with the key of one of its recipients, e.g. because it was tampered with, results in
+// ErrDecryptionFailed instead. Wrapping this reifier in one that returns the JWE itself on a LockedError gives a
+// partial view of an encrypted DAG, in which the JWE that cannot be decrypted are left as they are.
+func (cfg DecryptOptions) ReifyCleartext(linkContext ipld.LinkContext, n datamodel.Node, linkSystem *ipld.LinkSystem) (datamodel.Node, error) {
+	jwe, err := asDecodedJWE(n)
+	if err != nil {
+		return n, nil
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err = cfg.Decrypt(jwe, nb)
+	if keyIDs := recipientKeyIDs(jwe); errors.Is(err, ErrNoDecryptionKey) ||
+		(errors.Is(err, ErrDecryptionFailed) && !cfg.hasRecipientKey(keyIDs)) {
+		return nil, &LockedError{KeyIDs: keyIDs, Err: err}
+	} else if err != nil {
+		return nil, err
+	}
+	if linkSystem.NodeReifier != nil {
+		return linkSystem.NodeReifier(linkContext, nb.Build(), linkSystem)
+	}
+	return nb.Build(), nil
+}
+
+// CleartextReifier returns a reifier presenting each JWE as its cleartext, decrypted with the given private keys. See
+// DecryptOptions.ReifyCleartext.
+func CleartextReifier(keys ...interface{}) ipld.NodeReifier {
+	return DecryptOptions{Keys: keys}.ReifyCleartext
+}
+
+// recipientKeyIDs returns the `kid` header parameters of the recipients of a JWE, including the shared one of a JWE
+// without recipients.
+func recipientKeyIDs(jwe DecodedJWE) []string {
+	var keyIDs []string
+	if !jwe.recipients.Exists() || (len(jwe.recipients.v.x) == 0) {
+		if header, err := jwe.JOSEHeader(); (err == nil) && (len(header.KeyID) > 0) {
+			keyIDs = append(keyIDs, header.KeyID)
+		}
+		return keyIDs
+	}
+	for idx := range jwe.recipients.v.x {
+		if header, err := jwe.RecipientHeader(idx); (err == nil) && (len(header.KeyID) > 0) {
+			keyIDs = append(keyIDs, header.KeyID)
+		}
+	}
+	return keyIDs
+}
+
+// hasRecipientKey returns true if a key with one of the given key IDs is available, either as a configured JWK with
+// that key ID or from the Resolver.
+func (cfg DecryptOptions) hasRecipientKey(keyIDs []string) bool {
+	for _, kid := range keyIDs {
+		for _, key := range cfg.Keys {
+			if _, keyID := unwrapJSONWebKey(key); keyID == kid {
+				return true
+			}
+		}
+		if cfg.Resolver != nil {
+			if resolved, err := cfg.Resolver.ResolveKey(kid); (err == nil) && (len(resolved) > 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
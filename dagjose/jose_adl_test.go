@@ -0,0 +1,232 @@
+package dagjose
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+// storeSignedMessage stores a signed payload holding a message, and a dag-cbor root linking to the JWS
+func storeSignedMessage(t *testing.T, ls ipld.LinkSystem) (ipld.Link, ipld.Link) {
+	message := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("text").AssignString("hello")
+	})
+	_, jwsLink := storeSignedCommit(t, ls, map[string]datamodel.Node{"message": message})
+	rootLink, err := ls.Store(ipld.LinkContext{}, dagCBORLinkPrototype, fluent.MustBuildMap(basicnode.Prototype.Map, 1,
+		func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("signed").AssignLink(jwsLink)
+		},
+	))
+	require.NoError(t, err)
+	return rootLink, jwsLink
+}
+
+// Path lookups must go straight through a verified JWS into its payload, and fail if the JWS cannot be verified
+func TestReifyPayload(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	ls := memoryLinkSystem()
+	rootLink, jwsLink := storeSignedMessage(t, ls)
+	ls.NodeReifier = PayloadReifier(edKey.Public())
+
+	n, err := ls.Load(ipld.LinkContext{}, jwsLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	_, err = n.LookupByString("signatures")
+	require.Error(t, err)
+	root, err := ls.Load(ipld.LinkContext{}, rootLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	text, err := traversal.Progress{Cfg: traversalConfig(ls)}.Get(root, datamodel.ParsePath("signed/message/text"))
+	require.NoError(t, err)
+	require.Equal(t, basicnode.NewString("hello"), text)
+
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	ls.NodeReifier = PayloadReifier(otherKey.Public())
+	_, err = ls.Load(ipld.LinkContext{}, jwsLink, basicnode.Prototype.Any)
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	// The traversal does not wrap the error of the reifier
+	_, err = traversal.Progress{Cfg: traversalConfig(ls)}.Get(root, datamodel.ParsePath("signed/message/text"))
+	require.ErrorContains(t, err, ErrInvalidSignature.Error())
+	ls.NodeReifier = PayloadReifier()
+	_, err = ls.Load(ipld.LinkContext{}, jwsLink, basicnode.Prototype.Any)
+	require.ErrorIs(t, err, ErrNoVerificationKey)
+
+	// Nodes that are not a JWS are left as-is
+	for _, n := range []datamodel.Node{root, basicnode.NewString("payload"), fluent.MustBuildMap(basicnode.Prototype.Map, 0, func(fluent.MapAssembler) {})} {
+		reified, err := PayloadReifier()(ipld.LinkContext{}, n, &ls)
+		require.NoError(t, err)
+		require.Equal(t, n, reified)
+	}
+}
+
+// A selector must be able to interpret a JWS as its verified payload
+func TestReifyPayloadInterpretAs(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	ls := memoryLinkSystem()
+	_, jwsLink := storeSignedMessage(t, ls)
+	ls.KnownReifiers = map[string]ipld.NodeReifier{"verified": VerifyOptions{Keys: []interface{}{edKey}}.ReifyPayload}
+
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	sel, err := ssb.ExploreInterpretAs("verified", ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("message", ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("text", ssb.Matcher())
+		}))
+	})).Selector()
+	require.NoError(t, err)
+	jws, err := ls.Load(ipld.LinkContext{}, jwsLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	var matched []string
+	require.NoError(t, traversal.Progress{Cfg: traversalConfig(ls)}.WalkMatching(jws, sel,
+		func(_ traversal.Progress, n datamodel.Node) error {
+			text, err := n.AsString()
+			matched = append(matched, text)
+			return err
+		},
+	))
+	require.Equal(t, []string{"hello"}, matched)
+}
+
+// storeEncryptedMessage stores an encrypted message, and a dag-cbor root linking to the JWE
+func storeEncryptedMessage(t *testing.T, ls ipld.LinkSystem, key *ecdsa.PrivateKey) (ipld.Link, ipld.Link) {
+	message := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("text").AssignString("secret")
+	})
+	jwe, err := Encrypt(message, A256GCM, RecipientKey{Key: &key.PublicKey, KeyID: "key-1"})
+	require.NoError(t, err)
+	jweLink, err := StoreJOSE(ipld.LinkContext{}, jwe, ls)
+	require.NoError(t, err)
+	rootLink, err := ls.Store(ipld.LinkContext{}, dagCBORLinkPrototype, fluent.MustBuildMap(basicnode.Prototype.Map, 2,
+		func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("private").AssignLink(jweLink)
+			ma.AssembleEntry("public").AssignString("hello")
+		},
+	))
+	require.NoError(t, err)
+	return rootLink, jweLink
+}
+
+// Path lookups must go straight through a JWE into its cleartext, and fail with a LockedError without a key
+func TestReifyCleartext(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ls := memoryLinkSystem()
+	rootLink, jweLink := storeEncryptedMessage(t, ls, ecKey)
+	root, err := ls.Load(ipld.LinkContext{}, rootLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+
+	ls.NodeReifier = CleartextReifier(ecKey)
+	text, err := traversal.Progress{Cfg: traversalConfig(ls)}.Get(root, datamodel.ParsePath("private/text"))
+	require.NoError(t, err)
+	require.Equal(t, basicnode.NewString("secret"), text)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		keys  []interface{}
+		cause error
+	}{
+		{[]interface{}{otherKey}, ErrDecryptionFailed},
+		{nil, ErrNoDecryptionKey},
+	} {
+		ls.NodeReifier = CleartextReifier(tc.keys...)
+		_, err = ls.Load(ipld.LinkContext{}, jweLink, basicnode.Prototype.Any)
+		var locked *LockedError
+		require.ErrorAs(t, err, &locked)
+		require.Equal(t, []string{"key-1"}, locked.KeyIDs)
+		require.ErrorIs(t, err, ErrLocked)
+		require.ErrorIs(t, err, tc.cause)
+	}
+
+	// Locked JWE are left as they are in a partial view
+	ls.NodeReifier = func(linkContext ipld.LinkContext, n datamodel.Node, linkSystem *ipld.LinkSystem) (datamodel.Node, error) {
+		if reified, err := CleartextReifier(otherKey)(linkContext, n, linkSystem); errors.Is(err, ErrLocked) {
+			return n, nil
+		} else {
+			return reified, err
+		}
+	}
+	sel, err := selector.CompileSelector(selectorparse.CommonSelector_MatchAllRecursively)
+	require.NoError(t, err)
+	var paths []string
+	require.NoError(t, traversal.Progress{Cfg: traversalConfig(ls)}.WalkAdv(root, sel,
+		func(progress traversal.Progress, n datamodel.Node, _ traversal.VisitReason) error {
+			paths = append(paths, progress.Path.String())
+			return nil
+		},
+	))
+	require.Contains(t, paths, "public")
+	require.Contains(t, paths, "private/ciphertext")
+}
+
+// A JWE that fails to decrypt with the key of one of its recipients has been tampered with and must not be locked
+func TestReifyTamperedCleartext(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	message := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("text").AssignString("secret")
+	})
+	jwe, err := Encrypt(message, A256GCM, RecipientKey{Key: &ecKey.PublicKey, KeyID: "key-1"})
+	require.NoError(t, err)
+	jwe.ciphertext.x[0] ^= 0xff
+
+	resolver := KeyResolverFunc(func(kid string) ([]interface{}, error) {
+		if kid == "key-1" {
+			return []interface{}{ecKey}, nil
+		}
+		return nil, nil
+	})
+	for _, cfg := range []DecryptOptions{
+		{Keys: []interface{}{gojose.JSONWebKey{Key: ecKey, KeyID: "key-1"}}},
+		{Resolver: resolver},
+	} {
+		_, err = cfg.ReifyCleartext(ipld.LinkContext{}, jwe, &ipld.LinkSystem{})
+		require.ErrorIs(t, err, ErrDecryptionFailed)
+		require.NotErrorIs(t, err, ErrLocked)
+	}
+
+	// Without a key identified as that of the recipient, the JWE cannot be told apart from one encrypted for others
+	_, err = CleartextReifier(ecKey)(ipld.LinkContext{}, jwe, &ipld.LinkSystem{})
+	require.ErrorIs(t, err, ErrLocked)
+}
+
+// An encrypted JWS must be decrypted and then verified when both reifiers are chained
+func TestReifyEncryptedJWS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	ls := memoryLinkSystem()
+	_, jwsLink := storeSignedMessage(t, ls)
+	jws, err := ls.Load(ipld.LinkContext{}, jwsLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	jwe, err := EncryptOptions{Codec: Codec}.Encrypt(jws, RecipientKey{Key: &ecKey.PublicKey})
+	require.NoError(t, err)
+	jweLink, err := StoreJOSE(ipld.LinkContext{}, jwe, ls)
+	require.NoError(t, err)
+
+	decrypt, verify := CleartextReifier(ecKey), PayloadReifier(edKey.Public())
+	ls.NodeReifier = func(linkContext ipld.LinkContext, n datamodel.Node, linkSystem *ipld.LinkSystem) (datamodel.Node, error) {
+		if n, err := decrypt(linkContext, n, linkSystem); err != nil {
+			return nil, err
+		} else {
+			return verify(linkContext, n, linkSystem)
+		}
+	}
+	n, err := ls.Load(ipld.LinkContext{}, jweLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	text, err := traversal.Get(n, datamodel.ParsePath("message/text"))
+	require.NoError(t, err)
+	require.Equal(t, basicnode.NewString("hello"), text)
+}
@@ -0,0 +1,100 @@
+package dagjose
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+// CAROptions can be used to customize how DAGs containing dag-jose objects are exported to and imported from CAR files
+// (https://ipld.io/specs/transport/car/).
+type CAROptions struct {
+	// Version is the CAR version written by ExportCAR, 1 or 2. If zero, CARv1 is written. ImportCAR reads both versions
+	// regardless of this option.
+	Version uint64
+	// DecodeOptions are used by ImportCAR to validate each dag-jose block, e.g. to only accept canonical blocks or to
+	// limit the resources used by untrusted blocks.
+	DecodeOptions DecodeOptions
+}
+
+// ExportCAR writes the DAG rooted at the given link to the given writer as a CAR file with that root. Every block
+// reachable from the root is written, once and in traversal order, including the payloads of JWS, which are reached
+// through the `link` field added when the LinkSystem decodes them with the registered dag-jose decoder, and any
+// blocks linked from those payloads.
+func (cfg CAROptions) ExportCAR(root ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem, w io.Writer) error {
+	rootLink, castOk := root.(cidlink.Link)
+	if !castOk {
+		return fmt.Errorf("unsupported link type: %T", root)
+	}
+	ctx := linkContext.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	selector := selectorparse.CommonSelector_ExploreAllRecursively
+	switch cfg.Version {
+	case 0, 1:
+		_, err := car.TraverseV1(ctx, &linkSystem, rootLink.Cid, selector, w)
+		return err
+	case 2:
+		if carWriter, err := car.NewSelectiveWriter(ctx, &linkSystem, rootLink.Cid, selector); err != nil {
+			return err
+		} else {
+			_, err = carWriter.WriteTo(w)
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported CAR version: %d", cfg.Version)
+	}
+}
+
+// ImportCAR reads a CARv1 or CARv2 file from the given reader and writes each of its blocks to the given LinkSystem,
+// returning the roots of the CAR. Each block must match the hash of its CID, and dag-jose blocks must decode with the
+// configured DecodeOptions. Blocks are written as they are read, so some may have been written when an error is
+// returned.
+func (cfg CAROptions) ImportCAR(r io.Reader, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) ([]cid.Cid, error) {
+	blockReader, err := car.NewBlockReader(r)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		block, err := blockReader.Next()
+		if err == io.EOF {
+			return blockReader.Roots, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// The block reader has already checked that the block matches the hash of its CID
+		blockCid, data := block.Cid(), block.RawData()
+		if blockCid.Prefix().Codec == Codec {
+			if err := cfg.DecodeOptions.Decode(basicnode.Prototype.Any.NewBuilder(), bytes.NewReader(data)); err != nil {
+				return nil, fmt.Errorf("block %s: %w", blockCid, err)
+			}
+		}
+		if blockWriter, commit, err := linkSystem.StorageWriteOpener(linkContext); err != nil {
+			return nil, err
+		} else if _, err := blockWriter.Write(data); err != nil {
+			return nil, err
+		} else if err := commit(cidlink.Link{Cid: blockCid}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ExportCAR writes the DAG rooted at the given link to the given writer as a CARv1 file. See CAROptions.ExportCAR.
+func ExportCAR(root ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem, w io.Writer) error {
+	return CAROptions{}.ExportCAR(root, linkContext, linkSystem, w)
+}
+
+// ImportCAR writes the blocks of a CAR file to the given LinkSystem, validating each dag-jose block, and returns the
+// roots of the CAR. See CAROptions.ImportCAR.
+func ImportCAR(r io.Reader, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) ([]cid.Cid, error) {
+	return CAROptions{}.ImportCAR(r, linkContext, linkSystem)
+}
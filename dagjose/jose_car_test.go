@@ -0,0 +1,133 @@
+package dagjose
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+var dagCBORLinkPrototype = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagCBOR,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}}
+
+// storeSignedCommit stores a dag-cbor payload with the given fields and a JWS signing it, and returns the links to the
+// payload and to the JWS.
+func storeSignedCommit(t *testing.T, ls ipld.LinkSystem, fields map[string]datamodel.Node) (ipld.Link, ipld.Link) {
+	payload := fluent.MustBuildMap(basicnode.Prototype.Map, int64(len(fields)), func(ma fluent.MapAssembler) {
+		for key, value := range fields {
+			ma.AssembleEntry(key).AssignNode(value)
+		}
+	})
+	payloadLink, err := ls.Store(ipld.LinkContext{}, dagCBORLinkPrototype, payload)
+	require.NoError(t, err)
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := SignLink(payloadLink, SigningKey{Key: edKey})
+	require.NoError(t, err)
+	jwsLink, err := StoreJOSE(ipld.LinkContext{}, jws, ls)
+	require.NoError(t, err)
+	return payloadLink, jwsLink
+}
+
+// A DAG of signed commits must be exported with every block, through JWS payloads and dag-cbor links, and imported
+// back into an empty LinkSystem
+func TestCARRoundTrip(t *testing.T) {
+	ls := memoryLinkSystem()
+	leafLink, err := ls.Store(ipld.LinkContext{}, dagCBORLinkPrototype, basicnode.NewString("leaf"))
+	require.NoError(t, err)
+	firstPayload, firstJWS := storeSignedCommit(t, ls, map[string]datamodel.Node{"data": basicnode.NewLink(leafLink)})
+	secondPayload, secondJWS := storeSignedCommit(t, ls, map[string]datamodel.Node{"prev": basicnode.NewLink(firstJWS)})
+	links := []ipld.Link{secondJWS, secondPayload, firstJWS, firstPayload, leafLink}
+
+	for _, version := range []uint64{1, 2} {
+		carFile := filepath.Join(t.TempDir(), "commits.car")
+		w, err := os.Create(carFile)
+		require.NoError(t, err)
+		require.NoError(t, CAROptions{Version: version}.ExportCAR(secondJWS, ipld.LinkContext{}, ls, w))
+		require.NoError(t, w.Close())
+
+		r, err := os.Open(carFile)
+		require.NoError(t, err)
+		carVersion, err := car.ReadVersion(r)
+		require.NoError(t, err)
+		require.Equal(t, version, carVersion)
+		// Blocks are written in traversal order
+		_, err = r.Seek(0, 0)
+		require.NoError(t, err)
+		blockReader, err := car.NewBlockReader(r)
+		require.NoError(t, err)
+		for _, link := range links {
+			block, err := blockReader.Next()
+			require.NoError(t, err)
+			require.Equal(t, link.(cidlink.Link).Cid, block.Cid())
+		}
+
+		_, err = r.Seek(0, 0)
+		require.NoError(t, err)
+		imported := memoryLinkSystem()
+		roots, err := CAROptions{DecodeOptions: DecodeOptions{Strict: true}}.ImportCAR(r, ipld.LinkContext{}, imported)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, []cid.Cid{secondJWS.(cidlink.Link).Cid}, roots)
+		for _, link := range links {
+			expected, err := ls.LoadRaw(ipld.LinkContext{}, link)
+			require.NoError(t, err)
+			actual, err := imported.LoadRaw(ipld.LinkContext{}, link)
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+		}
+	}
+
+	// Exporting fails if a block is missing
+	jws, err := SignJWS(createCid([]byte("missing payload")), SigningKey{Key: ed25519.NewKeyFromSeed(make([]byte, 32))})
+	require.NoError(t, err)
+	missingPayload, err := StoreJOSE(ipld.LinkContext{}, jws, ls)
+	require.NoError(t, err)
+	require.Error(t, ExportCAR(missingPayload, ipld.LinkContext{}, ls, &bytes.Buffer{}))
+	require.ErrorContains(t, CAROptions{Version: 3}.ExportCAR(secondJWS, ipld.LinkContext{}, ls, &bytes.Buffer{}),
+		"unsupported CAR version")
+}
+
+// Importing must fail for blocks not matching their CID and for invalid dag-jose blocks
+func TestImportCARErrors(t *testing.T) {
+	writeCAR := func(blockCid cid.Cid, data []byte) *bytes.Buffer {
+		var buf bytes.Buffer
+		carWriter, err := storage.NewWritable(&buf, []cid.Cid{blockCid}, car.WriteAsCarV1(true))
+		require.NoError(t, err)
+		require.NoError(t, carWriter.Put(context.Background(), blockCid.KeyString(), data))
+		require.NoError(t, carWriter.Finalize())
+		return &buf
+	}
+
+	// The dag-cbor string "a"
+	notJOSE := []byte{0x61, 0x61}
+	notJOSECid, err := LinkPrototype.Sum(notJOSE)
+	require.NoError(t, err)
+	_, err = ImportCAR(writeCAR(notJOSECid, notJOSE), ipld.LinkContext{}, memoryLinkSystem())
+	require.ErrorIs(t, err, ErrNotJOSE)
+
+	block := []byte{0xa0}
+	wrongCid, err := dagCBORLinkPrototype.Sum([]byte{0xa1})
+	require.NoError(t, err)
+	_, err = ImportCAR(writeCAR(wrongCid, block), ipld.LinkContext{}, memoryLinkSystem())
+	require.ErrorContains(t, err, "mismatch in content integrity")
+
+	_, err = ImportCAR(bytes.NewReader([]byte("not a CAR")), ipld.LinkContext{}, memoryLinkSystem())
+	require.Error(t, err)
+}
@@ -0,0 +1,227 @@
+package dagjose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ParseCompactJWS parses a JWS in compact serialization (RFC 7515 §7.1), i.e. `protected.payload.signature`, into a
+// DecodedJWS. The payload must be a CID, and the `link` field of the returned node is populated from it.
+func ParseCompactJWS(compact string) (DecodedJWS, error) {
+	segments := strings.Split(compact, ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("invalid compact JWS serialization: expected 3 segments, found %d", len(segments))
+	}
+	protected, err := decodeCompactSegment("protected", segments[0], false)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decodeCompactSegment("payload", segments[1], false)
+	if err != nil {
+		return nil, err
+	}
+	payloadCid, err := cid.Cast(payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload is not a valid CID: %v", err)
+	}
+	signature, err := decodeCompactSegment("signature", segments[2], false)
+	if err != nil {
+		return nil, err
+	}
+	jws, err := fluent.BuildMap(Type.DecodedJWS__Repr, 3, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("link").AssignLink(cidlink.Link{Cid: payloadCid})
+		ma.AssembleEntry("payload").AssignBytes(payload)
+		ma.AssembleEntry("signatures").CreateList(1, func(la fluent.ListAssembler) {
+			la.AssembleValue().CreateMap(2, func(ma fluent.MapAssembler) {
+				ma.AssembleEntry("protected").AssignBytes(protected)
+				ma.AssembleEntry("signature").AssignBytes(signature)
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jws.(DecodedJWS), nil
+}
+
+// CompactJWS renders a dag-jose JWS in compact serialization (RFC 7515 §7.1). The JWS may be in general or flattened
+// form. Compact serialization can only carry a single signature with a protected header, so an error is returned if
+// the JWS has multiple signatures, no protected header, or an unprotected header.
+func CompactJWS(n datamodel.Node) (string, error) {
+	n, err := unflattenJWS(n)
+	if err != nil {
+		return "", err
+	}
+	payload, err := lookupString("payload", n)
+	if err != nil {
+		return "", err
+	}
+	signatures, err := lookupIgnoreAbsent("signatures", n)
+	if err != nil {
+		return "", err
+	} else if (signatures == nil) || (signatures.Length() != 1) {
+		return "", errors.New("compact JWS serialization requires exactly one signature")
+	}
+	signature, err := signatures.LookupByIndex(0)
+	if err != nil {
+		return "", err
+	}
+	if header, err := lookupIgnoreNoSuchField("header", signature); err != nil {
+		return "", err
+	} else if (header != nil) && !header.IsNull() {
+		return "", errors.New("compact JWS serialization cannot carry an unprotected header")
+	}
+	protected, err := lookupString("protected", signature)
+	if err != nil {
+		return "", err
+	} else if len(protected) == 0 {
+		return "", errors.New("compact JWS serialization requires a protected header")
+	}
+	signatureString, err := lookupString("signature", signature)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{protected, payload, signatureString}, "."), nil
+}
+
+// ParseCompactJWE parses a JWE in compact serialization (RFC 7516 §7.1), i.e.
+// `protected.encrypted_key.iv.ciphertext.tag`, into a DecodedJWE. Empty `encrypted_key`, `iv` and `tag` segments
+// result in the corresponding fields being absent, e.g. a JWE using direct encryption will have no `recipients`.
+func ParseCompactJWE(compact string) (DecodedJWE, error) {
+	segments := strings.Split(compact, ".")
+	if len(segments) != 5 {
+		return nil, fmt.Errorf("invalid compact JWE serialization: expected 5 segments, found %d", len(segments))
+	}
+	protected, err := decodeCompactSegment("protected", segments[0], false)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := decodeCompactSegment("encrypted_key", segments[1], true)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := decodeCompactSegment("iv", segments[2], true)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := decodeCompactSegment("ciphertext", segments[3], true)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := decodeCompactSegment("tag", segments[4], true)
+	if err != nil {
+		return nil, err
+	}
+	jwe, err := fluent.BuildMap(Type.DecodedJWE__Repr, 5, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("ciphertext").AssignBytes(ciphertext)
+		if len(iv) > 0 {
+			ma.AssembleEntry("iv").AssignBytes(iv)
+		}
+		ma.AssembleEntry("protected").AssignBytes(protected)
+		if len(encryptedKey) > 0 {
+			ma.AssembleEntry("recipients").CreateList(1, func(la fluent.ListAssembler) {
+				la.AssembleValue().CreateMap(1, func(ma fluent.MapAssembler) {
+					ma.AssembleEntry("encrypted_key").AssignBytes(encryptedKey)
+				})
+			})
+		}
+		if len(tag) > 0 {
+			ma.AssembleEntry("tag").AssignBytes(tag)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jwe.(DecodedJWE), nil
+}
+
+// CompactJWE renders a dag-jose JWE in compact serialization (RFC 7516 §7.1). The JWE may be in general or flattened
+// form. Compact serialization can only carry a protected header and at most one recipient without a per-recipient
+// header, so an error is returned if the JWE has multiple recipients, no protected header, or any of the `aad`,
+// `unprotected` or recipient `header` fields.
+func CompactJWE(n datamodel.Node) (string, error) {
+	n, err := unflattenJWE(n)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range []string{"aad", "unprotected"} {
+		if value, err := lookupIgnoreNoSuchField(key, n); err != nil {
+			return "", err
+		} else if (value != nil) && !value.IsNull() {
+			return "", fmt.Errorf("compact JWE serialization cannot carry `%s`", key)
+		}
+	}
+	encryptedKey := ""
+	if recipients, err := lookupIgnoreAbsent("recipients", n); err != nil {
+		return "", err
+	} else if recipients != nil {
+		if recipients.Length() > 1 {
+			return "", errors.New("compact JWE serialization allows at most one recipient")
+		} else if recipients.Length() == 1 {
+			if recipient, err := recipients.LookupByIndex(0); err != nil {
+				return "", err
+			} else if header, err := lookupIgnoreNoSuchField("header", recipient); err != nil {
+				return "", err
+			} else if (header != nil) && !header.IsNull() {
+				return "", errors.New("compact JWE serialization cannot carry a recipient header")
+			} else if encryptedKey, err = lookupString("encrypted_key", recipient); err != nil {
+				return "", err
+			}
+		}
+	}
+	protected, err := lookupString("protected", n)
+	if err != nil {
+		return "", err
+	} else if len(protected) == 0 {
+		return "", errors.New("compact JWE serialization requires a protected header")
+	}
+	iv, err := lookupString("iv", n)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := lookupString("ciphertext", n)
+	if err != nil {
+		return "", err
+	}
+	tag, err := lookupString("tag", n)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{protected, encryptedKey, iv, ciphertext, tag}, "."), nil
+}
+
+// decodeCompactSegment decodes a single base64url-encoded segment of a compact serialization. Empty segments are only
+// accepted if allowEmpty is set.
+func decodeCompactSegment(name string, segment string, allowEmpty bool) ([]byte, error) {
+	if (len(segment) == 0) && !allowEmpty {
+		return nil, fmt.Errorf("invalid compact serialization: %s must not be empty", name)
+	}
+	if decoded, err := decodeBase64Url(segment); err != nil {
+		return nil, fmt.Errorf("invalid compact serialization: %s is not base64url-encoded: %v", name, err)
+	} else {
+		return decoded, nil
+	}
+}
+
+// lookupString returns the base64url-encoded string form of a field, returning an empty string if the field is absent.
+func lookupString(key string, n datamodel.Node) (string, error) {
+	if value, err := lookupIgnoreNoSuchField(key, n); err != nil {
+		return "", err
+	} else if (value == nil) || value.IsNull() {
+		return "", nil
+	} else if value.Kind() == datamodel.Kind_Bytes {
+		if valueBytes, err := value.AsBytes(); err != nil {
+			return "", err
+		} else {
+			return encodeBase64Url(valueBytes), nil
+		}
+	} else {
+		return value.AsString()
+	}
+}
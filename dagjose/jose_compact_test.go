@@ -0,0 +1,152 @@
+package dagjose
+
+import (
+	"testing"
+
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"pgregory.net/rapid"
+)
+
+// A compact JWS produced by go-jose must survive a trip through dag-jose unchanged
+func TestRoundTripCompactJWS(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		link := cidGen().Draw(t, "compact JWS payload").(cid.Cid)
+		privateKey := ed25519PrivateKeyGen().Draw(t, "compact JWS private key").(ed25519.PrivateKey)
+		signer, err := gojose.NewSigner(gojose.SigningKey{Algorithm: gojose.EdDSA, Key: privateKey}, nil)
+		require.NoError(t, err)
+		signed, err := signer.Sign(link.Bytes())
+		require.NoError(t, err)
+		compact, err := signed.CompactSerialize()
+		require.NoError(t, err)
+
+		jws, err := ParseCompactJWS(compact)
+		require.NoError(t, err)
+		require.Equal(t, link, jws.FieldLink().Must().x.(cidlink.Link).Cid)
+
+		// The parsed node must be storable as a dag-jose block
+		encoded, err := ipld.Encode(jws, Encode)
+		require.NoError(t, err)
+		decoded, err := ipld.Decode(encoded, Decode)
+		require.NoError(t, err)
+
+		roundTripped, err := CompactJWS(decoded)
+		require.NoError(t, err)
+		require.Equal(t, compact, roundTripped)
+	})
+}
+
+func TestParseCompactJWSErrors(t *testing.T) {
+	payload := encodeBase64Url(createCid([]byte("payload")).Bytes())
+	protected := encodeBase64Url([]byte(`{"alg":"EdDSA"}`))
+	scenarios := map[string]string{
+		"expected 3 segments":     protected + "." + payload,
+		"protected must not be":   "." + payload + ".c2ln",
+		"signature must not be":   protected + "." + payload + ".",
+		"payload is not a valid":  protected + "." + encodeBase64Url([]byte("not a CID")) + ".c2ln",
+		"is not base64url-encode": protected + "." + payload + ".!!",
+	}
+	for expected, compact := range scenarios {
+		jws, err := ParseCompactJWS(compact)
+		require.ErrorContains(t, err, expected)
+		require.Nil(t, jws)
+	}
+}
+
+// Compact serialization can't represent multiple signatures or unprotected headers
+func TestCompactJWSErrors(t *testing.T) {
+	payload := encodeBase64Url(createCid([]byte("payload")).Bytes())
+	protected := encodeBase64Url([]byte(`{"alg":"EdDSA"}`))
+	scenarios := map[string]string{
+		"exactly one signature": `{"payload":"` + payload + `","signatures":[` +
+			`{"protected":"` + protected + `","signature":"c2ln"},{"protected":"` + protected + `","signature":"c2ln"}]}`,
+		"unprotected header":          `{"payload":"` + payload + `","protected":"` + protected + `","header":{"kid":"k"},"signature":"c2ln"}`,
+		"requires a protected header": `{"payload":"` + payload + `","signature":"c2ln"}`,
+	}
+	for expected, jsonStr := range scenarios {
+		jws, err := ParseJSON([]byte(jsonStr))
+		require.NoError(t, err)
+		_, err = CompactJWS(jws)
+		require.ErrorContains(t, err, expected)
+	}
+	jws, err := ParseJSON([]byte(`{"payload":"` + payload + `","protected":"` + protected + `","signature":"c2ln"}`))
+	require.NoError(t, err)
+	compact, err := CompactJWS(jws)
+	require.NoError(t, err)
+	require.Equal(t, protected+"."+payload+".c2ln", compact)
+}
+
+// A compact JWE produced by go-jose must survive a trip through dag-jose unchanged, including empty segments
+func TestRoundTripCompactJWE(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		key := rapid.ArrayOf(32, rapid.Byte()).Draw(t, "compact JWE key").([32]byte)
+		plaintext := nonNilSliceOfBytes().Draw(t, "compact JWE plaintext").([]byte)
+		algorithm := rapid.SampledFrom([]gojose.KeyAlgorithm{gojose.DIRECT, gojose.A256KW}).Draw(t, "key algorithm").(gojose.KeyAlgorithm)
+		encrypter, err := gojose.NewEncrypter(gojose.A256GCM, gojose.Recipient{Algorithm: algorithm, Key: key[:]}, nil)
+		require.NoError(t, err)
+		encrypted, err := encrypter.Encrypt(plaintext)
+		require.NoError(t, err)
+		compact, err := encrypted.CompactSerialize()
+		require.NoError(t, err)
+
+		jwe, err := ParseCompactJWE(compact)
+		require.NoError(t, err)
+		require.Equal(t, algorithm == gojose.DIRECT, jwe.FieldRecipients().IsAbsent())
+
+		// The parsed node must be storable as a dag-jose block
+		encoded, err := ipld.Encode(jwe, Encode)
+		require.NoError(t, err)
+		decoded, err := ipld.Decode(encoded, Decode)
+		require.NoError(t, err)
+
+		roundTripped, err := CompactJWE(decoded)
+		require.NoError(t, err)
+		require.Equal(t, compact, roundTripped)
+		parsed, err := gojose.ParseEncrypted(roundTripped, []gojose.KeyAlgorithm{algorithm}, []gojose.ContentEncryption{gojose.A256GCM})
+		require.NoError(t, err)
+		decrypted, err := parsed.Decrypt(key[:])
+		require.NoError(t, err)
+		require.Equal(t, plaintext, decrypted)
+	})
+}
+
+func TestParseCompactJWEErrors(t *testing.T) {
+	protected := encodeBase64Url([]byte(`{"alg":"dir","enc":"A256GCM"}`))
+	scenarios := map[string]string{
+		"expected 5 segments":     protected + "..aXY.Y3Q",
+		"protected must not be":   "..aXY.Y3Q.dGFn",
+		"is not base64url-encode": protected + ".!!.aXY.Y3Q.dGFn",
+	}
+	for expected, compact := range scenarios {
+		jwe, err := ParseCompactJWE(compact)
+		require.ErrorContains(t, err, expected)
+		require.Nil(t, jwe)
+	}
+}
+
+// Compact serialization can't represent multiple recipients, AAD or unprotected headers
+func TestCompactJWEErrors(t *testing.T) {
+	protected := encodeBase64Url([]byte(`{"enc":"A256GCM"}`))
+	scenarios := map[string]string{
+		"at most one recipient":       `{"ciphertext":"Y3Q","protected":"` + protected + `","recipients":[{"encrypted_key":"a2V5"},{"encrypted_key":"a2V5"}]}`,
+		"cannot carry a recipient":    `{"ciphertext":"Y3Q","protected":"` + protected + `","header":{"alg":"A256KW"},"encrypted_key":"a2V5"}`,
+		"cannot carry `aad`":          `{"ciphertext":"Y3Q","protected":"` + protected + `","aad":"YWFk"}`,
+		"cannot carry `unprotected`":  `{"ciphertext":"Y3Q","protected":"` + protected + `","unprotected":{"alg":"dir"}}`,
+		"requires a protected header": `{"ciphertext":"Y3Q","iv":"aXY","tag":"dGFn"}`,
+	}
+	for expected, jsonStr := range scenarios {
+		jwe, err := ParseJSON([]byte(jsonStr))
+		require.NoError(t, err)
+		_, err = CompactJWE(jwe)
+		require.ErrorContains(t, err, expected)
+	}
+	jwe, err := ParseJSON([]byte(`{"ciphertext":"Y3Q","protected":"` + protected + `","encrypted_key":"a2V5","tag":"dGFn"}`))
+	require.NoError(t, err)
+	compact, err := CompactJWE(jwe)
+	require.NoError(t, err)
+	require.Equal(t, protected+".a2V5..Y3Q.dGFn", compact)
+}
@@ -2,10 +2,14 @@ package dagjose
 
 import (
 	"bytes"
+	"errors"
 	"io"
 
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/node/mixins"
 	"github.com/ipld/go-ipld-prime/schema"
 )
 
@@ -14,20 +18,41 @@ import (
 type DecodeOptions struct {
 	// If true and the `payload` field is present, add a `link` field corresponding to the `payload`.
 	AddLink bool
+	// If true, accept JWS with a detached payload, i.e. without a `payload` field. As `payload` is required for a
+	// DecodedJWS, such a JWS is assembled as a map holding only its `signatures`, and fails to decode into a DecodedJWS
+	// builder. Its signatures can be verified with VerifyDetached.
+	DetachedPayload bool
+	// If true, only accept blocks in canonical form, i.e. blocks that encoding the decoded node reproduces exactly. This
+	// rejects e.g. maps not sorted as per RFC 7049, indefinite-length items, strings where bytes are expected and a
+	// `link` field stored in a JWS. Fields that are not part of a JWE or a JWS are rejected regardless of this option.
+	Strict bool
+
+	// The following limits protect against untrusted blocks demanding unbounded resources. Decoding fails with a
+	// LimitError as soon as a limit is found to be exceeded. Zero means no limit.
+
+	// MaxBlockSize is the maximum size of a block in bytes.
+	MaxBlockSize int64
+	// MaxSignatures is the maximum number of signatures of a JWS.
+	MaxSignatures int64
+	// MaxRecipients is the maximum number of recipients of a JWE.
+	MaxRecipients int64
+	// MaxHeaderDepth is the maximum nesting depth of maps and lists in a `header` or `unprotected` value, the value
+	// itself being at depth 1.
+	MaxHeaderDepth int
+	// MaxHeaderSize is the maximum number of values in a `header` or `unprotected` value, i.e. the value itself and every
+	// map entry and list element nested within it.
+	MaxHeaderSize int64
+	// MaxCiphertextSize is the maximum size of the ciphertext of a JWE in bytes, or in characters where it is stored as a
+	// string. Like the length of any byte or text string with MaxBlockSize, it is checked as soon as the ciphertext's
+	// length is read, before the ciphertext itself is.
+	MaxCiphertextSize int64
 }
 
 // Decode deserializes data from the given io.Reader and feeds it into the given datamodel.NodeAssembler. Decode fits
 // the codec.Decoder function interface.
 func (cfg DecodeOptions) Decode(na datamodel.NodeAssembler, r io.Reader) error {
-	// Since a JWE might be partially decoded by the time we figure out that this isn't a JWE and is probably a JWS, we
-	// can't pass the same reader to both decode methods. Instead, we read off all input bytes and use them in separate
-	// readers to the JWE/JWS decode methods.
-	if buf, err := io.ReadAll(r); err != nil {
-		return err
-	} else if err := cfg.DecodeJWE(na, bytes.NewReader(buf)); err != nil {
-		return cfg.DecodeJWS(na, bytes.NewReader(buf))
-	}
-	return nil
+	// The input is decoded in a single pass, with the first top-level key deciding whether a JWE or a JWS is assembled.
+	return cfg.decode(&joseAssembler{na: na, cfg: cfg}, r)
 }
 
 // Decode deserializes data from the given io.Reader and feeds it into the given datamodel.NodeAssembler. Decode fits
@@ -38,70 +63,339 @@ func Decode(na datamodel.NodeAssembler, r io.Reader) error {
 	}.Decode(na, r)
 }
 
-func (DecodeOptions) DecodeJWE(na datamodel.NodeAssembler, r io.Reader) error {
-	// Check for the fastpath where the passed assembler is already of type `_DecodedJWE__ReprBuilder` or
-	// `_DecodedJWE__ReprAssembler`.
-	copyRequired := false
-	jweBuilder, castOk := na.(*_DecodedJWE__ReprBuilder)
-	if !castOk {
-		// This could still be `_DecodedJWE__ReprAssembler`, so check for that.
-		_, castOk := na.(*_DecodedJWE__ReprAssembler)
-		if !castOk {
-			// No fastpath possible, just create a new `_DecodedJWE__ReprBuilder`, use it, then copy the built node into
-			// the assembler the caller passed in.
-			jweBuilder = Type.DecodedJWE__Repr.NewBuilder().(*_DecodedJWE__ReprBuilder)
-			copyRequired = true
-		}
+func (cfg DecodeOptions) DecodeJWE(na datamodel.NodeAssembler, r io.Reader) error {
+	ja := &joseAssembler{na: na, cfg: cfg}
+	ja.jweAssembler, ja.direct = newJWEAssembler(na)
+	return cfg.decode(ja, r)
+}
+
+func (cfg DecodeOptions) DecodeJWS(na datamodel.NodeAssembler, r io.Reader) error {
+	ja := &joseAssembler{na: na, cfg: cfg}
+	ja.jwsAssembler, ja.direct = newJWSAssembler(na)
+	return cfg.decode(ja, r)
+}
+
+// decode decodes a block with the given assembler, checks that the block is canonical in strict mode, and copies the
+// decoded JWE or JWS into the assembler the caller passed in.
+func (cfg DecodeOptions) decode(ja *joseAssembler, r io.Reader) error {
+	if cfg.MaxBlockSize > 0 {
+		r = &limitReader{r, cfg.MaxBlockSize, cfg.MaxBlockSize}
 	}
-	// DAG-CBOR is a superset of DAG-JOSE and can be used to decode valid DAG-JOSE objects.
-	// See: https://specs.ipld.io/block-layer/codecs/dag-jose.html
-	if err := dagcbor.Decode(jweBuilder, r); err != nil {
+	if (cfg.MaxBlockSize > 0) || (cfg.MaxCiphertextSize > 0) {
+		r = &lengthLimitReader{r: r, cfg: &cfg}
+	}
+	var block *bytes.Buffer
+	if cfg.Strict {
+		block = &bytes.Buffer{}
+		r = io.TeeReader(r, block)
+	}
+	if err := ja.decode(r); err != nil {
 		return err
 	}
-	// The "representation" node gives an accurate view of fields that are actually present
-	jweNode := jweBuilder.Build().(schema.TypedNode).Representation()
-	if copyRequired {
-		return datamodel.Copy(jweNode, na)
+	if cfg.Strict {
+		if err := ja.checkCanonical(block.Bytes()); err != nil {
+			return err
+		}
 	}
-	return nil
+	if ja.jweAssembler != nil {
+		return ja.finishJWE()
+	} else if ja.detached {
+		return ja.finishDetachedJWS()
+	}
+	return ja.finishJWS()
 }
 
-func (cfg DecodeOptions) DecodeJWS(na datamodel.NodeAssembler, r io.Reader) error {
-	// Check for the fastpath where the passed assembler is already of type `_DecodedJWS__ReprBuilder` or
-	// `_DecodedJWS__ReprAssembler`.
-	copyRequired := false
-	jwsBuilder, castOk := na.(*_DecodedJWS__ReprBuilder)
-	if !castOk {
-		// This could still be `_DecodedJWS__ReprAssembler`, so check for that.
-		_, castOk := na.(*_DecodedJWS__ReprAssembler)
-		if !castOk {
-			// No fastpath possible, just create a new `_DecodedJWE__ReprBuilder`, use it, then copy the built node into
-			// the assembler the caller passed in.
-			jwsBuilder = Type.DecodedJWS__Repr.NewBuilder().(*_DecodedJWS__ReprBuilder)
-			copyRequired = true
-		}
+// newJWEAssembler returns the assembler to decode a JWE with, and whether it is the passed assembler itself, which is
+// the case if it is already of type `_DecodedJWE__ReprBuilder` or `_DecodedJWE__ReprAssembler` (the fastpath).
+func newJWEAssembler(na datamodel.NodeAssembler) (*_DecodedJWE__ReprAssembler, bool) {
+	switch jweAssembler := na.(type) {
+	case *_DecodedJWE__ReprBuilder:
+		return &jweAssembler._DecodedJWE__ReprAssembler, true
+	case *_DecodedJWE__ReprAssembler:
+		return jweAssembler, true
 	}
-	// DAG-CBOR is a superset of DAG-JOSE and can be used to decode valid DAG-JOSE objects.
-	// See: https://specs.ipld.io/block-layer/codecs/dag-jose.html
-	if err := dagcbor.Decode(jwsBuilder, r); err != nil {
-		return err
+	// No fastpath possible, just create a new `_DecodedJWE__ReprBuilder` and copy the built node into the assembler the
+	// caller passed in once done.
+	return &Type.DecodedJWE__Repr.NewBuilder().(*_DecodedJWE__ReprBuilder)._DecodedJWE__ReprAssembler, false
+}
+
+// newJWSAssembler returns the assembler to decode a JWS with, and whether it is the passed assembler itself, which is
+// the case if it is already of type `_DecodedJWS__ReprBuilder` or `_DecodedJWS__ReprAssembler` (the fastpath).
+func newJWSAssembler(na datamodel.NodeAssembler) (*_DecodedJWS__ReprAssembler, bool) {
+	switch jwsAssembler := na.(type) {
+	case *_DecodedJWS__ReprBuilder:
+		return &jwsAssembler._DecodedJWS__ReprAssembler, true
+	case *_DecodedJWS__ReprAssembler:
+		return jwsAssembler, true
+	}
+	// No fastpath possible, just create a new `_DecodedJWS__ReprBuilder` and copy the built node into the assembler the
+	// caller passed in once done.
+	return &Type.DecodedJWS__Repr.NewBuilder().(*_DecodedJWS__ReprBuilder)._DecodedJWS__ReprAssembler, false
+}
+
+// finishJWE copies the decoded JWE into the assembler the caller passed in, unless it was decoded into it directly.
+func (ja *joseAssembler) finishJWE() error {
+	if ja.direct {
+		return nil
 	}
-	if cfg.AddLink {
+	// The "representation" node gives an accurate view of fields that are actually present
+	return datamodel.Copy(ja.jweAssembler.w.Representation(), ja.na)
+}
+
+// finishJWS adds the `link` field to the decoded JWS if requested, and copies the JWS into the assembler the caller
+// passed in, unless it was decoded into it directly.
+func (ja *joseAssembler) finishJWS() error {
+	if ja.cfg.AddLink {
 		// If `payload` is present but `link` is not, add `link` with the corresponding encoded CID.
-		linkNode := &jwsBuilder.w.link
+		linkNode := &ja.jwsAssembler.w.link
 		if !linkNode.Exists() {
-			if link, err := Type.Base64Url.Link(&jwsBuilder.w.payload); err != nil {
-				return err
+			if link, err := Type.Base64Url.Link(&ja.jwsAssembler.w.payload); err != nil {
+				return &Error{Kind: ErrInvalidJWS, Path: datamodel.ParsePath("payload"), Causes: []error{err}}
 			} else {
 				linkNode.m = schema.Maybe_Value
 				linkNode.v = *link
 			}
 		}
 	}
+	if ja.direct {
+		return nil
+	}
 	// The "representation" node gives an accurate view of fields that are actually present
-	jwsNode := jwsBuilder.Build().(schema.TypedNode).Representation()
-	if copyRequired {
-		return datamodel.Copy(jwsNode, na)
+	return datamodel.Copy(ja.jwsAssembler.w.Representation(), ja.na)
+}
+
+// finishDetachedJWS copies a decoded JWS with a detached payload into the assembler the caller passed in. Such a JWS
+// cannot be assembled as a DecodedJWS, so it is copied as a map holding only its `signatures`.
+func (ja *joseAssembler) finishDetachedJWS() error {
+	if ja.direct {
+		return &Error{Kind: ErrInvalidJWS, Path: datamodel.ParsePath("payload"), Causes: []error{errMissingPayload}}
+	}
+	return datamodel.Copy(detachedJWS(&ja.jwsAssembler.w.signatures), ja.na)
+}
+
+// detachedJWS returns a JWS with a detached payload holding the given signatures.
+func detachedJWS(signatures *_DecodedSignatures__Maybe) datamodel.Node {
+	return fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+		if signatures.Exists() {
+			ma.AssembleEntry("signatures").AssignNode(signatures.v.Representation())
+		}
+	})
+}
+
+// joseAssembler is the NodeAssembler used by Decode to assemble a JWE or a JWS in a single pass. The top-level keys of
+// JWEs and JWSs are disjoint, so the first key of the top-level map is enough to tell which of the two is being
+// decoded, unless an assembler was set up front. From then on, all entries are forwarded to the map assembler of the
+// JWE or JWS being assembled.
+type joseAssembler struct {
+	mixins.MapAssembler
+	na           datamodel.NodeAssembler
+	cfg          DecodeOptions
+	sizeHint     int64
+	jweAssembler *_DecodedJWE__ReprAssembler
+	jwsAssembler *_DecodedJWS__ReprAssembler
+	// direct is set if the JWE or JWS is assembled directly into the assembler the caller passed in.
+	direct bool
+	// detached is set once a JWS turns out to have a detached payload.
+	detached bool
+	ma       datamodel.MapAssembler
+	// key is the top-level key whose value is being assembled, used to report the path of decoding errors.
+	key string
+}
+
+// decode decodes a DAG-JOSE block into the assembler, reporting failures as an *Error.
+func (ja *joseAssembler) decode(r io.Reader) error {
+	// DAG-CBOR is a superset of DAG-JOSE and can be used to decode valid DAG-JOSE objects.
+	// See: https://specs.ipld.io/block-layer/codecs/dag-jose.html
+	if err := dagcbor.Decode(ja, r); err != nil {
+		var joseErr *Error
+		if errors.As(err, &joseErr) {
+			return err
+		} else if (ja.jweAssembler == nil) && (ja.jwsAssembler == nil) {
+			// The block is not a map, or is truncated before its first key
+			return &Error{Kind: ErrNotJOSE, Causes: []error{err}}
+		}
+		return ja.invalid(datamodel.ParsePath(ja.key), err)
+	}
+	return nil
+}
+
+// checkCanonical checks that encoding the decoded JWE or JWS reproduces the given block.
+func (ja *joseAssembler) checkCanonical(block []byte) error {
+	encoded := bytes.Buffer{}
+	var err error
+	if ja.jweAssembler != nil {
+		err = EncodeJWE(ja.jweAssembler.w.Representation(), &encoded)
+	} else if ja.jwsAssembler.w.link.Exists() {
+		// `link` is only ever added when decoding, and would otherwise be silently dropped when encoding.
+		return ja.invalid(datamodel.ParsePath("link"), errors.New("`link` must not be stored in a block"))
+	} else if ja.detached {
+		err = EncodeOptions{DetachedPayload: true}.EncodeJWS(detachedJWS(&ja.jwsAssembler.w.signatures), &encoded)
+	} else {
+		err = EncodeJWS(ja.jwsAssembler.w.Representation(), &encoded)
+	}
+	if err != nil {
+		return ja.invalid(datamodel.Path{}, err)
+	} else if !bytes.Equal(block, encoded.Bytes()) {
+		return ja.invalid(datamodel.Path{}, ErrNotCanonical)
+	}
+	return nil
+}
+
+// invalid returns an ErrInvalidJWE or ErrInvalidJWS error, depending on what is being assembled.
+func (ja *joseAssembler) invalid(path datamodel.Path, err error) error {
+	if ja.jweAssembler != nil {
+		return &Error{Kind: ErrInvalidJWE, Path: path, Causes: []error{err}}
+	}
+	return &Error{Kind: ErrInvalidJWS, Path: path, Causes: []error{err}}
+}
+
+func (ja *joseAssembler) BeginMap(sizeHint int64) (datamodel.MapAssembler, error) {
+	ja.sizeHint = sizeHint
+	return ja, nil
+}
+
+func (ja *joseAssembler) AssignNode(n datamodel.Node) error {
+	return datamodel.Copy(n, ja)
+}
+
+func (ja *joseAssembler) Prototype() datamodel.NodePrototype {
+	return basicnode.Prototype.Map
+}
+
+func (ja *joseAssembler) AssembleKey() datamodel.NodeAssembler {
+	if ja.ma != nil {
+		return ja.ma.AssembleKey()
+	}
+	return &joseKeyAssembler{mixins.StringAssembler{TypeName: "string"}, ja}
+}
+
+func (ja *joseAssembler) AssembleValue() datamodel.NodeAssembler {
+	return ja.ma.AssembleValue()
+}
+
+func (ja *joseAssembler) AssembleEntry(k string) (datamodel.NodeAssembler, error) {
+	if ja.ma == nil {
+		if err := ja.dispatch(k); err != nil {
+			return nil, err
+		}
+	}
+	va, err := ja.ma.AssembleEntry(k)
+	if err != nil {
+		return nil, ja.invalid(datamodel.ParsePath(k), err)
+	}
+	ja.key = k
+	return ja.cfg.limit(k, va), nil
+}
+
+func (ja *joseAssembler) Finish() error {
+	ja.key = ""
+	if ja.ma == nil {
+		if (ja.jweAssembler == nil) && (ja.jwsAssembler == nil) {
+			return notJOSE("", func(ma datamodel.MapAssembler) error {
+				if err := ma.Finish(); err != nil {
+					return err
+				}
+				// Only a JWS can be empty, with a detached payload and no signatures, but an empty map is not JOSE
+				return errMissingPayload
+			})
+		} else if err := ja.dispatch(""); err != nil {
+			return err
+		}
+	}
+	if err := ja.ma.Finish(); err != nil {
+		// `payload` is the only required field of a JWS, so a JWS failing only for the lack of it has a detached payload
+		var missing schema.ErrMissingRequiredField
+		if (ja.jwsAssembler != nil) && ja.cfg.DetachedPayload && errors.As(err, &missing) && !ja.jwsAssembler.w.link.Exists() {
+			ja.detached = true
+			return nil
+		}
+		return ja.invalid(fieldPath(err, ""), err)
+	}
+	return nil
+}
+
+func (ja *joseAssembler) KeyPrototype() datamodel.NodePrototype {
+	return basicnode.Prototype.String
+}
+
+func (ja *joseAssembler) ValuePrototype(k string) datamodel.NodePrototype {
+	if ja.ma != nil {
+		return ja.ma.ValuePrototype(k)
+	}
+	return basicnode.Prototype.Any
+}
+
+// dispatch starts assembling a JWE or a JWS depending on the first top-level key, unless an assembler was set up
+// front.
+func (ja *joseAssembler) dispatch(k string) error {
+	if (ja.jweAssembler == nil) && (ja.jwsAssembler == nil) {
+		switch k {
+		case "aad", "ciphertext", "iv", "protected", "recipients", "tag", "unprotected":
+			ja.jweAssembler, ja.direct = newJWEAssembler(ja.na)
+		case "link", "payload", "signatures":
+			ja.jwsAssembler, ja.direct = newJWSAssembler(ja.na)
+		default:
+			return notJOSE(k, func(ma datamodel.MapAssembler) error {
+				_, err := ma.AssembleEntry(k)
+				return err
+			})
+		}
+	}
+	var err error
+	if ja.jweAssembler != nil {
+		ja.ma, err = ja.jweAssembler.BeginMap(ja.sizeHint)
+	} else {
+		ja.ma, err = ja.jwsAssembler.BeginMap(ja.sizeHint)
+	}
+	if err != nil {
+		return ja.invalid(datamodel.Path{}, err)
 	}
 	return nil
 }
+
+// notJOSE returns an ErrNotJOSE error for a top-level map that was found to be neither a JWE nor a JWS by its first key,
+// or by being empty. The reasons it is neither are found by running assemble against both a JWE and a JWS builder.
+func notJOSE(k string, assemble func(datamodel.MapAssembler) error) error {
+	causes := make([]error, 0, 2)
+	for _, candidate := range []struct {
+		kind error
+		np   datamodel.NodePrototype
+	}{
+		{ErrInvalidJWE, Type.DecodedJWE__Repr},
+		{ErrInvalidJWS, Type.DecodedJWS__Repr},
+	} {
+		ma, err := candidate.np.NewBuilder().BeginMap(0)
+		if err == nil {
+			err = assemble(ma)
+		}
+		if err != nil {
+			causes = append(causes, &Error{Kind: candidate.kind, Path: fieldPath(err, k), Causes: []error{err}})
+		}
+	}
+	return &Error{Kind: ErrNotJOSE, Path: datamodel.ParsePath(k), Causes: causes}
+}
+
+// joseKeyAssembler assembles the first top-level key for a joseAssembler when keys and values are assembled separately.
+type joseKeyAssembler struct {
+	mixins.StringAssembler
+	ja *joseAssembler
+}
+
+func (ka *joseKeyAssembler) AssignString(k string) error {
+	if err := ka.ja.dispatch(k); err != nil {
+		return err
+	}
+	return ka.ja.ma.AssembleKey().AssignString(k)
+}
+
+func (ka *joseKeyAssembler) AssignNode(n datamodel.Node) error {
+	if k, err := n.AsString(); err != nil {
+		return err
+	} else {
+		return ka.AssignString(k)
+	}
+}
+
+func (ka *joseKeyAssembler) Prototype() datamodel.NodePrototype {
+	return basicnode.Prototype.String
+}
@@ -0,0 +1,409 @@
+package dagjose
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"pgregory.net/rapid"
+)
+
+// legacyDecode is the previous implementation of Decode, which buffers the whole block and decodes it as a JWS if
+// decoding it as a JWE fails. It is kept to check that Decode still produces the same nodes, and to benchmark against.
+func legacyDecode(na datamodel.NodeAssembler, r io.Reader) error {
+	if buf, err := io.ReadAll(r); err != nil {
+		return err
+	} else if err := legacyDecodeJWE(na, bytes.NewReader(buf)); err != nil {
+		return legacyDecodeJWS(na, bytes.NewReader(buf))
+	}
+	return nil
+}
+
+func legacyDecodeJWE(na datamodel.NodeAssembler, r io.Reader) error {
+	// Check for the fastpath where the passed assembler is already of type `_DecodedJWE__ReprBuilder` or
+	// `_DecodedJWE__ReprAssembler`.
+	copyRequired := false
+	jweBuilder, castOk := na.(*_DecodedJWE__ReprBuilder)
+	if !castOk {
+		// This could still be `_DecodedJWE__ReprAssembler`, so check for that.
+		_, castOk := na.(*_DecodedJWE__ReprAssembler)
+		if !castOk {
+			// No fastpath possible, just create a new `_DecodedJWE__ReprBuilder`, use it, then copy the built node into
+			// the assembler the caller passed in.
+			jweBuilder = Type.DecodedJWE__Repr.NewBuilder().(*_DecodedJWE__ReprBuilder)
+			copyRequired = true
+		}
+	}
+	// DAG-CBOR is a superset of DAG-JOSE and can be used to decode valid DAG-JOSE objects.
+	// See: https://specs.ipld.io/block-layer/codecs/dag-jose.html
+	if err := dagcbor.Decode(jweBuilder, r); err != nil {
+		return err
+	}
+	// The "representation" node gives an accurate view of fields that are actually present
+	jweNode := jweBuilder.Build().(schema.TypedNode).Representation()
+	if copyRequired {
+		return datamodel.Copy(jweNode, na)
+	}
+	return nil
+}
+
+func legacyDecodeJWS(na datamodel.NodeAssembler, r io.Reader) error {
+	// Check for the fastpath where the passed assembler is already of type `_DecodedJWS__ReprBuilder` or
+	// `_DecodedJWS__ReprAssembler`.
+	copyRequired := false
+	jwsBuilder, castOk := na.(*_DecodedJWS__ReprBuilder)
+	if !castOk {
+		// This could still be `_DecodedJWS__ReprAssembler`, so check for that.
+		_, castOk := na.(*_DecodedJWS__ReprAssembler)
+		if !castOk {
+			// No fastpath possible, just create a new `_DecodedJWE__ReprBuilder`, use it, then copy the built node into
+			// the assembler the caller passed in.
+			jwsBuilder = Type.DecodedJWS__Repr.NewBuilder().(*_DecodedJWS__ReprBuilder)
+			copyRequired = true
+		}
+	}
+	// DAG-CBOR is a superset of DAG-JOSE and can be used to decode valid DAG-JOSE objects.
+	// See: https://specs.ipld.io/block-layer/codecs/dag-jose.html
+	if err := dagcbor.Decode(jwsBuilder, r); err != nil {
+		return err
+	}
+	// If `payload` is present but `link` is not, add `link` with the corresponding encoded CID.
+	linkNode := &jwsBuilder.w.link
+	if !linkNode.Exists() {
+		if link, err := Type.Base64Url.Link(&jwsBuilder.w.payload); err != nil {
+			return err
+		} else {
+			linkNode.m = schema.Maybe_Value
+			linkNode.v = *link
+		}
+	}
+	// The "representation" node gives an accurate view of fields that are actually present
+	jwsNode := jwsBuilder.Build().(schema.TypedNode).Representation()
+	if copyRequired {
+		return datamodel.Copy(jwsNode, na)
+	}
+	return nil
+}
+
+// Decoding in a single pass produces the same nodes as decoding a JWE and falling back to a JWS
+func TestSinglePassDecode(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		jose := arbitraryJoseGen().Draw(t, "an arbitrary JOSE object").(datamodel.Node)
+		encoded, err := ipld.Encode(jose, Encode)
+		require.NoError(t, err)
+		expected, err := ipld.Decode(encoded, legacyDecode)
+		require.NoError(t, err)
+		actual, err := ipld.Decode(encoded, Decode)
+		require.NoError(t, err)
+		require.True(t, datamodel.DeepEqual(expected, actual))
+
+		// Decoding directly into a typed builder works as well
+		var nb datamodel.NodeBuilder
+		if _, isJWE := jose.(*_EncodedJWE__Repr); isJWE {
+			nb = Type.DecodedJWE__Repr.NewBuilder()
+		} else {
+			nb = Type.DecodedJWS__Repr.NewBuilder()
+		}
+		require.NoError(t, Decode(nb, bytes.NewReader(encoded)))
+		require.True(t, datamodel.DeepEqual(expected, nb.Build().(schema.TypedNode).Representation()))
+
+		// And directly into a typed assembler, e.g. the one of a field holding a JWE or a JWS
+		var decoded schema.TypedNode
+		if _, isJWE := jose.(*_EncodedJWE__Repr); isJWE {
+			jweAssembler := &Type.DecodedJWE__Repr.NewBuilder().(*_DecodedJWE__ReprBuilder)._DecodedJWE__ReprAssembler
+			require.NoError(t, Decode(jweAssembler, bytes.NewReader(encoded)))
+			decoded = jweAssembler.w
+		} else {
+			jwsAssembler := &Type.DecodedJWS__Repr.NewBuilder().(*_DecodedJWS__ReprBuilder)._DecodedJWS__ReprAssembler
+			require.NoError(t, Decode(jwsAssembler, bytes.NewReader(encoded)))
+			decoded = jwsAssembler.w
+		}
+		require.True(t, datamodel.DeepEqual(expected, decoded.Representation()))
+	})
+}
+
+// Typed builders and assemblers of the decoded types are decoded into directly, other assemblers get a copy
+func TestDecodeFastpath(t *testing.T) {
+	jweBuilder := Type.DecodedJWE__Repr.NewBuilder().(*_DecodedJWE__ReprBuilder)
+	for _, na := range []datamodel.NodeAssembler{jweBuilder, &jweBuilder._DecodedJWE__ReprAssembler} {
+		_, direct := newJWEAssembler(na)
+		require.True(t, direct)
+	}
+	jwsBuilder := Type.DecodedJWS__Repr.NewBuilder().(*_DecodedJWS__ReprBuilder)
+	for _, na := range []datamodel.NodeAssembler{jwsBuilder, &jwsBuilder._DecodedJWS__ReprAssembler} {
+		_, direct := newJWSAssembler(na)
+		require.True(t, direct)
+	}
+	_, direct := newJWEAssembler(basicnode.Prototype.Any.NewBuilder())
+	require.False(t, direct)
+	_, direct = newJWSAssembler(Type.DecodedJWS.NewBuilder())
+	require.False(t, direct)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, n := range map[string]datamodel.Node{
+		"empty map": fluent.MustBuildMap(basicnode.Prototype.Map, 0, func(ma fluent.MapAssembler) {}),
+		"unexpected field": fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("claims").AssignString("value")
+		}),
+		"JWE with JWS field": fluent.MustBuildMap(basicnode.Prototype.Map, 2, func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("ciphertext").AssignBytes([]byte("ciphertext"))
+			ma.AssembleEntry("payload").AssignBytes([]byte("payload"))
+		}),
+		"not a map": basicnode.NewString("jose"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := ipld.Encode(n, dagcbor.Encode)
+			require.NoError(t, err)
+			_, err = ipld.Decode(encoded, Decode)
+			require.Error(t, err)
+		})
+	}
+}
+
+// Decoding into an assembler that is not a dag-jose builder, e.g. when copying a block into a generic node, assembles
+// keys and values separately.
+func TestDecodeCopy(t *testing.T) {
+	jwe, err := EncryptBlock([]byte("cleartext block"), A256GCM, RecipientKey{Key: make([]byte, 32)})
+	require.NoError(t, err)
+	nb := basicnode.Prototype.Any.NewBuilder()
+	require.NoError(t, datamodel.Copy(jwe.Representation(), &joseAssembler{na: nb}))
+	require.Error(t, datamodel.Copy(basicnode.NewString("jose"), &joseAssembler{na: nb}))
+}
+
+// Blocks produced by Encode are canonical and are decoded the same in strict mode
+func TestStrictDecode(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		jose := arbitraryJoseGen().Draw(t, "an arbitrary JOSE object").(datamodel.Node)
+		encoded, err := ipld.Encode(jose, Encode)
+		require.NoError(t, err)
+		expected, err := ipld.Decode(encoded, Decode)
+		require.NoError(t, err)
+		actual, err := ipld.Decode(encoded, DecodeOptions{AddLink: true, Strict: true}.Decode)
+		require.NoError(t, err)
+		require.True(t, datamodel.DeepEqual(expected, actual))
+	})
+}
+
+func TestStrictDecodeErrors(t *testing.T) {
+	payload := createCid([]byte("payload"))
+	signatures := fluent.MustBuildList(basicnode.Prototype.List, 1, func(la fluent.ListAssembler) {
+		la.AssembleValue().CreateMap(1, func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("signature").AssignBytes([]byte("signature"))
+		})
+	})
+	for name, scenario := range map[string]struct {
+		mapSortMode codec.MapSortMode
+		build       func(ma fluent.MapAssembler)
+		path        string
+	}{
+		"unsorted map": {
+			codec.MapSortMode_None,
+			func(ma fluent.MapAssembler) {
+				ma.AssembleEntry("signatures").AssignNode(signatures)
+				ma.AssembleEntry("payload").AssignBytes(payload.Bytes())
+			},
+			"",
+		},
+		"string instead of bytes": {
+			codec.MapSortMode_RFC7049,
+			func(ma fluent.MapAssembler) {
+				ma.AssembleEntry("payload").AssignString(encodeBase64Url(payload.Bytes()))
+				ma.AssembleEntry("signatures").AssignNode(signatures)
+			},
+			"",
+		},
+		"stored link": {
+			codec.MapSortMode_RFC7049,
+			func(ma fluent.MapAssembler) {
+				ma.AssembleEntry("link").AssignLink(cidlink.Link{Cid: payload})
+				ma.AssembleEntry("payload").AssignBytes(payload.Bytes())
+				ma.AssembleEntry("signatures").AssignNode(signatures)
+			},
+			"link",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			block, err := ipld.Encode(
+				fluent.MustBuildMap(basicnode.Prototype.Map, 3, scenario.build),
+				dagcbor.EncodeOptions{AllowLinks: true, MapSortMode: scenario.mapSortMode}.Encode,
+			)
+			require.NoError(t, err)
+			// Such blocks are accepted unless decoding in strict mode
+			_, err = ipld.Decode(block, Decode)
+			require.NoError(t, err)
+			_, err = ipld.Decode(block, DecodeOptions{Strict: true}.Decode)
+			require.ErrorIs(t, err, ErrInvalidJWS)
+			if len(scenario.path) == 0 {
+				require.ErrorIs(t, err, ErrNotCanonical)
+			}
+			requireErrorPath(t, err, scenario.path)
+		})
+	}
+}
+
+// A JWS with a detached payload is encoded without `payload`, and only decoded with DetachedPayload set
+func TestDetachedPayload(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{Key: edKey})
+	require.NoError(t, err)
+	detached, err := ipld.Encode(jws, EncodeOptions{DetachedPayload: true}.Encode)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(detached, []byte("payload")))
+
+	_, err = ipld.Decode(detached, Decode)
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	requireErrorPath(t, err, "payload")
+
+	// The JWS is decoded as a map holding only its signatures, without `link`, and the block is canonical
+	cfg := DecodeOptions{AddLink: true, DetachedPayload: true, Strict: true}
+	decoded, err := ipld.Decode(detached, cfg.Decode)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), decoded.Length())
+	_, err = decoded.LookupByString("signatures")
+	require.NoError(t, err)
+	jwsStruct, err := AsJWS(decoded)
+	require.NoError(t, err)
+	require.False(t, jwsStruct.Payload.Defined())
+	require.Len(t, jwsStruct.Signatures, 1)
+
+	// Encoding a JWS without `payload` requires DetachedPayload as well
+	_, err = ipld.Encode(decoded, Encode)
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	requireErrorPath(t, err, "payload")
+	reencoded, err := ipld.Encode(decoded, EncodeOptions{DetachedPayload: true}.Encode)
+	require.NoError(t, err)
+	require.Equal(t, detached, reencoded)
+
+	// A JWS with a payload decodes as usual
+	attached, err := ipld.Encode(jws, Encode)
+	require.NoError(t, err)
+	n, err := ipld.Decode(attached, cfg.Decode)
+	require.NoError(t, err)
+	_, err = n.LookupByString("link")
+	require.NoError(t, err)
+
+	// `link` is never a substitute for `payload`
+	_, err = ipld.Encode(fluent.MustBuildMap(basicnode.Prototype.Map, 2, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("link").AssignLink(cidlink.Link{Cid: createCid([]byte("payload"))})
+		ma.AssembleEntry("signatures").CreateList(0, func(la fluent.ListAssembler) {})
+	}), EncodeOptions{DetachedPayload: true}.Encode)
+	require.Error(t, err)
+}
+
+// `payload` is required for a DecodedJWS however it is assembled, including with DetachedPayload set
+func TestDetachedPayloadTypedNodes(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{Key: edKey})
+	require.NoError(t, err)
+	detached, err := ipld.Encode(jws, EncodeOptions{DetachedPayload: true}.Encode)
+	require.NoError(t, err)
+	cfg := DecodeOptions{DetachedPayload: true}
+
+	for _, np := range []datamodel.NodePrototype{Type.DecodedJWS__Repr, Type.DecodedJWS} {
+		_, err = ipld.DecodeUsingPrototype(detached, cfg.Decode, np)
+		require.Error(t, err)
+	}
+	err = cfg.DecodeJWS(Type.DecodedJWS__Repr.NewBuilder(), bytes.NewReader(detached))
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	requireErrorPath(t, err, "payload")
+
+	decoded, err := ipld.Decode(detached, cfg.Decode)
+	require.NoError(t, err)
+	require.Error(t, datamodel.Copy(decoded, Type.DecodedJWS__Repr.NewBuilder()))
+	_, err = asDecodedJWS(decoded)
+	require.Error(t, err)
+	jwsStruct, err := AsJWS(decoded)
+	require.NoError(t, err)
+	_, err = jwsStruct.Decoded()
+	require.Error(t, err)
+
+	// Loading a detached block through a LinkSystem only succeeds without a DecodedJWS prototype
+	ls := memoryLinkSystem()
+	ls.DecoderChooser = func(ipld.Link) (ipld.Decoder, error) {
+		return cfg.Decode, nil
+	}
+	linkCid, err := LinkPrototype.Sum(detached)
+	require.NoError(t, err)
+	link := cidlink.Link{Cid: linkCid}
+	w, commit, err := ls.StorageWriteOpener(ipld.LinkContext{})
+	require.NoError(t, err)
+	_, err = w.Write(detached)
+	require.NoError(t, err)
+	require.NoError(t, commit(link))
+	_, err = ls.Load(ipld.LinkContext{}, link, Type.DecodedJWS__Repr)
+	require.Error(t, err)
+	_, err = ls.Load(ipld.LinkContext{}, link, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	_, err = LoadJWS(link, ipld.LinkContext{}, ls)
+	require.Error(t, err)
+}
+
+func benchmarkDecode(b *testing.B, decode ipld.Decoder, jose datamodel.Node) {
+	encoded, err := ipld.Encode(jose, Encode)
+	require.NoError(b, err)
+	b.SetBytes(int64(len(encoded)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ipld.Decode(encoded, decode); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// A JWE with a large ciphertext and many recipients, which is where buffering and decoding twice costs the most
+func benchmarkJWE(b *testing.B) datamodel.Node {
+	block := make([]byte, 1<<20)
+	_, err := rand.Read(block)
+	require.NoError(b, err)
+	recipients := make([]RecipientKey, 16)
+	for idx := range recipients {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		require.NoError(b, err)
+		recipients[idx] = RecipientKey{Key: key.PublicKey(), Algorithm: ECDHESXC20PKW}
+	}
+	jwe, err := EncryptBlock(block, XC20P, recipients...)
+	require.NoError(b, err)
+	return jwe
+}
+
+func benchmarkJWS(b *testing.B) datamodel.Node {
+	keys := make([]SigningKey, 16)
+	for idx := range keys {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(b, err)
+		keys[idx] = SigningKey{Key: key}
+	}
+	jws, err := SignJWS(createCid([]byte("payload")), keys...)
+	require.NoError(b, err)
+	return jws
+}
+
+func BenchmarkDecodeJWE(b *testing.B) {
+	benchmarkDecode(b, Decode, benchmarkJWE(b))
+}
+
+func BenchmarkLegacyDecodeJWE(b *testing.B) {
+	benchmarkDecode(b, legacyDecode, benchmarkJWE(b))
+}
+
+func BenchmarkDecodeJWS(b *testing.B) {
+	benchmarkDecode(b, Decode, benchmarkJWS(b))
+}
+
+func BenchmarkLegacyDecodeJWS(b *testing.B) {
+	benchmarkDecode(b, legacyDecode, benchmarkJWS(b))
+}
@@ -0,0 +1,353 @@
+package dagjose
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	josecipher "github.com/go-jose/go-jose/v4/cipher"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+var (
+	// ErrNoDecryptionKey is returned when none of the available keys could be used with any recipient of a JWE.
+	ErrNoDecryptionKey = errors.New("no key available to decrypt JWE")
+	// ErrDecryptionFailed is returned when none of the available keys decrypted a JWE, e.g. because the keys are not
+	// those of a recipient or the JWE has been tampered with.
+	ErrDecryptionFailed = errors.New("JWE decryption failed")
+)
+
+// DecryptOptions can be used to customize how a JWE is decrypted. The Decrypt and DecryptBlock methods on this struct
+// decrypt a JWE with the configured keys.
+type DecryptOptions struct {
+	// Keys are the private keys to decrypt with. The following are supported: *ecdsa.PrivateKey and *ecdh.PrivateKey
+	// for key agreement, *rsa.PrivateKey or any crypto.Decrypter with an RSA public key for key encryption, []byte for
+	// direct encryption, and gojose.JSONWebKey (or a pointer to one) holding one of these.
+	Keys []interface{}
+	// Resolver is consulted for the keys of recipients with a `kid` header parameter.
+	Resolver KeyResolver
+	// Codec is the multicodec used by Decrypt to decode the cleartext block. Defaults to the codec of the `cid`
+	// protected header parameter if present, and to dag-cbor otherwise.
+	Codec uint64
+	// VerifyCID requires the protected header to carry a `cid` parameter and checks that it is the CID of the
+	// cleartext block.
+	VerifyCID bool
+}
+
+// Decrypt decrypts the given JWE and decodes the cleartext block into the given assembler with the configured codec.
+// The JWE may be a DecodedJWE or any node that can be assembled into one, e.g. the node returned by Decode.
+func (cfg DecryptOptions) Decrypt(n datamodel.Node, na datamodel.NodeAssembler) error {
+	block, blockCid, err := cfg.decrypt(n)
+	if err != nil {
+		return err
+	}
+	codec := cfg.Codec
+	if codec == 0 {
+		if blockCid.Defined() {
+			codec = blockCid.Prefix().Codec
+		} else {
+			codec = cid.DagCBOR
+		}
+	}
+	decoder, err := multicodec.LookupDecoder(codec)
+	if err != nil {
+		return err
+	}
+	return decoder(na, bytes.NewReader(block))
+}
+
+// DecryptBlock decrypts the given JWE and returns the cleartext block.
+func (cfg DecryptOptions) DecryptBlock(n datamodel.Node) ([]byte, error) {
+	block, _, err := cfg.decrypt(n)
+	return block, err
+}
+
+// Decrypt decrypts the given JWE with the given private keys and decodes the cleartext block into the given
+// assembler. See DecryptOptions.Decrypt.
+func Decrypt(jwe datamodel.Node, na datamodel.NodeAssembler, keys ...interface{}) error {
+	return DecryptOptions{Keys: keys}.Decrypt(jwe, na)
+}
+
+// DecryptBlock decrypts the given JWE with the given private keys and returns the cleartext block. See
+// DecryptOptions.DecryptBlock.
+func DecryptBlock(jwe datamodel.Node, keys ...interface{}) ([]byte, error) {
+	return DecryptOptions{Keys: keys}.DecryptBlock(jwe)
+}
+
+// decrypt tries each recipient of the JWE in turn and returns the cleartext block along with the CID carried in the
+// protected header, if any.
+func (cfg DecryptOptions) decrypt(n datamodel.Node) ([]byte, cid.Cid, error) {
+	jwe, err := asDecodedJWE(n)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	var protected, iv, tag, aad []byte
+	if jwe.protected.Exists() {
+		protected = []byte(jwe.protected.v.x)
+	}
+	if jwe.iv.Exists() {
+		iv = []byte(jwe.iv.v.x)
+	}
+	if jwe.tag.Exists() {
+		tag = []byte(jwe.tag.v.x)
+	}
+	if jwe.aad.Exists() {
+		aad = []byte(jwe.aad.v.x)
+	}
+	sharedHeader, err := jwe.JOSEHeader()
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	enc := sharedHeader.EncryptionAlgorithm
+	if _, err := contentKeySize(enc); err != nil {
+		return nil, cid.Undef, err
+	}
+	blockCid, err := protectedCID(protected)
+	if err != nil {
+		return nil, cid.Undef, err
+	} else if cfg.VerifyCID && !blockCid.Defined() {
+		return nil, cid.Undef, errors.New("protected header has no `cid` parameter")
+	}
+	sealed := append(append([]byte{}, jwe.ciphertext.x...), tag...)
+	additionalData := jweAdditionalData(protected, aad)
+
+	// A JWE without recipients has a single implicit one, e.g. for direct encryption
+	numRecipients := 1
+	if jwe.recipients.Exists() && (len(jwe.recipients.v.x) > 0) {
+		numRecipients = len(jwe.recipients.v.x)
+	}
+	foundKey := false
+	for idx := 0; idx < numRecipients; idx++ {
+		header := sharedHeader
+		var encryptedKey []byte
+		if jwe.recipients.Exists() && (len(jwe.recipients.v.x) > 0) {
+			if header, err = jwe.RecipientHeader(idx); err != nil {
+				return nil, cid.Undef, err
+			}
+			if recipient := jwe.recipients.v.x[idx]; recipient.encrypted_key.Exists() {
+				encryptedKey = []byte(recipient.encrypted_key.v.x)
+			}
+		}
+		keys, err := cfg.candidateKeys(header.KeyID)
+		if err != nil {
+			return nil, cid.Undef, fmt.Errorf("resolving key %s: %w", header.KeyID, err)
+		}
+		for _, key := range keys {
+			cek, err := unwrapContentKey(header.Algorithm, key, encryptedKey, header.Extra)
+			if err != nil {
+				// A key that can be used with the algorithm but doesn't decrypt the encrypted key is the wrong key
+				foundKey = foundKey || errors.Is(err, ErrDecryptionFailed)
+				continue
+			}
+			foundKey = true
+			// A content encryption key of the wrong size can only come from a wrong key, like a failed authentication
+			contentCipher, err := newContentCipher(enc, cek)
+			if err != nil {
+				continue
+			} else if len(iv) != contentCipher.NonceSize() {
+				return nil, cid.Undef, fmt.Errorf("invalid JWE: %s requires a %d-byte iv", enc, contentCipher.NonceSize())
+			}
+			block, err := contentCipher.Open(nil, iv, sealed, additionalData)
+			if err != nil {
+				continue
+			}
+			if cfg.VerifyCID {
+				if computedCid, err := blockCid.Prefix().Sum(block); err != nil {
+					return nil, cid.Undef, err
+				} else if !computedCid.Equals(blockCid) {
+					return nil, cid.Undef, &Error{Kind: ErrCIDMismatch, Path: datamodel.ParsePath("protected/cid")}
+				}
+			}
+			return block, blockCid, nil
+		}
+	}
+	if !foundKey {
+		return nil, cid.Undef, ErrNoDecryptionKey
+	}
+	return nil, cid.Undef, ErrDecryptionFailed
+}
+
+// candidateKeys returns the private keys that may decrypt the content encryption key of a recipient with the given
+// key ID.
+func (cfg DecryptOptions) candidateKeys(kid string) ([]interface{}, error) {
+	var keys []interface{}
+	if (cfg.Resolver != nil) && (len(kid) > 0) {
+		if resolved, err := cfg.Resolver.ResolveKey(kid); err != nil {
+			return nil, err
+		} else {
+			keys = append(keys, resolved...)
+		}
+	}
+	return append(keys, cfg.Keys...), nil
+}
+
+// protectedCID returns the CID carried in the `cid` protected header parameter, or cid.Undef if there is none.
+func protectedCID(protected []byte) (cid.Cid, error) {
+	header, err := mergeHeaders(ErrInvalidJWE, protected)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if value, exists := header["cid"]; !exists {
+		return cid.Undef, nil
+	} else if cidString, castOk := value.(string); !castOk {
+		return cid.Undef, errors.New("invalid `cid` protected header parameter")
+	} else {
+		return cid.Decode(cidString)
+	}
+}
+
+// unwrapContentKey returns the content encryption key of a recipient using the given key management algorithm,
+// private key and algorithm-specific header parameters. An error wrapping ErrDecryptionFailed is returned if the key
+// can be used with the algorithm but does not decrypt the encrypted key.
+func unwrapContentKey(alg string, key interface{}, encryptedKey []byte, params map[string]interface{}) ([]byte, error) {
+	key, _ = unwrapJSONWebKey(key)
+	switch alg {
+	case Direct:
+		if symmetricKey, castOk := key.([]byte); !castOk {
+			return nil, fmt.Errorf("algorithm %s requires a symmetric key, found %T", alg, key)
+		} else {
+			return symmetricKey, nil
+		}
+	case RSAOAEP, RSAOAEP256:
+		decrypter, castOk := key.(crypto.Decrypter)
+		if !castOk {
+			return nil, fmt.Errorf("algorithm %s requires an RSA key, found %T", alg, key)
+		} else if _, castOk := decrypter.Public().(*rsa.PublicKey); !castOk {
+			return nil, fmt.Errorf("algorithm %s requires an RSA key, found %T", alg, decrypter.Public())
+		}
+		hash := crypto.SHA256
+		if alg == RSAOAEP {
+			hash = crypto.SHA1
+		}
+		if cek, err := decrypter.Decrypt(rand.Reader, encryptedKey, &rsa.OAEPOptions{Hash: hash}); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+		} else {
+			return cek, nil
+		}
+	case ECDHESA256KW, ECDHESXC20PKW:
+		var privateKey *ecdh.PrivateKey
+		switch k := key.(type) {
+		case *ecdh.PrivateKey:
+			privateKey = k
+		case *ecdsa.PrivateKey:
+			var err error
+			if privateKey, err = k.ECDH(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported key agreement key type: %T", key)
+		}
+		ephemeralKey, err := parseEphemeralKey(params["epk"], privateKey.Curve())
+		if err != nil {
+			return nil, err
+		}
+		kek, err := deriveKeyEncryptionKey(alg, privateKey, ephemeralKey)
+		if err != nil {
+			return nil, err
+		}
+		return unwrapWithKeyEncryptionKey(alg, kek, encryptedKey, params)
+	}
+	return nil, fmt.Errorf("unsupported key management algorithm: %s", alg)
+}
+
+// unwrapWithKeyEncryptionKey decrypts the content encryption key of a recipient with the key encryption key derived
+// through key agreement.
+func unwrapWithKeyEncryptionKey(alg string, kek []byte, encryptedKey []byte, params map[string]interface{}) ([]byte, error) {
+	if alg == ECDHESA256KW {
+		block, err := aes.NewCipher(kek)
+		if err != nil {
+			return nil, err
+		}
+		if cek, err := josecipher.KeyUnwrap(block, encryptedKey); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+		} else {
+			return cek, nil
+		}
+	}
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := decodeHeaderBytes(params, "iv")
+	if err != nil {
+		return nil, err
+	} else if len(iv) != aead.NonceSize() {
+		return nil, fmt.Errorf("algorithm %s requires a %d-byte `iv` header parameter", alg, aead.NonceSize())
+	}
+	tag, err := decodeHeaderBytes(params, "tag")
+	if err != nil {
+		return nil, err
+	}
+	if cek, err := aead.Open(nil, iv, append(append([]byte{}, encryptedKey...), tag...), nil); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	} else {
+		return cek, nil
+	}
+}
+
+// parseEphemeralKey returns the public key held by the `epk` header parameter, which must be on the given curve.
+func parseEphemeralKey(epk interface{}, curve ecdh.Curve) (*ecdh.PublicKey, error) {
+	jwk, castOk := epk.(map[string]interface{})
+	if !castOk {
+		return nil, errors.New("missing or invalid `epk` header parameter")
+	}
+	if crv, _ := jwk["crv"].(string); crv != fmt.Sprint(curve) {
+		return nil, fmt.Errorf("`epk` curve %s does not match key curve %s", crv, curve)
+	}
+	x, err := decodeHeaderBytes(jwk, "x")
+	if err != nil {
+		return nil, err
+	}
+	if curve == ecdh.X25519() {
+		return curve.NewPublicKey(x)
+	}
+	y, err := decodeHeaderBytes(jwk, "y")
+	if err != nil {
+		return nil, err
+	} else if (len(x) != len(y)) || (len(x) == 0) {
+		return nil, errors.New("invalid `epk` header parameter")
+	}
+	// NIST curve public keys are parsed in uncompressed form, i.e. 0x04 followed by the X and Y coordinates
+	return curve.NewPublicKey(append(append([]byte{4}, x...), y...))
+}
+
+// decodeHeaderBytes returns the bytes held by a base64url-encoded header parameter.
+func decodeHeaderBytes(header map[string]interface{}, name string) ([]byte, error) {
+	if encoded, castOk := header[name].(string); !castOk {
+		return nil, fmt.Errorf("missing or invalid `%s` header parameter", name)
+	} else if decoded, err := decodeBase64Url(encoded); err != nil {
+		return nil, fmt.Errorf("invalid `%s` header parameter: %v", name, err)
+	} else {
+		return decoded, nil
+	}
+}
+
+// asDecodedJWE returns the given node as a DecodedJWE, assembling a new one if needed.
+func asDecodedJWE(n datamodel.Node) (DecodedJWE, error) {
+	switch jwe := n.(type) {
+	case *_DecodedJWE:
+		return jwe, nil
+	case *_DecodedJWE__Repr:
+		return (*_DecodedJWE)(jwe), nil
+	}
+	if tn, castOk := n.(schema.TypedNode); castOk {
+		// The "representation" node gives an accurate view of fields that are actually present
+		n = tn.Representation()
+	}
+	jweBuilder := Type.DecodedJWE__Repr.NewBuilder()
+	if err := datamodel.Copy(n, jweBuilder); err != nil {
+		return nil, err
+	}
+	return jweBuilder.Build().(DecodedJWE), nil
+}
@@ -0,0 +1,166 @@
+package dagjose
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/require"
+	"pgregory.net/rapid"
+)
+
+// Encode and decode a JWE so that decryption runs against what would be read from a block
+func roundTripJWE(t require.TestingT, jwe datamodel.Node) datamodel.Node {
+	encoded, err := ipld.Encode(jwe, Encode)
+	require.NoError(t, err)
+	decoded, err := ipld.Decode(encoded, Decode)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestDecryptJWE(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rapid.Check(t, func(t *rapid.T) {
+		message := rapid.String().Draw(t, "cleartext message").(string)
+		cleartext := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("message").AssignString(message)
+		})
+		enc := rapid.SampledFrom([]string{A128GCM, A192GCM, A256GCM, XC20P}).Draw(t, "content encryption").(string)
+		recipients := []RecipientKey{
+			{Key: ecKey},
+			{Key: x25519Key.PublicKey(), Algorithm: ECDHESA256KW},
+			{Key: x25519Key, Algorithm: ECDHESXC20PKW},
+			{Key: rsaKey, Algorithm: RSAOAEP},
+			{Key: rsaKey},
+		}
+		jwe, err := EncryptOptions{ContentEncryption: enc, IncludeCID: true}.Encrypt(cleartext, recipients...)
+		require.NoError(t, err)
+		decoded := roundTripJWE(t, jwe)
+
+		// Each recipient can decrypt the JWE on its own
+		for _, key := range []interface{}{ecKey, x25519Key, rsaKey} {
+			nb := basicnode.Prototype.Any.NewBuilder()
+			require.NoError(t, DecryptOptions{Keys: []interface{}{key}, VerifyCID: true}.Decrypt(decoded, nb))
+			require.True(t, datamodel.DeepEqual(cleartext, nb.Build()))
+		}
+	})
+}
+
+func TestDecryptJWEWithDirectKey(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	cleartext := basicnode.NewString("cleartext")
+	jwe, err := EncryptOptions{ContentEncryption: XC20P, Codec: cid.DagJSON, IncludeCID: true}.Encrypt(cleartext, RecipientKey{Key: key})
+	require.NoError(t, err)
+	decoded := roundTripJWE(t, jwe)
+
+	block, err := DecryptBlock(decoded, key)
+	require.NoError(t, err)
+	require.Equal(t, `"cleartext"`, string(block))
+	// The codec of the `cid` protected header parameter is used by default
+	nb := basicnode.Prototype.Any.NewBuilder()
+	require.NoError(t, Decrypt(decoded, nb, key))
+	require.True(t, datamodel.DeepEqual(cleartext, nb.Build()))
+}
+
+// Keys are resolved by the recipient `kid`, e.g. from a keystore
+func TestDecryptJWEWithKeyResolver(t *testing.T) {
+	firstKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	secondKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwe, err := EncryptBlock(
+		[]byte("cleartext block"),
+		XC20P,
+		RecipientKey{Key: firstKey, KeyID: "first"},
+		RecipientKey{Key: secondKey, KeyID: "second"},
+	)
+	require.NoError(t, err)
+	decoded := roundTripJWE(t, jwe)
+	var resolved []string
+	resolver := KeyResolverFunc(func(kid string) ([]interface{}, error) {
+		resolved = append(resolved, kid)
+		if kid == "second" {
+			return []interface{}{secondKey}, nil
+		}
+		return nil, nil
+	})
+
+	block, err := DecryptOptions{Resolver: resolver}.DecryptBlock(decoded)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cleartext block"), block)
+	require.Equal(t, []string{"first", "second"}, resolved)
+
+	_, err = DecryptOptions{Resolver: KeyResolverFunc(func(kid string) ([]interface{}, error) {
+		return nil, errors.New("keystore unavailable")
+	})}.DecryptBlock(decoded)
+	require.ErrorContains(t, err, "resolving key first: keystore unavailable")
+}
+
+// A JWE produced by go-jose can be decrypted
+func TestDecryptGoJOSEJWE(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	encrypter, err := gojose.NewEncrypter(gojose.A256GCM, gojose.Recipient{Algorithm: gojose.ECDH_ES_A256KW, Key: &ecKey.PublicKey}, nil)
+	require.NoError(t, err)
+	encrypted, err := encrypter.EncryptWithAuthData([]byte("cleartext block"), []byte("additional data"))
+	require.NoError(t, err)
+	jwe, err := ParseJSON([]byte(encrypted.FullSerialize()))
+	require.NoError(t, err)
+
+	block, err := DecryptBlock(roundTripJWE(t, jwe), ecKey)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cleartext block"), block)
+}
+
+func TestDecryptJWEErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwe, err := EncryptBlock([]byte("cleartext block"), A256GCM, RecipientKey{Key: ecKey})
+	require.NoError(t, err)
+
+	_, err = DecryptBlock(jwe, otherKey)
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+	_, err = DecryptBlock(jwe, rsaKey, make([]byte, 32))
+	require.ErrorIs(t, err, ErrNoDecryptionKey)
+	_, err = DecryptOptions{Keys: []interface{}{ecKey}, VerifyCID: true}.DecryptBlock(jwe)
+	require.ErrorContains(t, err, "no `cid` parameter")
+
+	// Tampering with the ciphertext is detected
+	jwe.ciphertext.x[0] ^= 0xff
+	_, err = DecryptBlock(jwe, ecKey)
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+
+	// The cleartext must match the CID carried in the protected header
+	jwe, err = EncryptOptions{IncludeCID: true}.EncryptBlock([]byte("cleartext block"), createCid([]byte("other block")), RecipientKey{Key: ecKey})
+	require.NoError(t, err)
+	block, err := DecryptBlock(jwe, ecKey)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cleartext block"), block)
+	_, err = DecryptOptions{Keys: []interface{}{ecKey}, VerifyCID: true}.DecryptBlock(jwe)
+	require.ErrorIs(t, err, ErrCIDMismatch)
+
+	// The cleartext must be decodable with the requested codec
+	err = DecryptOptions{Keys: []interface{}{ecKey}, Codec: cid.DagJSON}.Decrypt(jwe, basicnode.Prototype.Any.NewBuilder())
+	require.Error(t, err)
+}
@@ -8,14 +8,40 @@ import (
 	"github.com/ipld/go-ipld-prime/codec"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
 	"github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/schema"
 	"github.com/multiformats/go-multibase"
 )
 
+// EncodeOptions can be used to customize the behavior of an encoding function. The Encode method on this struct fits the
+// codec.Encoder function interface.
+type EncodeOptions struct {
+	// If true, encode JWS with a detached payload, i.e. only their `signatures`, for signatures over a CID that is
+	// stored elsewhere. The `payload` of a JWS is dropped if present, and a JWS without `payload`, e.g. one decoded with
+	// DecodeOptions.DetachedPayload, can only be encoded with this option set. The CID has to be passed to
+	// VerifyDetached to verify the signatures.
+	DetachedPayload bool
+}
+
 // Encode walks the given datamodel.Node and serializes it to the given io.Writer. Encode fits the codec.Encoder
 // function interface.
 func Encode(n datamodel.Node, w io.Writer) error {
+	return EncodeOptions{}.Encode(n, w)
+}
+
+func EncodeJWE(n datamodel.Node, w io.Writer) error {
+	return EncodeOptions{}.EncodeJWE(n, w)
+}
+
+func EncodeJWS(n datamodel.Node, w io.Writer) error {
+	return EncodeOptions{}.EncodeJWS(n, w)
+}
+
+// Encode walks the given datamodel.Node and serializes it to the given io.Writer. Encode fits the codec.Encoder
+// function interface.
+func (cfg EncodeOptions) Encode(n datamodel.Node, w io.Writer) error {
 	// "flattened" fields are not included in the schema and thus never encoded. That means that this cannot have been
 	// called on a JOSE-related node because we wouldn't have gotten this far without an error have occurred earlier.
 	// We'll assume this is some sort of Map-type node that we can reconstruct to be in a "general" form before any
@@ -25,7 +51,7 @@ func Encode(n datamodel.Node, w io.Writer) error {
 	} else if jwe {
 		if n, err := unflattenJWE(n); err != nil {
 			return err
-		} else if err := EncodeJWE(n, w); err != nil {
+		} else if err := cfg.EncodeJWE(n, w); err != nil {
 			return err
 		}
 	} else if jws, err := isJWS(n); err != nil {
@@ -33,16 +59,16 @@ func Encode(n datamodel.Node, w io.Writer) error {
 	} else if jws {
 		if n, err := unflattenJWS(n); err != nil {
 			return err
-		} else if err := EncodeJWS(n, w); err != nil {
+		} else if err := cfg.EncodeJWS(n, w); err != nil {
 			return err
 		}
 	} else {
-		return errors.New("invalid JOSE object")
+		return &Error{Kind: ErrNotJOSE}
 	}
 	return nil
 }
 
-func EncodeJWE(n datamodel.Node, w io.Writer) error {
+func (cfg EncodeOptions) EncodeJWE(n datamodel.Node, w io.Writer) error {
 	// Check for the fastpath where the passed node is already of type `_EncodedJWE__Repr` or `_EncodedJWE`
 	if _, castOk := n.(*_EncodedJWE__Repr); !castOk {
 		// This could still be `_EncodedJWE`, so check for that.
@@ -60,11 +86,12 @@ func EncodeJWE(n datamodel.Node, w io.Writer) error {
 	// DAG-CBOR is a superset of DAG-JOSE and can be used to encode valid DAG-JOSE objects.
 	// See: https://specs.ipld.io/block-layer/codecs/dag-jose.html
 	return dagcbor.EncodeOptions{
+		AllowLinks:  true,
 		MapSortMode: codec.MapSortMode_RFC7049,
 	}.Encode(n, w)
 }
 
-func EncodeJWS(n datamodel.Node, w io.Writer) error {
+func (cfg EncodeOptions) EncodeJWS(n datamodel.Node, w io.Writer) error {
 	// Check for the fastpath where the passed node is already of type `_EncodedJWES__Repr` or `_EncodedJWS`
 	if _, castOk := n.(*_EncodedJWS__Repr); !castOk {
 		// This could still be `_EncodedJWS`, so check for that.
@@ -73,6 +100,20 @@ func EncodeJWS(n datamodel.Node, w io.Writer) error {
 			if err := validateLink(n); err != nil {
 				return err
 			}
+			// A JWS without `payload` cannot be assembled into an `EncodedJWS`, so only its signatures are assembled
+			if payload, err := lookupIgnoreAbsent("payload", n); err != nil {
+				return err
+			} else if (payload == nil) && !cfg.DetachedPayload {
+				return &Error{Kind: ErrInvalidJWS, Path: datamodel.ParsePath("payload"), Causes: []error{errMissingPayload}}
+			} else if payload == nil {
+				if signatures, err := jwsSignatures(n, Type.EncodedSignatures__Repr); err != nil {
+					return err
+				} else if signatures == nil {
+					return encodeDetachedJWS(nil, w)
+				} else {
+					return encodeDetachedJWS(signatures.(EncodedSignatures), w)
+				}
+			}
 			// No fastpath possible, just create a new `_EncodedJWS__ReprBuilder` and copy the passed node into it.
 			jwsBuilder := Type.EncodedJWS__Repr.NewBuilder().(*_EncodedJWS__ReprBuilder)
 			if err := datamodel.Copy(n, jwsBuilder); err != nil {
@@ -83,9 +124,34 @@ func EncodeJWS(n datamodel.Node, w io.Writer) error {
 		// The "representation" node gives an accurate view of fields that are actually present
 		n = n.(schema.TypedNode).Representation()
 	}
+	if jws := n.(*_EncodedJWS__Repr); cfg.DetachedPayload && jws.signatures.Exists() {
+		return encodeDetachedJWS(&jws.signatures.v, w)
+	} else if cfg.DetachedPayload {
+		return encodeDetachedJWS(nil, w)
+	}
 	// DAG-CBOR is a superset of DAG-JOSE and can be used to encode valid DAG-JOSE objects.
 	// See: https://specs.ipld.io/block-layer/codecs/dag-jose.html
 	return dagcbor.EncodeOptions{
+		AllowLinks:  true,
+		MapSortMode: codec.MapSortMode_RFC7049,
+	}.Encode(n, w)
+}
+
+// encodeDetachedJWS encodes a JWS with a detached payload, i.e. a map holding only the given signatures.
+func encodeDetachedJWS(signatures EncodedSignatures, w io.Writer) error {
+	if signatures == nil {
+		// Such a block would be an empty map, which is not a JWS
+		return &Error{
+			Kind:   ErrInvalidJWS,
+			Path:   datamodel.ParsePath("signatures"),
+			Causes: []error{errors.New("a JWS with a detached payload must have signatures")},
+		}
+	}
+	n := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("signatures").AssignNode(signatures.Representation())
+	})
+	return dagcbor.EncodeOptions{
+		AllowLinks:  true,
 		MapSortMode: codec.MapSortMode_RFC7049,
 	}.Encode(n, w)
 }
@@ -105,7 +171,7 @@ func validateLink(n datamodel.Node) error {
 		} else if linkFromNode, err := linkNode.AsLink(); err != nil {
 			return err
 		} else if linkFromNode.(cidlink.Link).Cid != cidFromPayload {
-			return errors.New("cid mismatch")
+			return &Error{Kind: ErrCIDMismatch, Path: datamodel.ParsePath("link")}
 		}
 	}
 	return nil
@@ -0,0 +1,460 @@
+package dagjose
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	josecipher "github.com/go-jose/go-jose/v4/cipher"
+	"github.com/go-jose/go-jose/v4/json"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// JWE content encryption algorithms supported when encrypting and decrypting dag-jose objects. See RFC 7518 §5.1 and,
+// for XC20P, draft-amringer-jose-chacha.
+const (
+	A128GCM = "A128GCM"
+	A192GCM = "A192GCM"
+	A256GCM = "A256GCM"
+	XC20P   = "XC20P"
+)
+
+// JWE key management algorithms supported when encrypting and decrypting dag-jose objects. See RFC 7518 §4.1 and, for
+// ECDH-ES+XC20PKW, draft-amringer-jose-chacha.
+const (
+	Direct        = "dir"
+	RSAOAEP       = "RSA-OAEP"
+	RSAOAEP256    = "RSA-OAEP-256"
+	ECDHESA256KW  = "ECDH-ES+A256KW"
+	ECDHESXC20PKW = "ECDH-ES+XC20PKW"
+)
+
+// RecipientKey describes a single recipient of a JWE.
+type RecipientKey struct {
+	// Key of the recipient. The following are supported:
+	//   - *ecdsa.PublicKey (P-256, P-384, P-521) and *ecdh.PublicKey (X25519, P-256, P-384, P-521) for key agreement
+	//   - *rsa.PublicKey for key encryption
+	//   - []byte holding the content encryption key itself for direct encryption, which requires a single recipient
+	//   - gojose.JSONWebKey (or a pointer to one) holding one of the above, and any private key from which one of the
+	//     above can be derived
+	Key interface{}
+	// Algorithm is the `alg` header parameter. If empty, it is inferred from the key: dir for symmetric keys,
+	// RSA-OAEP-256 for RSA, and ECDH-ES+XC20PKW for XC20P content encryption or ECDH-ES+A256KW otherwise for elliptic
+	// curve keys.
+	Algorithm string
+	// KeyID is set as the `kid` header parameter, if not empty. If the key is a gojose.JSONWebKey, its key ID is used
+	// by default.
+	KeyID string
+	// Header holds additional header parameters for this recipient. They must not include any of the parameters set
+	// by the key management algorithm.
+	Header map[string]interface{}
+}
+
+// EncryptOptions can be used to customize how a JWE is produced. The Encrypt and EncryptBlock methods on this struct
+// encrypt a block for one or more recipients.
+type EncryptOptions struct {
+	// ContentEncryption is the `enc` protected header parameter. Defaults to A256GCM.
+	ContentEncryption string
+	// Codec is the multicodec used by Encrypt to encode nodes into the cleartext block. Defaults to dag-cbor.
+	Codec uint64
+	// IncludeCID adds the CID of the cleartext block to the protected header as the `cid` parameter so that it can be
+	// checked after decryption. Note that this reveals the CID of the cleartext to anyone who can read the JWE.
+	IncludeCID bool
+	// ExtraHeaders are additional protected header parameters. They must not include `enc`, `cid` or any recipient
+	// header parameter.
+	ExtraHeaders map[string]interface{}
+	// Unprotected is the shared unprotected header of the JWE, if not empty.
+	Unprotected map[string]interface{}
+	// AAD is additional authenticated data, if not empty.
+	AAD []byte
+}
+
+// Encrypt encodes the given node with the configured codec and encrypts the resulting block for the given recipients.
+// The returned JWE is ready for EncodeJWE or Encode.
+func (cfg EncryptOptions) Encrypt(n datamodel.Node, recipients ...RecipientKey) (EncodedJWE, error) {
+	codec := cfg.Codec
+	if codec == 0 {
+		codec = cid.DagCBOR
+	}
+	encoder, err := multicodec.LookupEncoder(codec)
+	if err != nil {
+		return nil, err
+	}
+	var block bytes.Buffer
+	if err := encoder(n, &block); err != nil {
+		return nil, err
+	}
+	blockCid, err := cid.Prefix{Version: 1, Codec: codec, MhType: multihash.SHA2_256, MhLength: -1}.Sum(block.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return cfg.EncryptBlock(block.Bytes(), blockCid, recipients...)
+}
+
+// EncryptBlock encrypts the given cleartext block for the given recipients. The CID of the block is only used if
+// IncludeCID is set, and may be cid.Undef otherwise.
+func (cfg EncryptOptions) EncryptBlock(block []byte, blockCid cid.Cid, recipients ...RecipientKey) (EncodedJWE, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one recipient is required")
+	}
+	enc := cfg.ContentEncryption
+	if len(enc) == 0 {
+		enc = A256GCM
+	}
+	cekSize, err := contentKeySize(enc)
+	if err != nil {
+		return nil, err
+	}
+	protectedHeader := make(map[string]interface{}, len(cfg.ExtraHeaders)+2)
+	for name, value := range cfg.ExtraHeaders {
+		if (name == "enc") || (name == "cid") {
+			return nil, fmt.Errorf("`%s` must not be set through extra headers", name)
+		}
+		protectedHeader[name] = value
+	}
+	protectedHeader["enc"] = enc
+	if cfg.IncludeCID {
+		if !blockCid.Defined() {
+			return nil, errors.New("block CID is required to include it in the protected header")
+		}
+		protectedHeader["cid"] = blockCid.String()
+	}
+
+	// Direct encryption uses the recipient key as the content encryption key, otherwise a random one is wrapped for
+	// each recipient.
+	var cek []byte
+	keys := make([]interface{}, len(recipients))
+	algs := make([]string, len(recipients))
+	for idx, recipient := range recipients {
+		keys[idx] = recipientPublicKey(recipient.Key)
+		if algs[idx] = recipient.Algorithm; len(algs[idx]) == 0 {
+			if algs[idx], err = inferKeyManagementAlgorithm(keys[idx], enc); err != nil {
+				return nil, fmt.Errorf("recipient %d: %w", idx, err)
+			}
+		}
+		if algs[idx] == Direct {
+			if len(recipients) > 1 {
+				return nil, errors.New("direct encryption requires a single recipient")
+			} else if symmetricKey, castOk := keys[idx].([]byte); !castOk {
+				return nil, fmt.Errorf("recipient %d: direct encryption requires a symmetric key, found %T", idx, keys[idx])
+			} else if len(symmetricKey) != cekSize {
+				return nil, fmt.Errorf("recipient %d: %s requires a %d-byte key, found %d bytes", idx, enc, cekSize, len(symmetricKey))
+			} else {
+				cek = symmetricKey
+			}
+		}
+	}
+	if cek == nil {
+		cek = make([]byte, cekSize)
+		if _, err := rand.Read(cek); err != nil {
+			return nil, err
+		}
+	}
+	encryptedKeys := make([][]byte, len(recipients))
+	headers := make([]map[string]interface{}, len(recipients))
+	for idx, recipient := range recipients {
+		if encryptedKeys[idx], headers[idx], err = recipient.wrap(algs[idx], keys[idx], cek); err != nil {
+			return nil, fmt.Errorf("recipient %d: %w", idx, err)
+		}
+	}
+	// Like in compact serialization, the header parameters of a single recipient are integrity-protected
+	if len(recipients) == 1 {
+		for name, value := range headers[0] {
+			if _, exists := protectedHeader[name]; exists {
+				return nil, fmt.Errorf("`%s` must not be set through extra headers", name)
+			}
+			protectedHeader[name] = value
+		}
+		headers[0] = nil
+	}
+	protected, err := json.Marshal(protectedHeader)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newContentCipher(enc, cek)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, iv, block, jweAdditionalData(protected, cfg.AAD))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+
+	var unprotected datamodel.Node
+	if len(cfg.Unprotected) > 0 {
+		if unprotected, err = goPrimitiveToIpldNode(cfg.Unprotected); err != nil {
+			return nil, err
+		}
+	}
+	headerNodes := make([]datamodel.Node, len(recipients))
+	for idx, header := range headers {
+		if len(header) > 0 {
+			if headerNodes[idx], err = goPrimitiveToIpldNode(header); err != nil {
+				return nil, err
+			}
+		}
+	}
+	jwe, err := fluent.BuildMap(Type.EncodedJWE__Repr, 7, func(ma fluent.MapAssembler) {
+		if len(cfg.AAD) > 0 {
+			ma.AssembleEntry("aad").AssignBytes(cfg.AAD)
+		}
+		ma.AssembleEntry("ciphertext").AssignBytes(ciphertext)
+		ma.AssembleEntry("iv").AssignBytes(iv)
+		ma.AssembleEntry("protected").AssignBytes(protected)
+		// Direct encryption has no encrypted key and, with its header parameters protected, no recipient at all
+		if algs[0] != Direct {
+			ma.AssembleEntry("recipients").CreateList(int64(len(recipients)), func(la fluent.ListAssembler) {
+				for idx := range recipients {
+					la.AssembleValue().CreateMap(2, func(ma fluent.MapAssembler) {
+						if headerNodes[idx] != nil {
+							ma.AssembleEntry("header").AssignNode(headerNodes[idx])
+						}
+						ma.AssembleEntry("encrypted_key").AssignBytes(encryptedKeys[idx])
+					})
+				}
+			})
+		}
+		ma.AssembleEntry("tag").AssignBytes(tag)
+		if unprotected != nil {
+			ma.AssembleEntry("unprotected").AssignNode(unprotected)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jwe.(EncodedJWE), nil
+}
+
+// Encrypt encodes the given node as dag-cbor and encrypts it for the given recipients using the given content
+// encryption algorithm. See EncryptOptions.Encrypt.
+func Encrypt(n datamodel.Node, enc string, recipients ...RecipientKey) (EncodedJWE, error) {
+	return EncryptOptions{ContentEncryption: enc}.Encrypt(n, recipients...)
+}
+
+// EncryptBlock encrypts the given cleartext block for the given recipients using the given content encryption
+// algorithm. See EncryptOptions.EncryptBlock.
+func EncryptBlock(block []byte, enc string, recipients ...RecipientKey) (EncodedJWE, error) {
+	return EncryptOptions{ContentEncryption: enc}.EncryptBlock(block, cid.Undef, recipients...)
+}
+
+// wrap encrypts the content encryption key for this recipient and returns it along with the recipient's header
+// parameters.
+func (rk RecipientKey) wrap(alg string, key interface{}, cek []byte) ([]byte, map[string]interface{}, error) {
+	_, keyID := unwrapJSONWebKey(rk.Key)
+	if len(rk.KeyID) > 0 {
+		keyID = rk.KeyID
+	}
+	header := make(map[string]interface{}, len(rk.Header)+4)
+	for name, value := range rk.Header {
+		header[name] = value
+	}
+	setHeader := func(name string, value interface{}) error {
+		if _, exists := header[name]; exists {
+			return fmt.Errorf("`%s` must not be set through the recipient header", name)
+		}
+		header[name] = value
+		return nil
+	}
+	if err := setHeader("alg", alg); err != nil {
+		return nil, nil, err
+	}
+	if len(keyID) > 0 {
+		if err := setHeader("kid", keyID); err != nil {
+			return nil, nil, err
+		}
+	}
+	switch alg {
+	case Direct:
+		return nil, header, nil
+	case RSAOAEP, RSAOAEP256:
+		publicKey, castOk := key.(*rsa.PublicKey)
+		if !castOk {
+			return nil, nil, fmt.Errorf("algorithm %s requires an RSA key, found %T", alg, key)
+		}
+		encryptedKey, err := rsa.EncryptOAEP(oaepHash(alg), rand.Reader, publicKey, cek, nil)
+		return encryptedKey, header, err
+	case ECDHESA256KW, ECDHESXC20PKW:
+		publicKey, err := ecdhPublicKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("algorithm %s: %w", alg, err)
+		}
+		ephemeralKey, err := publicKey.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		kek, err := deriveKeyEncryptionKey(alg, ephemeralKey, publicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := setHeader("epk", ecdhJWK(ephemeralKey.PublicKey())); err != nil {
+			return nil, nil, err
+		}
+		if alg == ECDHESA256KW {
+			block, err := aes.NewCipher(kek)
+			if err != nil {
+				return nil, nil, err
+			}
+			encryptedKey, err := josecipher.KeyWrap(block, cek)
+			return encryptedKey, header, err
+		}
+		aead, err := chacha20poly1305.NewX(kek)
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, err
+		}
+		sealed := aead.Seal(nil, iv, cek, nil)
+		if err := setHeader("iv", encodeBase64Url(iv)); err != nil {
+			return nil, nil, err
+		} else if err := setHeader("tag", encodeBase64Url(sealed[len(sealed)-aead.Overhead():])); err != nil {
+			return nil, nil, err
+		}
+		return sealed[:len(sealed)-aead.Overhead()], header, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported key management algorithm: %s", alg)
+}
+
+// recipientPublicKey returns the key to encrypt for with any of the supported key types.
+func recipientPublicKey(key interface{}) interface{} {
+	key, _ = unwrapJSONWebKey(key)
+	if privateKey, castOk := key.(*ecdh.PrivateKey); castOk {
+		return privateKey.PublicKey()
+	}
+	return publicKeyOf(key)
+}
+
+// inferKeyManagementAlgorithm returns the default key management algorithm for a recipient key.
+func inferKeyManagementAlgorithm(key interface{}, enc string) (string, error) {
+	switch key.(type) {
+	case []byte:
+		return Direct, nil
+	case *rsa.PublicKey:
+		return RSAOAEP256, nil
+	case *ecdsa.PublicKey, *ecdh.PublicKey:
+		if enc == XC20P {
+			return ECDHESXC20PKW, nil
+		}
+		return ECDHESA256KW, nil
+	}
+	return "", fmt.Errorf("unsupported recipient key type: %T", key)
+}
+
+// contentKeySize returns the size in bytes of the content encryption key used by a content encryption algorithm.
+func contentKeySize(enc string) (int, error) {
+	switch enc {
+	case A128GCM:
+		return 16, nil
+	case A192GCM:
+		return 24, nil
+	case A256GCM, XC20P:
+		return 32, nil
+	}
+	return 0, fmt.Errorf("unsupported content encryption algorithm: %s", enc)
+}
+
+// newContentCipher returns the AEAD for a content encryption algorithm and key.
+func newContentCipher(enc string, cek []byte) (cipher.AEAD, error) {
+	if size, err := contentKeySize(enc); err != nil {
+		return nil, err
+	} else if len(cek) != size {
+		return nil, fmt.Errorf("%s requires a %d-byte key, found %d bytes", enc, size, len(cek))
+	}
+	if enc == XC20P {
+		return chacha20poly1305.NewX(cek)
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// jweAdditionalData returns the additional authenticated data of a JWE (RFC 7516 §5.1, step 14).
+func jweAdditionalData(protected []byte, aad []byte) []byte {
+	additionalData := encodeBase64Url(protected)
+	if len(aad) > 0 {
+		additionalData += "." + encodeBase64Url(aad)
+	}
+	return []byte(additionalData)
+}
+
+// oaepHash returns the hash function used by an RSA-OAEP key management algorithm.
+func oaepHash(alg string) hash.Hash {
+	if alg == RSAOAEP {
+		return sha1.New()
+	}
+	return sha256.New()
+}
+
+// ecdhPublicKey returns a recipient key as a crypto/ecdh public key.
+func ecdhPublicKey(key interface{}) (*ecdh.PublicKey, error) {
+	switch publicKey := key.(type) {
+	case *ecdh.PublicKey:
+		return publicKey, nil
+	case *ecdsa.PublicKey:
+		return publicKey.ECDH()
+	}
+	return nil, fmt.Errorf("unsupported key agreement key type: %T", key)
+}
+
+// deriveKeyEncryptionKey performs ECDH key agreement and derives a 256-bit key encryption key from the shared secret
+// with the Concat KDF (RFC 7518 §4.6.2). PartyUInfo and PartyVInfo are left empty.
+func deriveKeyEncryptionKey(alg string, privateKey *ecdh.PrivateKey, publicKey *ecdh.PublicKey) ([]byte, error) {
+	sharedSecret, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	lengthPrefixed := func(data []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(data))), data...)
+	}
+	kek := make([]byte, 32)
+	kdf := josecipher.NewConcatKDF(
+		crypto.SHA256,
+		sharedSecret,
+		lengthPrefixed([]byte(alg)),
+		lengthPrefixed(nil),
+		lengthPrefixed(nil),
+		binary.BigEndian.AppendUint32(nil, uint32(len(kek))*8),
+		nil,
+	)
+	if _, err := kdf.Read(kek); err != nil {
+		return nil, err
+	}
+	return kek, nil
+}
+
+// ecdhJWK returns the JWK of an ephemeral public key, as used in the `epk` header parameter.
+func ecdhJWK(publicKey *ecdh.PublicKey) map[string]interface{} {
+	if publicKey.Curve() == ecdh.X25519() {
+		return map[string]interface{}{"kty": "OKP", "crv": "X25519", "x": encodeBase64Url(publicKey.Bytes())}
+	}
+	// NIST curve public keys are in uncompressed form, i.e. 0x04 followed by the X and Y coordinates
+	coordinates := publicKey.Bytes()[1:]
+	return map[string]interface{}{
+		"kty": "EC",
+		"crv": fmt.Sprint(publicKey.Curve()),
+		"x":   encodeBase64Url(coordinates[:len(coordinates)/2]),
+		"y":   encodeBase64Url(coordinates[len(coordinates)/2:]),
+	}
+}
@@ -0,0 +1,189 @@
+package dagjose
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"pgregory.net/rapid"
+)
+
+var goJOSEKeyAlgorithms = []gojose.KeyAlgorithm{gojose.DIRECT, gojose.RSA_OAEP, gojose.RSA_OAEP_256, gojose.ECDH_ES_A256KW}
+var goJOSEContentEncryptions = []gojose.ContentEncryption{gojose.A128GCM, gojose.A192GCM, gojose.A256GCM}
+
+// Decode the protected header of a JWE
+func protectedHeaderOf(t require.TestingT, jwe EncodedJWE) map[string]interface{} {
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(jwe.protected.v.x, &header))
+	return header
+}
+
+func TestEncryptJWE(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		message := rapid.String().Draw(t, "cleartext message").(string)
+		cleartext := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("message").AssignString(message)
+		})
+		block, err := ipld.Encode(cleartext, dagcbor.Encode)
+		require.NoError(t, err)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		jwe, err := EncryptOptions{IncludeCID: true}.Encrypt(cleartext, RecipientKey{Key: &ecKey.PublicKey, KeyID: "ec"})
+		require.NoError(t, err)
+		header := protectedHeaderOf(t, jwe)
+		require.Equal(t, ECDHESA256KW, header["alg"])
+		require.Equal(t, A256GCM, header["enc"])
+		require.Equal(t, "ec", header["kid"])
+		blockCid, err := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}.Sum(block)
+		require.NoError(t, err)
+		require.Equal(t, blockCid.String(), header["cid"])
+
+		// A JWE for a single recipient can be rendered in compact serialization and decrypted by go-jose
+		compact, err := CompactJWE(jwe)
+		require.NoError(t, err)
+		parsed, err := gojose.ParseEncryptedCompact(compact, goJOSEKeyAlgorithms, goJOSEContentEncryptions)
+		require.NoError(t, err)
+		decrypted, err := parsed.Decrypt(ecKey)
+		require.NoError(t, err)
+		require.Equal(t, block, decrypted)
+	})
+}
+
+func TestEncryptJWEForMultipleRecipients(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	block := []byte("cleartext block")
+
+	jwe, err := EncryptOptions{
+		ContentEncryption: A192GCM,
+		Unprotected:       map[string]interface{}{"shared": "unprotected"},
+		AAD:               []byte("additional data"),
+	}.EncryptBlock(
+		block,
+		cid.Undef,
+		RecipientKey{Key: rsaKey, Algorithm: RSAOAEP},
+		RecipientKey{Key: gojose.JSONWebKey{Key: &rsaKey.PublicKey, KeyID: "rsa"}},
+		RecipientKey{Key: ecKey, Header: map[string]interface{}{"note": "recipient"}},
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"enc": A192GCM}, protectedHeaderOf(t, jwe))
+	encoded, err := ipld.Encode(jwe, Encode)
+	require.NoError(t, err)
+	decoded, err := ipld.Decode(encoded, Decode)
+	require.NoError(t, err)
+	general, err := MarshalGeneralJSON(decoded)
+	require.NoError(t, err)
+
+	parsed, err := gojose.ParseEncryptedJSON(string(general), goJOSEKeyAlgorithms, goJOSEContentEncryptions)
+	require.NoError(t, err)
+	for expectedIdx, key := range []interface{}{rsaKey, ecKey} {
+		idx, header, decrypted, err := parsed.DecryptMulti(key)
+		require.NoError(t, err)
+		require.Equal(t, block, decrypted)
+		// The first recipient the key can decrypt for is used
+		require.Equal(t, 2*expectedIdx, idx)
+		if idx == 0 {
+			require.Equal(t, RSAOAEP, header.Algorithm)
+		} else {
+			require.Equal(t, ECDHESA256KW, header.Algorithm)
+			require.Equal(t, "recipient", header.ExtraHeaders["note"])
+		}
+	}
+	var generalMap struct {
+		Recipients []struct{ Header map[string]interface{} }
+	}
+	require.NoError(t, json.Unmarshal(general, &generalMap))
+	require.Equal(t, map[string]interface{}{"alg": RSAOAEP256, "kid": "rsa"}, generalMap.Recipients[1].Header)
+	require.Equal(t, "unprotected", parsed.Header.ExtraHeaders["shared"])
+	require.Equal(t, []byte("additional data"), parsed.GetAuthData())
+}
+
+func TestEncryptJWEWithDirectKey(t *testing.T) {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	jwe, err := EncryptBlock([]byte("cleartext block"), A128GCM, RecipientKey{Key: key})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"alg": Direct, "enc": A128GCM}, protectedHeaderOf(t, jwe))
+	require.False(t, jwe.recipients.Exists())
+
+	compact, err := CompactJWE(jwe)
+	require.NoError(t, err)
+	parsed, err := gojose.ParseEncryptedCompact(compact, goJOSEKeyAlgorithms, goJOSEContentEncryptions)
+	require.NoError(t, err)
+	decrypted, err := parsed.Decrypt(key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cleartext block"), decrypted)
+}
+
+// go-jose supports neither X25519 nor XC20P, so only check the structure of the JWE here
+func TestEncryptJWEWithX25519(t *testing.T) {
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwe, err := EncryptBlock([]byte("cleartext block"), XC20P, RecipientKey{Key: x25519Key.PublicKey()}, RecipientKey{Key: x25519Key})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"enc": XC20P}, protectedHeaderOf(t, jwe))
+	require.Len(t, jwe.iv.v.x, 24)
+	require.Len(t, jwe.tag.v.x, 16)
+	require.Len(t, jwe.ciphertext.x, len("cleartext block"))
+	general, err := MarshalGeneralJSON(jwe)
+	require.NoError(t, err)
+	var generalMap struct {
+		Recipients []struct {
+			Header       map[string]interface{} `json:"header"`
+			EncryptedKey string                 `json:"encrypted_key"`
+		}
+	}
+	require.NoError(t, json.Unmarshal(general, &generalMap))
+	require.Len(t, generalMap.Recipients, 2)
+	for _, recipient := range generalMap.Recipients {
+		require.Equal(t, ECDHESXC20PKW, recipient.Header["alg"])
+		require.Equal(t, "X25519", recipient.Header["epk"].(map[string]interface{})["crv"])
+		require.Contains(t, recipient.Header, "iv")
+		require.Contains(t, recipient.Header, "tag")
+		encryptedKey, err := decodeBase64Url(recipient.EncryptedKey)
+		require.NoError(t, err)
+		require.Len(t, encryptedKey, 32)
+	}
+}
+
+func TestEncryptJWEErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	scenarios := map[string]struct {
+		options    EncryptOptions
+		recipients []RecipientKey
+	}{
+		"at least one recipient":                 {},
+		"unsupported content encryption":         {EncryptOptions{ContentEncryption: "A256CBC-HS512"}, []RecipientKey{{Key: ecKey}}},
+		"block CID is required":                  {EncryptOptions{IncludeCID: true}, []RecipientKey{{Key: ecKey}}},
+		"`enc` must not be set":                  {EncryptOptions{ExtraHeaders: map[string]interface{}{"enc": "none"}}, []RecipientKey{{Key: ecKey}}},
+		"`epk` must not be set":                  {EncryptOptions{}, []RecipientKey{{Key: ecKey, Header: map[string]interface{}{"epk": "none"}}}},
+		"`kid` must not be set through extra":    {EncryptOptions{ExtraHeaders: map[string]interface{}{"kid": "ec"}}, []RecipientKey{{Key: ecKey, KeyID: "ec"}}},
+		"direct encryption requires a single":    {EncryptOptions{}, []RecipientKey{{Key: make([]byte, 32)}, {Key: ecKey}}},
+		"requires a 32-byte key":                 {EncryptOptions{}, []RecipientKey{{Key: make([]byte, 16)}}},
+		"requires an RSA key":                    {EncryptOptions{}, []RecipientKey{{Key: ecKey, Algorithm: RSAOAEP}}},
+		"unsupported key management algorithm":   {EncryptOptions{}, []RecipientKey{{Key: ecKey, Algorithm: "A256KW"}}},
+		"unsupported recipient key type: string": {EncryptOptions{}, []RecipientKey{{Key: "secret"}}},
+	}
+	for expected, scenario := range scenarios {
+		jwe, err := scenario.options.EncryptBlock([]byte("cleartext block"), cid.Undef, scenario.recipients...)
+		require.ErrorContains(t, err, expected)
+		require.Nil(t, jwe)
+	}
+}
@@ -0,0 +1,132 @@
+package dagjose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+var (
+	// ErrNotJOSE is returned when a node or block is neither a JWE nor a JWS.
+	ErrNotJOSE = errors.New("not a JOSE object")
+	// ErrInvalidJWE is returned when a node or block was identified as a JWE but is not a valid one.
+	ErrInvalidJWE = errors.New("invalid JWE")
+	// ErrInvalidJWS is returned when a node or block was identified as a JWS but is not a valid one.
+	ErrInvalidJWS = errors.New("invalid JWS")
+	// ErrCIDMismatch is returned when a CID carried by a JOSE object does not match the content it refers to.
+	ErrCIDMismatch = errors.New("cid mismatch")
+	// ErrNotCanonical is returned when decoding a block in strict mode and the block is not in canonical form.
+	ErrNotCanonical = errors.New("non-canonical dag-jose encoding")
+	// ErrLimitExceeded is matched by a LimitError.
+	ErrLimitExceeded = errors.New("decode limit exceeded")
+	// ErrInvalidSerialization is returned when a JOSE object mixes fields of the general and flattened serializations.
+	ErrInvalidSerialization = errors.New("invalid JOSE serialization")
+	// ErrLocked is matched by a LockedError.
+	ErrLocked = errors.New("JWE is locked")
+)
+
+// Error is the error type returned when a node or block is not a valid JOSE object. It matches its Kind and each of its
+// Causes with errors.Is and errors.As.
+//
+// When a block is neither a JWE nor a JWS, Kind is ErrNotJOSE and Causes hold an ErrInvalidJWE error and an
+// ErrInvalidJWS error explaining why the block is neither. A block that is not in canonical form when decoding in strict
+// mode is reported as an ErrInvalidJWE or ErrInvalidJWS error caused by ErrNotCanonical.
+type Error struct {
+	// Kind is one of ErrNotJOSE, ErrInvalidJWE, ErrInvalidJWS, ErrCIDMismatch or ErrInvalidSerialization.
+	Kind error
+	// Path is the path of the offending field within the JOSE object, empty if the error is not specific to a field.
+	Path datamodel.Path
+	// Causes are the underlying errors, if any.
+	Causes []error
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Kind.Error())
+	if e.Path.Len() > 0 {
+		fmt.Fprintf(&sb, " at `%s`", e.Path)
+	}
+	for idx, cause := range e.Causes {
+		if idx == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(cause.Error())
+	}
+	return sb.String()
+}
+
+func (e *Error) Unwrap() []error {
+	return append([]error{e.Kind}, e.Causes...)
+}
+
+// errMissingPayload is reported for a JWS without `payload` unless it is encoded or decoded with a detached payload.
+var errMissingPayload = schema.ErrMissingRequiredField{Missing: []string{"payload"}}
+
+// fieldPath returns the path of the field an error refers to: the first missing field if the error reports missing
+// required fields, and the given field otherwise.
+func fieldPath(err error, field string) datamodel.Path {
+	var missing schema.ErrMissingRequiredField
+	if errors.As(err, &missing) && (len(missing.Missing) > 0) {
+		field = missing.Missing[0]
+	}
+	return datamodel.ParsePath(field)
+}
+
+// invalidSerialization returns an ErrInvalidSerialization error for a field of the flattened serialization found
+// alongside the list field of the general serialization. The error also matches kind, ErrInvalidJWE or ErrInvalidJWS.
+func invalidSerialization(kind error, field, list string) error {
+	return &Error{
+		Kind:   ErrInvalidSerialization,
+		Path:   datamodel.ParsePath(field),
+		Causes: []error{fmt.Errorf("%w serialization: `%s` cannot be used with `%s`", kind, field, list)},
+	}
+}
+
+// LimitError is returned when decoding a block that exceeds one of the limits set in DecodeOptions. It matches
+// ErrLimitExceeded with errors.Is.
+type LimitError struct {
+	// Limit is the name of the DecodeOptions field that was exceeded, e.g. "MaxBlockSize".
+	Limit string
+	// Max is the value of the limit.
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s of %d exceeded", ErrLimitExceeded, e.Limit, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// LockedError is returned by DecryptOptions.ReifyCleartext for a JWE for which no key is available, so that callers can
+// leave such JWE as they are, e.g. to present partial views of encrypted DAGs. A JWE that fails to decrypt with a key
+// identified as that of one of its recipients is not locked, as it may have been tampered with. It matches ErrLocked
+// and its Err with errors.Is.
+type LockedError struct {
+	// KeyIDs are the `kid` header parameters of the recipients of the JWE, for those that have one.
+	KeyIDs []string
+	// Err is ErrNoDecryptionKey if none of the keys could be used with any recipient, or ErrDecryptionFailed if none of
+	// those that could be used decrypted the JWE and none has the key ID of a recipient.
+	Err error
+}
+
+func (e *LockedError) Error() string {
+	if len(e.KeyIDs) == 0 {
+		return fmt.Sprintf("%s: %s", ErrLocked, e.Err)
+	}
+	return fmt.Sprintf("%s: %s for %s", ErrLocked, e.Err, strings.Join(e.KeyIDs, ", "))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+func (e *LockedError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,142 @@
+package dagjose
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeMap(t *testing.T, fn func(ma fluent.MapAssembler)) error {
+	encoded, err := ipld.Encode(fluent.MustBuildMap(basicnode.Prototype.Map, 0, fn), dagcbor.Encode)
+	require.NoError(t, err)
+	_, err = ipld.Decode(encoded, Decode)
+	return err
+}
+
+func requireErrorPath(t *testing.T, err error, path string) {
+	var joseErr *Error
+	require.True(t, errors.As(err, &joseErr))
+	require.Equal(t, path, joseErr.Path.String())
+}
+
+// A malformed JWE reports why it is not a valid JWE, rather than why it is not a valid JWS
+func TestDecodeInvalidJWE(t *testing.T) {
+	err := decodeMap(t, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("ciphertext").AssignBytes([]byte("ciphertext"))
+		ma.AssembleEntry("recipients").AssignString("not a list")
+	})
+	require.ErrorIs(t, err, ErrInvalidJWE)
+	require.NotErrorIs(t, err, ErrInvalidJWS)
+	require.NotErrorIs(t, err, ErrNotJOSE)
+	requireErrorPath(t, err, "recipients")
+
+	err = decodeMap(t, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("iv").AssignBytes([]byte("iv"))
+	})
+	require.ErrorIs(t, err, ErrInvalidJWE)
+	requireErrorPath(t, err, "ciphertext")
+
+	err = decodeMap(t, func(ma fluent.MapAssembler) {
+		// Keys are sorted by length first, so `iv` identifies the block as a JWE
+		ma.AssembleEntry("iv").AssignBytes([]byte("iv"))
+		ma.AssembleEntry("payload").AssignBytes([]byte("payload"))
+	})
+	require.ErrorIs(t, err, ErrInvalidJWE)
+	requireErrorPath(t, err, "payload")
+}
+
+func TestDecodeInvalidJWS(t *testing.T) {
+	err := decodeMap(t, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("signatures").CreateList(0, func(la fluent.ListAssembler) {})
+	})
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	require.NotErrorIs(t, err, ErrInvalidJWE)
+	requireErrorPath(t, err, "payload")
+
+	// Decoding as a JWS reports JWS errors even if the block looks like a JWE
+	encoded, err := ipld.Encode(fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("ciphertext").AssignBytes([]byte("ciphertext"))
+	}), dagcbor.Encode)
+	require.NoError(t, err)
+	err = DecodeOptions{}.DecodeJWS(basicnode.Prototype.Any.NewBuilder(), bytes.NewReader(encoded))
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	requireErrorPath(t, err, "ciphertext")
+}
+
+// A block that is neither a JWE nor a JWS carries the reasons for both
+func TestDecodeNotJOSE(t *testing.T) {
+	err := decodeMap(t, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("claims").AssignString("value")
+	})
+	require.ErrorIs(t, err, ErrNotJOSE)
+	require.ErrorIs(t, err, ErrInvalidJWE)
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	requireErrorPath(t, err, "claims")
+
+	err = decodeMap(t, func(ma fluent.MapAssembler) {})
+	require.ErrorIs(t, err, ErrNotJOSE)
+	var joseErr *Error
+	require.True(t, errors.As(err, &joseErr))
+	require.Len(t, joseErr.Causes, 2)
+	requireErrorPath(t, joseErr.Causes[0], "ciphertext")
+	requireErrorPath(t, joseErr.Causes[1], "payload")
+
+	encoded, err := ipld.Encode(basicnode.NewString("jose"), dagcbor.Encode)
+	require.NoError(t, err)
+	_, err = ipld.Decode(encoded, Decode)
+	require.ErrorIs(t, err, ErrNotJOSE)
+}
+
+func TestEncodeErrors(t *testing.T) {
+	_, err := ipld.Encode(basicnode.NewString("jose"), Encode)
+	require.ErrorIs(t, err, ErrNotJOSE)
+
+	err = EncodeJWS(fluent.MustBuildMap(basicnode.Prototype.Map, 3, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("link").AssignLink(cidlink.Link{Cid: createCid([]byte("other payload"))})
+		ma.AssembleEntry("payload").AssignString(encodeBase64Url(createCid([]byte("payload")).Bytes()))
+		ma.AssembleEntry("signatures").CreateList(0, func(la fluent.ListAssembler) {})
+	}), &bytes.Buffer{})
+	require.ErrorIs(t, err, ErrCIDMismatch)
+	requireErrorPath(t, err, "link")
+}
+
+func TestInvalidSerializationErrors(t *testing.T) {
+	payload := encodeBase64Url(createCid([]byte("payload")).Bytes())
+	_, err := ParseJSON([]byte(`{"payload": "` + payload + `", "protected": "", "signatures": []}`))
+	require.ErrorIs(t, err, ErrInvalidSerialization)
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	requireErrorPath(t, err, "protected")
+
+	_, err = ParseJSON([]byte(`{"ciphertext": "", "header": {}, "recipients": []}`))
+	require.ErrorIs(t, err, ErrInvalidSerialization)
+	require.ErrorIs(t, err, ErrInvalidJWE)
+	requireErrorPath(t, err, "header")
+
+	_, err = ParseJSON([]byte(`{"claims": {}}`))
+	require.ErrorIs(t, err, ErrNotJOSE)
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &Error{
+		Kind: ErrNotJOSE,
+		Causes: []error{
+			&Error{Kind: ErrInvalidJWE, Path: datamodel.ParsePath("ciphertext"), Causes: []error{errors.New("missing")}},
+			&Error{Kind: ErrInvalidJWS, Path: datamodel.ParsePath("payload"), Causes: []error{errors.New("missing")}},
+		},
+	}
+	require.Equal(t, "not a JOSE object: invalid JWE at `ciphertext`: missing; invalid JWS at `payload`: missing", err.Error())
+}
+
+func TestLockedErrorMessage(t *testing.T) {
+	require.Equal(t, "JWE is locked: no key available to decrypt JWE", (&LockedError{Err: ErrNoDecryptionKey}).Error())
+	require.Equal(t, "JWE is locked: JWE decryption failed for key-1, key-2",
+		(&LockedError{KeyIDs: []string{"key-1", "key-2"}, Err: ErrDecryptionFailed}).Error())
+}
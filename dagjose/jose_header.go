@@ -0,0 +1,205 @@
+package dagjose
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-jose/go-jose/v4/json"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// Header holds the parameters of a JOSE header. Registered header parameters (RFC 7515 §4.1 and RFC 7516 §4.1) are
+// available as fields, while all other parameters, including algorithm-specific ones such as `epk`, are kept in Extra.
+type Header struct {
+	Algorithm            string                 // alg
+	EncryptionAlgorithm  string                 // enc
+	Compression          string                 // zip
+	JWKSetURL            string                 // jku
+	JWK                  map[string]interface{} // jwk
+	KeyID                string                 // kid
+	X509URL              string                 // x5u
+	X509CertificateChain []string               // x5c
+	X509Thumbprint       string                 // x5t
+	X509ThumbprintS256   string                 // x5t#S256
+	Type                 string                 // typ
+	ContentType          string                 // cty
+	Critical             []string               // crit
+	// Extra holds the header parameters that are not registered, keyed by name. Parameters from unprotected headers
+	// keep their data model kind, e.g. integers are `int64`, bytes `[]byte` and links `cid.Cid`, while those from the
+	// protected header are decoded from JSON.
+	Extra map[string]interface{}
+}
+
+// ProtectedHeader parses the protected header of the signature. A signature without a protected header results in an
+// empty header.
+func (n _DecodedSignature) ProtectedHeader() (*Header, error) {
+	return parseJOSEHeader(ErrInvalidJWS, &n.protected)
+}
+
+// JOSEHeader returns the union of the protected and unprotected header parameters of the signature (RFC 7515 §4). An
+// error is returned if a parameter is present in both headers.
+func (n _DecodedSignature) JOSEHeader() (*Header, error) {
+	return parseJOSEHeader(ErrInvalidJWS, &n.protected, &n.header)
+}
+
+// ProtectedHeader parses the protected header of the JWE. A JWE without a protected header results in an empty header.
+func (n _DecodedJWE) ProtectedHeader() (*Header, error) {
+	return parseJOSEHeader(ErrInvalidJWE, &n.protected)
+}
+
+// JOSEHeader returns the union of the protected and shared unprotected header parameters of the JWE. The header
+// parameters of a given recipient are included by RecipientHeader. An error is returned if a parameter is present in
+// both headers.
+func (n _DecodedJWE) JOSEHeader() (*Header, error) {
+	return parseJOSEHeader(ErrInvalidJWE, &n.protected, &n.unprotected)
+}
+
+// RecipientHeader returns the union of the protected, shared unprotected and per-recipient unprotected header
+// parameters for the recipient at the given index (RFC 7516 §4). An error is returned if a parameter is present in
+// more than one header.
+func (n _DecodedJWE) RecipientHeader(idx int) (*Header, error) {
+	if !n.recipients.Exists() || (idx < 0) || (idx >= len(n.recipients.v.x)) {
+		return nil, fmt.Errorf("recipient %d does not exist", idx)
+	}
+	return parseJOSEHeader(ErrInvalidJWE, &n.protected, &n.unprotected, &n.recipients.v.x[idx].header)
+}
+
+// parseJOSEHeader parses the union of a protected header, which may be absent, and the given unprotected headers. kind
+// is the error reported if the protected header is invalid, ErrInvalidJWE or ErrInvalidJWS.
+func parseJOSEHeader(kind error, protected MaybeBase64Url, headers ...MaybeAny) (*Header, error) {
+	var protectedBytes []byte
+	if protected.Exists() {
+		protectedBytes = []byte(protected.v.x)
+	}
+	if merged, err := mergeHeaders(kind, protectedBytes, headers...); err != nil {
+		return nil, err
+	} else {
+		return headerFromMap(merged)
+	}
+}
+
+// mergeHeaders returns the union of the protected header parameters and those of the given unprotected headers. An
+// error is returned if a parameter is present in more than one header, and an error of the given kind, ErrInvalidJWE or
+// ErrInvalidJWS, if the protected header is not a JSON object.
+func mergeHeaders(kind error, protected []byte, headers ...MaybeAny) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, 0)
+	if len(protected) > 0 {
+		var value interface{}
+		if err := json.Unmarshal(protected, &value); err != nil {
+			return nil, &Error{Kind: kind, Path: datamodel.ParsePath("protected"), Causes: []error{err}}
+		} else if object, castOk := value.(map[string]interface{}); !castOk {
+			return nil, &Error{
+				Kind:   kind,
+				Path:   datamodel.ParsePath("protected"),
+				Causes: []error{errors.New("protected header is not a JSON object")},
+			}
+		} else {
+			merged = object
+		}
+	}
+	for _, header := range headers {
+		if !header.Exists() {
+			continue
+		}
+		headerMap, err := ipldNodeToGoMap(header.Must().Representation())
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range headerMap {
+			if _, exists := merged[name]; exists {
+				return nil, fmt.Errorf("duplicate header parameter `%s`", name)
+			}
+			merged[name] = value
+		}
+	}
+	return merged, nil
+}
+
+// headerFromMap sorts header parameters into the registered fields of a Header and its Extra map.
+func headerFromMap(m map[string]interface{}) (*Header, error) {
+	header := &Header{}
+	stringFields := map[string]*string{
+		"alg":      &header.Algorithm,
+		"enc":      &header.EncryptionAlgorithm,
+		"zip":      &header.Compression,
+		"jku":      &header.JWKSetURL,
+		"kid":      &header.KeyID,
+		"x5u":      &header.X509URL,
+		"x5t":      &header.X509Thumbprint,
+		"x5t#S256": &header.X509ThumbprintS256,
+		"typ":      &header.Type,
+		"cty":      &header.ContentType,
+	}
+	stringListFields := map[string]*[]string{
+		"x5c":  &header.X509CertificateChain,
+		"crit": &header.Critical,
+	}
+	for name, value := range m {
+		if field, registered := stringFields[name]; registered {
+			if stringValue, castOk := value.(string); !castOk {
+				return nil, fmt.Errorf("header parameter `%s` must be a string", name)
+			} else {
+				*field = stringValue
+			}
+		} else if field, registered := stringListFields[name]; registered {
+			if stringList, castOk := value.([]string); castOk {
+				*field = stringList
+			} else if listValue, castOk := value.([]interface{}); !castOk {
+				return nil, fmt.Errorf("header parameter `%s` must be a list of strings", name)
+			} else {
+				*field = make([]string, len(listValue))
+				for idx, element := range listValue {
+					if (*field)[idx], castOk = element.(string); !castOk {
+						return nil, fmt.Errorf("header parameter `%s` must be a list of strings", name)
+					}
+				}
+			}
+		} else if name == "jwk" {
+			if jwk, castOk := value.(map[string]interface{}); !castOk {
+				return nil, fmt.Errorf("header parameter `%s` must be a JSON object", name)
+			} else {
+				header.JWK = jwk
+			}
+		} else {
+			if header.Extra == nil {
+				header.Extra = make(map[string]interface{})
+			}
+			header.Extra[name] = value
+		}
+	}
+	return header, nil
+}
+
+// Map returns the header parameters as a map, omitting registered parameters that are not set.
+func (h *Header) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(h.Extra)+4)
+	for name, value := range h.Extra {
+		m[name] = value
+	}
+	for name, value := range map[string]string{
+		"alg":      h.Algorithm,
+		"enc":      h.EncryptionAlgorithm,
+		"zip":      h.Compression,
+		"jku":      h.JWKSetURL,
+		"kid":      h.KeyID,
+		"x5u":      h.X509URL,
+		"x5t":      h.X509Thumbprint,
+		"x5t#S256": h.X509ThumbprintS256,
+		"typ":      h.Type,
+		"cty":      h.ContentType,
+	} {
+		if len(value) > 0 {
+			m[name] = value
+		}
+	}
+	if h.JWK != nil {
+		m["jwk"] = h.JWK
+	}
+	if h.X509CertificateChain != nil {
+		m["x5c"] = h.X509CertificateChain
+	}
+	if h.Critical != nil {
+		m["crit"] = h.Critical
+	}
+	return m
+}
@@ -0,0 +1,231 @@
+package dagjose
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestSignatureHeader(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := SignJWS(
+		createCid([]byte("payload")),
+		SigningKey{
+			Key:          edKey,
+			KeyID:        "ed",
+			ExtraHeaders: map[string]interface{}{"typ": "JWT", "cty": "dag-cbor", "crit": []string{"exp"}, "exp": 1234},
+			Header:       map[string]interface{}{"note": "unprotected"},
+		},
+		SigningKey{Key: edKey, Header: map[string]interface{}{"kid": "unprotected"}},
+	)
+	require.NoError(t, err)
+	decoded, err := asDecodedJWS(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	signature := &decoded.signatures.v.x[0]
+
+	protected, err := signature.ProtectedHeader()
+	require.NoError(t, err)
+	expected := &Header{
+		Algorithm:   EdDSA,
+		KeyID:       "ed",
+		Type:        "JWT",
+		ContentType: "dag-cbor",
+		Critical:    []string{"exp"},
+		Extra:       map[string]interface{}{"exp": float64(1234)},
+	}
+	require.Equal(t, expected, protected)
+	header, err := signature.JOSEHeader()
+	require.NoError(t, err)
+	expected.Extra["note"] = "unprotected"
+	require.Equal(t, expected, header)
+
+	// Parameters can come from either header
+	header, err = decoded.signatures.v.x[1].JOSEHeader()
+	require.NoError(t, err)
+	require.Equal(t, &Header{Algorithm: EdDSA, KeyID: "unprotected"}, header)
+	parsed, err := headerFromMap(expected.Map())
+	require.NoError(t, err)
+	require.Equal(t, expected, parsed)
+}
+
+// A parameter must not be present in both the protected and unprotected headers
+func TestSignatureHeaderWithDuplicateParameter(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{Key: edKey, KeyID: "protected", Header: map[string]interface{}{"kid": "unprotected"}})
+	require.NoError(t, err)
+	decoded := roundTripJWS(t, jws)
+	jwsNode, err := asDecodedJWS(decoded)
+	require.NoError(t, err)
+	_, err = jwsNode.signatures.v.x[0].JOSEHeader()
+	require.ErrorContains(t, err, "duplicate header parameter `kid`")
+
+	results, err := Verify(decoded, edKey)
+	require.NoError(t, err)
+	require.ErrorContains(t, results[0].Err, "duplicate header parameter `kid`")
+}
+
+func TestHeaderParameterTypes(t *testing.T) {
+	scenarios := map[string]map[string]interface{}{
+		"`alg` must be a string":          {"alg": 1},
+		"`crit` must be a list of string": {"crit": "exp"},
+		"`x5c` must be a list of strings": {"x5c": []interface{}{1}},
+		"`jwk` must be a JSON object":     {"jwk": "key"},
+	}
+	for expected, m := range scenarios {
+		_, err := headerFromMap(m)
+		require.ErrorContains(t, err, expected)
+	}
+}
+
+func TestRecipientHeader(t *testing.T) {
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwe, err := EncryptOptions{
+		ContentEncryption: XC20P,
+		Unprotected:       map[string]interface{}{"note": "shared"},
+	}.EncryptBlock([]byte("cleartext block"), createCid([]byte("cleartext block")), RecipientKey{Key: x25519Key}, RecipientKey{Key: x25519Key, KeyID: "x25519"})
+	require.NoError(t, err)
+	decoded, err := asDecodedJWE(roundTripJWE(t, jwe))
+	require.NoError(t, err)
+
+	protected, err := decoded.ProtectedHeader()
+	require.NoError(t, err)
+	require.Equal(t, &Header{EncryptionAlgorithm: XC20P}, protected)
+	header, err := decoded.JOSEHeader()
+	require.NoError(t, err)
+	require.Equal(t, &Header{EncryptionAlgorithm: XC20P, Extra: map[string]interface{}{"note": "shared"}}, header)
+	header, err = decoded.RecipientHeader(1)
+	require.NoError(t, err)
+	require.Equal(t, ECDHESXC20PKW, header.Algorithm)
+	require.Equal(t, XC20P, header.EncryptionAlgorithm)
+	require.Equal(t, "x25519", header.KeyID)
+	for _, name := range []string{"epk", "iv", "tag", "note"} {
+		require.Contains(t, header.Extra, name)
+	}
+	_, err = decoded.RecipientHeader(2)
+	require.ErrorContains(t, err, "recipient 2 does not exist")
+}
+
+// A protected header that is valid JSON but not an object must be reported as invalid, not merged with the
+// unprotected headers
+func TestProtectedHeaderNotObject(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	payload := base64.RawURLEncoding.EncodeToString(createCid([]byte("payload")).Bytes())
+	for _, protected := range []string{"null", "[]"} {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(protected))
+		jws, err := ParseJSON([]byte(fmt.Sprintf(
+			`{"payload":"%s","protected":"%s","header":{"kid":"x"},"signature":"AAAA"}`, payload, encoded,
+		)))
+		require.NoError(t, err)
+		decodedJWS, err := asDecodedJWS(roundTripJWS(t, jws))
+		require.NoError(t, err)
+		_, err = decodedJWS.signatures.v.x[0].ProtectedHeader()
+		require.ErrorIs(t, err, ErrInvalidJWS)
+		_, err = decodedJWS.signatures.v.x[0].JOSEHeader()
+		require.ErrorIs(t, err, ErrInvalidJWS)
+		results, err := Verify(decodedJWS, edKey)
+		require.NoError(t, err)
+		require.ErrorIs(t, results[0].Err, ErrInvalidJWS)
+
+		jwe, err := ParseJSON([]byte(fmt.Sprintf(
+			`{"protected":"%s","header":{"kid":"x"},"encrypted_key":"AAAA","iv":"AAAA","ciphertext":"AAAA","tag":"AAAA"}`,
+			encoded,
+		)))
+		require.NoError(t, err)
+		decodedJWE, err := asDecodedJWE(roundTripJWE(t, jwe))
+		require.NoError(t, err)
+		_, err = decodedJWE.JOSEHeader()
+		require.ErrorIs(t, err, ErrInvalidJWE)
+		_, err = decodedJWE.RecipientHeader(0)
+		require.ErrorIs(t, err, ErrInvalidJWE)
+		_, err = DecryptBlock(decodedJWE, x25519Key)
+		require.ErrorIs(t, err, ErrInvalidJWE)
+	}
+}
+
+// Unprotected headers can hold values of every data model kind
+func TestHeaderDataModelKinds(t *testing.T) {
+	link := cidlink.Link{Cid: createCid([]byte("linked"))}
+	// Entries are in canonical order, so that the header compares equal to the decoded one
+	header := fluent.MustBuildMap(basicnode.Prototype.Map, 7, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("b64").AssignBool(false)
+		ma.AssembleEntry("int").AssignInt(-1)
+		ma.AssembleEntry("link").AssignLink(link)
+		ma.AssembleEntry("list").CreateList(3, func(la fluent.ListAssembler) {
+			la.AssembleValue().AssignBool(true)
+			la.AssembleValue().AssignNull()
+			la.AssembleValue().CreateMap(1, func(ma fluent.MapAssembler) {
+				ma.AssembleEntry("link").AssignLink(link)
+			})
+		})
+		ma.AssembleEntry("null").AssignNull()
+		ma.AssembleEntry("bytes").AssignBytes([]byte("bytes"))
+		ma.AssembleEntry("float").AssignFloat(1.5)
+	})
+	jws := fluent.MustBuildMap(Type.EncodedJWS__Repr, 2, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("payload").AssignBytes(createCid([]byte("payload")).Bytes())
+		ma.AssembleEntry("signatures").CreateList(1, func(la fluent.ListAssembler) {
+			la.AssembleValue().CreateMap(2, func(ma fluent.MapAssembler) {
+				ma.AssembleEntry("header").AssignNode(header)
+				ma.AssembleEntry("signature").AssignBytes([]byte("signature"))
+			})
+		})
+	})
+	jwe := fluent.MustBuildMap(Type.EncodedJWE__Repr, 2, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("ciphertext").AssignBytes([]byte("ciphertext"))
+		ma.AssembleEntry("unprotected").AssignNode(header)
+	})
+
+	decodedJWS, err := asDecodedJWS(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.True(t, datamodel.DeepEqual(header, decodedJWS.signatures.v.x[0].header.Must().Representation()))
+	decodedJWE, err := asDecodedJWE(roundTripJWE(t, jwe))
+	require.NoError(t, err)
+	require.True(t, datamodel.DeepEqual(header, decodedJWE.unprotected.Must().Representation()))
+
+	// Parsed headers keep every kind as well
+	parsed, err := decodedJWE.JOSEHeader()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"b64":   false,
+		"int":   int64(-1),
+		"link":  link.Cid,
+		"list":  []interface{}{true, nil, map[string]interface{}{"link": link.Cid}},
+		"null":  nil,
+		"bytes": []byte("bytes"),
+		"float": 1.5,
+	}, parsed.Extra)
+}
+
+// Headers set from Go values keep booleans and null
+func TestHeaderBoolAndNull(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{
+		Key:    edKey,
+		Header: map[string]interface{}{"flag": true, "none": nil, "list": []interface{}{false, nil}},
+	})
+	require.NoError(t, err)
+	decoded, err := AsJWS(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"flag": true, "none": nil, "list": []interface{}{false, nil}}, decoded.Signatures[0].Header)
+
+	// The JSON serialization round-trips as well
+	serialized, err := MarshalGeneralJSON(jws)
+	require.NoError(t, err)
+	parsed, err := ParseJSON(serialized)
+	require.NoError(t, err)
+	decoded, err = AsJWS(roundTripJWS(t, parsed))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"flag": true, "none": nil, "list": []interface{}{false, nil}}, decoded.Signatures[0].Header)
+}
@@ -0,0 +1,195 @@
+package dagjose
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/go-jose/go-jose/v4/json"
+
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// ParseJSON returns a general form JWE/JWS node given the JSON serialization of a JWE/JWS, in either flattened or
+// general form (RFC 7515 §7.2, RFC 7516 §7.2). The returned node can be passed to Encode. The payload of a JWS must
+// not be detached. Headers are parsed as plain JSON, so values such as `{"/": ...}` are kept as maps rather than read
+// as the links or bytes they denote in DAG-JSON.
+func ParseJSON(jsonBytes []byte) (datamodel.Node, error) {
+	buf := bytes.NewReader(jsonBytes)
+	anyBuilder := basicnode.Prototype.Any.NewBuilder()
+	if err := (dagjson.DecodeOptions{
+		ParseLinks: false,
+		ParseBytes: false,
+	}.Decode(anyBuilder, buf)); err != nil {
+		return nil, err
+	} else {
+		anyNode := anyBuilder.Build()
+		if jwe, err := isJWE(anyNode); err != nil {
+			return nil, err
+		} else if jwe {
+			return unflattenJWE(anyNode)
+		} else if jws, err := isJWS(anyNode); err != nil {
+			return nil, err
+		} else if jws {
+			if payload, err := lookupIgnoreAbsent("payload", anyNode); err != nil {
+				return nil, err
+			} else if payload == nil {
+				return nil, &Error{Kind: ErrInvalidJWS, Path: datamodel.ParsePath("payload"), Causes: []error{errMissingPayload}}
+			}
+			return unflattenJWS(anyNode)
+		} else {
+			return nil, &Error{Kind: ErrNotJOSE}
+		}
+	}
+}
+
+// MarshalGeneralJSON renders a dag-jose object in the general JSON serialization (RFC 7515 §7.2.1, RFC 7516 §7.2.1).
+// Binary fields are rendered as base64url-encoded strings.
+func MarshalGeneralJSON(n datamodel.Node) ([]byte, error) {
+	if jose, err := joseToJSONMap(n, false); err != nil {
+		return nil, err
+	} else {
+		return json.Marshal(jose)
+	}
+}
+
+// MarshalFlattenedJSON renders a dag-jose object in the flattened JSON serialization (RFC 7515 §7.2.2,
+// RFC 7516 §7.2.2). Binary fields are rendered as base64url-encoded strings. An error is returned if a JWS does not
+// have exactly one signature or a JWE has more than one recipient.
+func MarshalFlattenedJSON(n datamodel.Node) ([]byte, error) {
+	if jose, err := joseToJSONMap(n, true); err != nil {
+		return nil, err
+	} else {
+		return json.Marshal(jose)
+	}
+}
+
+func joseToJSONMap(n datamodel.Node, flattened bool) (map[string]interface{}, error) {
+	if jwe, err := isJWE(n); err != nil {
+		return nil, err
+	} else if jwe {
+		return jweToJSONMap(n, flattened)
+	} else if jws, err := isJWS(n); err != nil {
+		return nil, err
+	} else if jws {
+		return jwsToJSONMap(n, flattened)
+	}
+	return nil, &Error{Kind: ErrNotJOSE}
+}
+
+func jweToJSONMap(n datamodel.Node, flattened bool) (map[string]interface{}, error) {
+	n, err := unflattenJWE(n)
+	if err != nil {
+		return nil, err
+	}
+	jwe := make(map[string]interface{}, 0)
+	if err := copyJSONStrings(n, jwe, "aad", "ciphertext", "iv", "protected", "tag"); err != nil {
+		return nil, err
+	} else if err := copyJSONHeader(n, jwe, "unprotected"); err != nil {
+		return nil, err
+	}
+	var recipientList []map[string]interface{}
+	if recipients, err := lookupIgnoreAbsent("recipients", n); err != nil {
+		return nil, err
+	} else if recipients != nil {
+		for itr := recipients.ListIterator(); !itr.Done(); {
+			if _, recipient, err := itr.Next(); err != nil {
+				return nil, err
+			} else {
+				recipientMap := make(map[string]interface{}, 0)
+				if err := copyJSONStrings(recipient, recipientMap, "encrypted_key"); err != nil {
+					return nil, err
+				} else if err := copyJSONHeader(recipient, recipientMap, "header"); err != nil {
+					return nil, err
+				}
+				recipientList = append(recipientList, recipientMap)
+			}
+		}
+	}
+	if flattened {
+		if len(recipientList) > 1 {
+			return nil, errors.New("flattened JWE serialization allows at most one recipient")
+		} else if len(recipientList) == 1 {
+			for key, value := range recipientList[0] {
+				jwe[key] = value
+			}
+		}
+	} else if len(recipientList) > 0 {
+		jwe["recipients"] = recipientList
+	}
+	return jwe, nil
+}
+
+func jwsToJSONMap(n datamodel.Node, flattened bool) (map[string]interface{}, error) {
+	n, err := unflattenJWS(n)
+	if err != nil {
+		return nil, err
+	}
+	jws := make(map[string]interface{}, 0)
+	if err := copyJSONStrings(n, jws, "payload"); err != nil {
+		return nil, err
+	}
+	var signatureList []map[string]interface{}
+	if signatures, err := lookupIgnoreAbsent("signatures", n); err != nil {
+		return nil, err
+	} else if signatures != nil {
+		for itr := signatures.ListIterator(); !itr.Done(); {
+			if _, signature, err := itr.Next(); err != nil {
+				return nil, err
+			} else {
+				signatureMap := make(map[string]interface{}, 0)
+				if err := copyJSONStrings(signature, signatureMap, "protected", "signature"); err != nil {
+					return nil, err
+				} else if err := copyJSONHeader(signature, signatureMap, "header"); err != nil {
+					return nil, err
+				}
+				signatureList = append(signatureList, signatureMap)
+			}
+		}
+	}
+	if flattened {
+		if len(signatureList) != 1 {
+			return nil, errors.New("flattened JWS serialization requires exactly one signature")
+		}
+		for key, value := range signatureList[0] {
+			jws[key] = value
+		}
+	} else if len(signatureList) > 0 {
+		jws["signatures"] = signatureList
+	}
+	return jws, nil
+}
+
+// copyJSONStrings copies the base64url-encoded string form of the given fields, if present, into a JSON map.
+func copyJSONStrings(n datamodel.Node, m map[string]interface{}, keys ...string) error {
+	for _, key := range keys {
+		if value, err := lookupIgnoreNoSuchField(key, n); err != nil {
+			return err
+		} else if (value != nil) && !value.IsNull() {
+			if m[key], err = lookupString(key, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyJSONHeader copies a header field, if present, into a JSON map. Bytes and links within the header are rendered
+// the way DAG-JSON renders them.
+func copyJSONHeader(n datamodel.Node, m map[string]interface{}, key string) error {
+	if header, err := lookupIgnoreNoSuchField(key, n); err != nil {
+		return err
+	} else if (header != nil) && !header.IsNull() {
+		headerJSON := bytes.NewBuffer([]byte{})
+		if err := (dagjson.EncodeOptions{
+			EncodeLinks: true,
+			EncodeBytes: true,
+		}.Encode(header, headerJSON)); err != nil {
+			return err
+		}
+		rawHeader := json.RawMessage(headerJSON.Bytes())
+		m[key] = &rawHeader
+	}
+	return nil
+}
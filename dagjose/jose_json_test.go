@@ -0,0 +1,195 @@
+package dagjose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"pgregory.net/rapid"
+)
+
+func requireJSONEqual(t require.TestingT, expected []byte, actual []byte) {
+	var expectedMap, actualMap map[string]interface{}
+	require.NoError(t, json.Unmarshal(expected, &expectedMap))
+	require.NoError(t, json.Unmarshal(actual, &actualMap))
+	require.Equal(t, expectedMap, actualMap)
+}
+
+// A JWS produced by go-jose must survive a trip through dag-jose without any loss
+func TestRoundTripJSONJWS(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		link := cidGen().Draw(t, "JSON JWS payload").(cid.Cid)
+		privateKey := ed25519PrivateKeyGen().Draw(t, "JSON JWS private key").(ed25519.PrivateKey)
+		signer, err := gojose.NewSigner(
+			gojose.SigningKey{Algorithm: gojose.EdDSA, Key: privateKey},
+			(&gojose.SignerOptions{}).WithHeader("kid", "did:key:z6Mk#z6Mk"),
+		)
+		require.NoError(t, err)
+		signed, err := signer.Sign(link.Bytes())
+		require.NoError(t, err)
+		flattened := []byte(signed.FullSerialize())
+
+		jws, err := ParseJSON(flattened)
+		require.NoError(t, err)
+		encoded, err := ipld.Encode(jws, Encode)
+		require.NoError(t, err)
+		decoded, err := ipld.Decode(encoded, Decode)
+		require.NoError(t, err)
+
+		roundTripped, err := MarshalFlattenedJSON(decoded)
+		require.NoError(t, err)
+		requireJSONEqual(t, flattened, roundTripped)
+		general, err := MarshalGeneralJSON(decoded)
+		require.NoError(t, err)
+		parsed, err := gojose.ParseSigned(string(general), []gojose.SignatureAlgorithm{gojose.EdDSA})
+		require.NoError(t, err)
+		payload, err := parsed.Verify(privateKey.Public())
+		require.NoError(t, err)
+		require.Equal(t, link.Bytes(), payload)
+	})
+}
+
+// A multi-recipient JWE produced by go-jose, including nested recipient headers, must survive a trip through dag-jose
+// without any loss
+func TestRoundTripJSONJWE(t *testing.T) {
+	firstKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	secondKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	encrypter, err := gojose.NewMultiEncrypter(gojose.A256GCM, []gojose.Recipient{
+		{Algorithm: gojose.ECDH_ES_A256KW, Key: &firstKey.PublicKey, KeyID: "first"},
+		{Algorithm: gojose.ECDH_ES_A256KW, Key: &secondKey.PublicKey, KeyID: "second"},
+	}, nil)
+	require.NoError(t, err)
+	encrypted, err := encrypter.EncryptWithAuthData([]byte("plaintext"), []byte("aad"))
+	require.NoError(t, err)
+	// go-jose duplicates the first recipient's `encrypted_key` at the top level of a general JWE, which RFC 7516 does
+	// not allow, so drop it here.
+	var generalMap map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(encrypted.FullSerialize()), &generalMap))
+	delete(generalMap, "encrypted_key")
+	general, err := json.Marshal(generalMap)
+	require.NoError(t, err)
+
+	jwe, err := ParseJSON(general)
+	require.NoError(t, err)
+	encoded, err := ipld.Encode(jwe, Encode)
+	require.NoError(t, err)
+	decoded, err := ipld.Decode(encoded, Decode)
+	require.NoError(t, err)
+
+	roundTripped, err := MarshalGeneralJSON(decoded)
+	require.NoError(t, err)
+	requireJSONEqual(t, general, roundTripped)
+	parsed, err := gojose.ParseEncryptedJSON(
+		string(roundTripped),
+		[]gojose.KeyAlgorithm{gojose.ECDH_ES_A256KW},
+		[]gojose.ContentEncryption{gojose.A256GCM},
+	)
+	require.NoError(t, err)
+	_, _, plaintext, err := parsed.DecryptMulti(secondKey)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plaintext"), plaintext)
+
+	_, err = MarshalFlattenedJSON(decoded)
+	require.ErrorContains(t, err, "at most one recipient")
+}
+
+// Flattened JWS serialization requires exactly one signature
+func TestMarshalFlattenedJSONJWSErrors(t *testing.T) {
+	payload := encodeBase64Url(createCid([]byte("payload")).Bytes())
+	jws, err := ParseJSON([]byte(`{"payload":"` + payload + `","signatures":[{"signature":"c2ln"},{"signature":"c2ln"}]}`))
+	require.NoError(t, err)
+	_, err = MarshalFlattenedJSON(jws)
+	require.ErrorContains(t, err, "exactly one signature")
+	general, err := MarshalGeneralJSON(jws)
+	require.NoError(t, err)
+	requireJSONEqual(t, []byte(`{"payload":"`+payload+`","signatures":[{"signature":"c2ln"},{"signature":"c2ln"}]}`), general)
+
+	// A JWS without signatures is serialized without `signatures`, like a JWE without recipients
+	jws, err = ParseJSON([]byte(`{"payload":"` + payload + `","signatures":[]}`))
+	require.NoError(t, err)
+	general, err = MarshalGeneralJSON(jws)
+	require.NoError(t, err)
+	requireJSONEqual(t, []byte(`{"payload":"`+payload+`"}`), general)
+}
+
+// Typed nodes returned by the loaders must render headers as plain JSON values
+func TestMarshalJSONFromTypedNode(t *testing.T) {
+	general := []byte(`{"ciphertext":"Y3Q","protected":"cHI","recipients":[{"encrypted_key":"a2V5","header":{"alg":"A256KW","epk":{"x":"y"}}}]}`)
+	jwe, err := ParseJSON(general)
+	require.NoError(t, err)
+	ls := memoryLinkSystem()
+	link, err := StoreJOSE(ipld.LinkContext{}, jwe, ls)
+	require.NoError(t, err)
+	decodedJWE, err := LoadJWE(link, ipld.LinkContext{}, ls)
+	require.NoError(t, err)
+	roundTripped, err := MarshalGeneralJSON(decodedJWE)
+	require.NoError(t, err)
+	requireJSONEqual(t, general, roundTripped)
+}
+
+// Bytes and links in headers are rendered as in DAG-JSON
+func TestJSONHeaderKinds(t *testing.T) {
+	link := cidlink.Link{Cid: createCid([]byte("linked"))}
+	flattened := fluent.MustBuildMap(basicnode.Prototype.Map, 3, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("header").CreateMap(4, func(ma fluent.MapAssembler) {
+			ma.AssembleEntry("bytes").AssignBytes([]byte("bytes"))
+			ma.AssembleEntry("int").AssignInt(1)
+			ma.AssembleEntry("link").AssignLink(link)
+			ma.AssembleEntry("null").AssignNull()
+		})
+		ma.AssembleEntry("payload").AssignString(encodeBase64Url(createCid([]byte("payload")).Bytes()))
+		ma.AssembleEntry("signature").AssignString(encodeBase64Url([]byte("signature")))
+	})
+	serialized, err := MarshalFlattenedJSON(flattened)
+	require.NoError(t, err)
+	var jsonMap map[string]interface{}
+	require.NoError(t, json.Unmarshal(serialized, &jsonMap))
+	require.Equal(t, map[string]interface{}{
+		"bytes": map[string]interface{}{"/": map[string]interface{}{"bytes": "Ynl0ZXM"}},
+		"int":   float64(1),
+		"link":  map[string]interface{}{"/": link.String()},
+		"null":  nil,
+	}, jsonMap["header"])
+
+	// Headers are parsed as plain JSON, so the rendered bytes and link are read back as maps, and the JSON round-trips
+	parsed, err := ParseJSON(serialized)
+	require.NoError(t, err)
+	decoded, err := asDecodedJWS(roundTripJWS(t, parsed))
+	require.NoError(t, err)
+	header, err := decoded.signatures.v.x[0].JOSEHeader()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"bytes": map[string]interface{}{"/": map[string]interface{}{"bytes": "Ynl0ZXM"}},
+		"int":   int64(1),
+		"link":  map[string]interface{}{"/": link.String()},
+		"null":  nil,
+	}, header.Extra)
+	reserialized, err := MarshalFlattenedJSON(decoded)
+	require.NoError(t, err)
+	requireJSONEqual(t, serialized, reserialized)
+}
+
+// Plain JSON header values with a "/" key must round-trip as the user's JSON, even when they are not valid DAG-JSON
+// links or bytes
+func TestJSONHeaderWithSlashKey(t *testing.T) {
+	payload := encodeBase64Url(createCid([]byte("payload")).Bytes())
+	flattened := []byte(`{"header":{"a":{"/":"x"},"b":{"/":{"bytes":"!!"}},"c":{"/":1,"d":2}},"payload":"` + payload +
+		`","signature":"c2ln"}`)
+	jws, err := ParseJSON(flattened)
+	require.NoError(t, err)
+	reserialized, err := MarshalFlattenedJSON(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	requireJSONEqual(t, flattened, reserialized)
+}
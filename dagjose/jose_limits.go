@@ -0,0 +1,453 @@
+package dagjose
+
+import (
+	"io"
+	"math"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// The limits set in DecodeOptions are enforced by wrapping the reader a block is decoded from and the assemblers of the
+// top-level fields they apply to. The wrappers only intercept the methods used by the DAG-CBOR decoder, and check
+// declared lengths before the decoder or the builders allocate anything for them.
+
+// limitReader reads at most max bytes from a block, failing with a LimitError once a block turns out to be larger.
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, &LimitError{Limit: "MaxBlockSize", Max: lr.max}
+	}
+	// Read one byte more than allowed so that a block of exactly the maximum size is not mistaken for a larger one
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return n, &LimitError{Limit: "MaxBlockSize", Max: lr.max}
+	}
+	return n, err
+}
+
+// lengthLimitReader follows the structure of a block as it is read, and fails with a LimitError before passing on the
+// head of a byte or text string declared to be longer than allowed. The DAG-CBOR decoder allocates a string as soon as
+// it has read its head, so a string cannot be rejected by its assembler before it is allocated.
+type lengthLimitReader struct {
+	r   io.Reader
+	cfg *DecodeOptions
+	// read is the number of bytes read so far.
+	read int64
+	// head holds the bytes of the head being read.
+	head []byte
+	// skip is the number of bytes of the string being read that are still to come.
+	skip int64
+	// stack holds the maps, lists and indefinite-length strings being read.
+	stack []cborFrame
+	// key holds the top-level map key being read, if any, and lastKey the last one read.
+	key     []byte
+	lastKey string
+	// done is set once the block has been read or turns out to be malformed, which is left to the decoder to report.
+	done bool
+}
+
+// cborFrame is a map, list or indefinite-length string being read by a lengthLimitReader.
+type cborFrame struct {
+	// left is the number of items left in a definite-length map or list, or -1.
+	left int64
+	// items is the number of items read so far, counting map keys and values separately.
+	items int64
+	isMap bool
+	// isString is set for an indefinite-length string, whose length is that of its chunks so far.
+	isString   bool
+	length     int64
+	ciphertext bool
+}
+
+// maxKeyLength is the length of the longest top-level key whose value is limited.
+const maxKeyLength = len("ciphertext")
+
+func (lr *lengthLimitReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	for i := 0; (i < n) && !lr.done; i++ {
+		if lr.skip > 0 {
+			m := lr.skip
+			if int64(n-i) < m {
+				m = int64(n - i)
+			}
+			if lr.key != nil {
+				lr.key = append(lr.key, p[i:i+int(m)]...)
+			}
+			if lr.skip -= m; lr.skip == 0 {
+				lr.complete()
+			}
+			i += int(m) - 1
+			continue
+		}
+		lr.head = append(lr.head, p[i])
+		// Withhold the head, or what of it is part of p, so that the decoder never gets to the declared length
+		start := i + 1 - len(lr.head)
+		if limitErr := lr.scanHead(lr.read + int64(i) + 1); limitErr != nil {
+			if start < 0 {
+				start = 0
+			}
+			return start, limitErr
+		}
+	}
+	lr.read += int64(n)
+	return n, err
+}
+
+// scanHead scans the head being read, given the number of bytes read up to its last byte so far.
+func (lr *lengthLimitReader) scanHead(read int64) error {
+	major, info := lr.head[0]>>5, lr.head[0]&0x1f
+	size := 1
+	if (info >= 24) && (info <= 27) {
+		size += 1 << (info - 24)
+	} else if (info > 27) && ((info != 31) || (major < 2) || (major == 6)) {
+		lr.done = true
+		return nil
+	}
+	if len(lr.head) < size {
+		return nil
+	}
+	arg := uint64(info)
+	if size > 1 {
+		arg = 0
+		for _, b := range lr.head[1:] {
+			arg = (arg << 8) | uint64(b)
+		}
+	}
+	lr.head = lr.head[:0]
+	top := lr.topLevel()
+	if (top != nil) && (top.items%2 == 0) {
+		lr.lastKey = ""
+	}
+	switch {
+	case (major == 2) || (major == 3):
+		if info == 31 {
+			ciphertext := (top != nil) && (top.items%2 == 1) && (lr.lastKey == "ciphertext")
+			lr.stack = append(lr.stack, cborFrame{left: -1, isString: true, ciphertext: ciphertext})
+			return nil
+		}
+		return lr.beginString(major, arg, read)
+	case (major == 4) || (major == 5):
+		left := int64(-1)
+		if info != 31 {
+			if arg > math.MaxInt64/2 {
+				lr.done = true
+				return nil
+			}
+			if left = int64(arg); major == 5 {
+				left *= 2
+			}
+		}
+		lr.stack = append(lr.stack, cborFrame{left: left, isMap: major == 5})
+		if left == 0 {
+			lr.stack = lr.stack[:len(lr.stack)-1]
+			lr.complete()
+		}
+	case major == 6:
+		// A tag applies to the item that follows
+	case info == 31:
+		// A break ends the innermost indefinite-length item
+		if len(lr.stack) == 0 {
+			lr.done = true
+			return nil
+		}
+		lr.stack = lr.stack[:len(lr.stack)-1]
+		lr.complete()
+	default:
+		lr.complete()
+	}
+	return nil
+}
+
+// beginString checks the declared length of a byte or text string, given the number of bytes read up to its head.
+func (lr *lengthLimitReader) beginString(major byte, arg uint64, read int64) error {
+	if arg > math.MaxInt64 {
+		lr.done = true
+		return nil
+	}
+	length, total := int64(arg), int64(arg)
+	top := lr.topLevel()
+	ciphertext := (top != nil) && (top.items%2 == 1) && (lr.lastKey == "ciphertext")
+	if len(lr.stack) > 0 {
+		if frame := &lr.stack[len(lr.stack)-1]; frame.isString {
+			// A chunk of an indefinite-length string
+			frame.length += length
+			total, ciphertext = frame.length, frame.ciphertext
+		}
+	}
+	if ciphertext && (lr.cfg.MaxCiphertextSize > 0) && (total > lr.cfg.MaxCiphertextSize) {
+		return &LimitError{Limit: "MaxCiphertextSize", Max: lr.cfg.MaxCiphertextSize}
+	}
+	if (lr.cfg.MaxBlockSize > 0) && (length > lr.cfg.MaxBlockSize-read) {
+		return &LimitError{Limit: "MaxBlockSize", Max: lr.cfg.MaxBlockSize}
+	}
+	if (top != nil) && (top.items%2 == 0) && (major == 3) && (length <= int64(maxKeyLength)) {
+		lr.key = make([]byte, 0, length)
+	}
+	if lr.skip = length; length == 0 {
+		lr.complete()
+	}
+	return nil
+}
+
+// complete records that an item has been read.
+func (lr *lengthLimitReader) complete() {
+	if lr.key != nil {
+		lr.lastKey, lr.key = string(lr.key), nil
+	}
+	for len(lr.stack) > 0 {
+		frame := &lr.stack[len(lr.stack)-1]
+		if frame.isString {
+			// Only a chunk of the string has been read
+			return
+		}
+		frame.items++
+		if frame.left < 0 {
+			return
+		} else if frame.left--; frame.left > 0 {
+			return
+		}
+		lr.stack = lr.stack[:len(lr.stack)-1]
+	}
+	lr.done = true
+}
+
+// topLevel returns the top-level map if it is the innermost item being read, or nil.
+func (lr *lengthLimitReader) topLevel() *cborFrame {
+	if (len(lr.stack) == 1) && lr.stack[0].isMap {
+		return &lr.stack[0]
+	}
+	return nil
+}
+
+// limit wraps the assembler of the given top-level field to enforce the limits that apply to it.
+func (cfg DecodeOptions) limit(field string, na datamodel.NodeAssembler) datamodel.NodeAssembler {
+	switch field {
+	case "signatures":
+		if (cfg.MaxSignatures > 0) || cfg.limitsHeaders() {
+			return &listLimitAssembler{na, &cfg, "MaxSignatures", cfg.MaxSignatures}
+		}
+	case "recipients":
+		if (cfg.MaxRecipients > 0) || cfg.limitsHeaders() {
+			return &listLimitAssembler{na, &cfg, "MaxRecipients", cfg.MaxRecipients}
+		}
+	case "unprotected":
+		if cfg.limitsHeaders() {
+			return cfg.limitHeader(na)
+		}
+	}
+	return na
+}
+
+func (cfg *DecodeOptions) limitsHeaders() bool {
+	return (cfg.MaxHeaderDepth > 0) || (cfg.MaxHeaderSize > 0)
+}
+
+func (cfg *DecodeOptions) limitHeader(na datamodel.NodeAssembler) datamodel.NodeAssembler {
+	return &headerLimitAssembler{na, cfg, 0, new(int64)}
+}
+
+// listLimitAssembler limits the number of signatures or recipients, and the headers within them.
+type listLimitAssembler struct {
+	datamodel.NodeAssembler
+	cfg   *DecodeOptions
+	limit string
+	max   int64
+}
+
+func (la *listLimitAssembler) BeginList(sizeHint int64) (datamodel.ListAssembler, error) {
+	if (la.max > 0) && (sizeHint > la.max) {
+		return nil, &LimitError{Limit: la.limit, Max: la.max}
+	}
+	if ls, err := la.NodeAssembler.BeginList(sizeHint); err != nil {
+		return nil, err
+	} else {
+		return &listLimitListAssembler{ls, la, 0}, nil
+	}
+}
+
+type listLimitListAssembler struct {
+	datamodel.ListAssembler
+	la    *listLimitAssembler
+	count int64
+}
+
+func (ls *listLimitListAssembler) AssembleValue() datamodel.NodeAssembler {
+	// The length of indefinite-length lists is only known as values are assembled
+	if ls.count++; (ls.la.max > 0) && (ls.count > ls.la.max) {
+		return limitExceededAssembler{&LimitError{Limit: ls.la.limit, Max: ls.la.max}}
+	}
+	return &entryLimitAssembler{ls.ListAssembler.AssembleValue(), ls.la.cfg}
+}
+
+// entryLimitAssembler limits the `header` of a signature or recipient.
+type entryLimitAssembler struct {
+	datamodel.NodeAssembler
+	cfg *DecodeOptions
+}
+
+func (ea *entryLimitAssembler) BeginMap(sizeHint int64) (datamodel.MapAssembler, error) {
+	if ma, err := ea.NodeAssembler.BeginMap(sizeHint); err != nil {
+		return nil, err
+	} else {
+		return &entryLimitMapAssembler{ma, ea.cfg}, nil
+	}
+}
+
+type entryLimitMapAssembler struct {
+	datamodel.MapAssembler
+	cfg *DecodeOptions
+}
+
+func (ema *entryLimitMapAssembler) AssembleEntry(k string) (datamodel.NodeAssembler, error) {
+	if va, err := ema.MapAssembler.AssembleEntry(k); err != nil {
+		return nil, err
+	} else if (k == "header") && ema.cfg.limitsHeaders() {
+		return ema.cfg.limitHeader(va), nil
+	} else {
+		return va, nil
+	}
+}
+
+// headerLimitAssembler limits the nesting depth and the number of values of a header. The header itself is at depth
+// zero, and counts as a value.
+type headerLimitAssembler struct {
+	datamodel.NodeAssembler
+	cfg   *DecodeOptions
+	depth int
+	size  *int64
+}
+
+// reserve accounts for the given number of values about to be assembled in the header.
+func (ha *headerLimitAssembler) reserve(count int64) error {
+	if *ha.size += count; (ha.cfg.MaxHeaderSize > 0) && (*ha.size > ha.cfg.MaxHeaderSize) {
+		return &LimitError{Limit: "MaxHeaderSize", Max: ha.cfg.MaxHeaderSize}
+	}
+	return nil
+}
+
+// nest returns the assembler of a value nested within a map or list of the header.
+func (ha *headerLimitAssembler) nest(na datamodel.NodeAssembler) datamodel.NodeAssembler {
+	if err := ha.reserve(1); err != nil {
+		return limitExceededAssembler{err}
+	}
+	return &headerLimitAssembler{na, ha.cfg, ha.depth + 1, ha.size}
+}
+
+func (ha *headerLimitAssembler) begin(sizeHint int64) error {
+	if (ha.cfg.MaxHeaderDepth > 0) && (ha.depth >= ha.cfg.MaxHeaderDepth) {
+		return &LimitError{Limit: "MaxHeaderDepth", Max: int64(ha.cfg.MaxHeaderDepth)}
+	}
+	if ha.depth == 0 {
+		if err := ha.reserve(1); err != nil {
+			return err
+		}
+	}
+	// Fail before anything is allocated for a collection that is declared to be too large
+	if (ha.cfg.MaxHeaderSize > 0) && (*ha.size+sizeHint > ha.cfg.MaxHeaderSize) {
+		return &LimitError{Limit: "MaxHeaderSize", Max: ha.cfg.MaxHeaderSize}
+	}
+	return nil
+}
+
+func (ha *headerLimitAssembler) BeginMap(sizeHint int64) (datamodel.MapAssembler, error) {
+	if err := ha.begin(sizeHint); err != nil {
+		return nil, err
+	} else if ma, err := ha.NodeAssembler.BeginMap(sizeHint); err != nil {
+		return nil, err
+	} else {
+		return &headerLimitMapAssembler{ma, ha}, nil
+	}
+}
+
+func (ha *headerLimitAssembler) BeginList(sizeHint int64) (datamodel.ListAssembler, error) {
+	if err := ha.begin(sizeHint); err != nil {
+		return nil, err
+	} else if la, err := ha.NodeAssembler.BeginList(sizeHint); err != nil {
+		return nil, err
+	} else {
+		return &headerLimitListAssembler{la, ha}, nil
+	}
+}
+
+type headerLimitMapAssembler struct {
+	datamodel.MapAssembler
+	ha *headerLimitAssembler
+}
+
+func (hma *headerLimitMapAssembler) AssembleEntry(k string) (datamodel.NodeAssembler, error) {
+	if err := hma.ha.reserve(1); err != nil {
+		return nil, err
+	} else if va, err := hma.MapAssembler.AssembleEntry(k); err != nil {
+		return nil, err
+	} else {
+		return &headerLimitAssembler{va, hma.ha.cfg, hma.ha.depth + 1, hma.ha.size}, nil
+	}
+}
+
+type headerLimitListAssembler struct {
+	datamodel.ListAssembler
+	ha *headerLimitAssembler
+}
+
+func (hla *headerLimitListAssembler) AssembleValue() datamodel.NodeAssembler {
+	return hla.ha.nest(hla.ListAssembler.AssembleValue())
+}
+
+// limitExceededAssembler is returned where an assembler is required but a limit was exceeded, and fails to assemble
+// anything.
+type limitExceededAssembler struct {
+	err error
+}
+
+func (la limitExceededAssembler) BeginMap(int64) (datamodel.MapAssembler, error) {
+	return nil, la.err
+}
+
+func (la limitExceededAssembler) BeginList(int64) (datamodel.ListAssembler, error) {
+	return nil, la.err
+}
+
+func (la limitExceededAssembler) AssignNull() error {
+	return la.err
+}
+
+func (la limitExceededAssembler) AssignBool(bool) error {
+	return la.err
+}
+
+func (la limitExceededAssembler) AssignInt(int64) error {
+	return la.err
+}
+
+func (la limitExceededAssembler) AssignFloat(float64) error {
+	return la.err
+}
+
+func (la limitExceededAssembler) AssignString(string) error {
+	return la.err
+}
+
+func (la limitExceededAssembler) AssignBytes([]byte) error {
+	return la.err
+}
+
+func (la limitExceededAssembler) AssignLink(datamodel.Link) error {
+	return la.err
+}
+
+func (la limitExceededAssembler) AssignNode(datamodel.Node) error {
+	return la.err
+}
+
+func (la limitExceededAssembler) Prototype() datamodel.NodePrototype {
+	return nil
+}
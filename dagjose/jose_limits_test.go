@@ -0,0 +1,166 @@
+package dagjose
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Check that a block decodes with the given limits when the limit is set to max, and fails with a LimitError when the
+// limit is set to max-1.
+func requireLimit(t *testing.T, block []byte, limit string, max int64, path string, set func(cfg *DecodeOptions, max int64)) {
+	cfg := DecodeOptions{AddLink: true}
+	set(&cfg, max)
+	_, err := ipld.Decode(block, cfg.Decode)
+	require.NoError(t, err)
+
+	set(&cfg, max-1)
+	_, err = ipld.Decode(block, cfg.Decode)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitError{Limit: limit, Max: max - 1}, *limitErr)
+	if len(path) > 0 {
+		requireErrorPath(t, err, path)
+	}
+}
+
+func limitedJWS(t *testing.T) []byte {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	header := map[string]interface{}{"nested": map[string]interface{}{"list": []interface{}{"a", "b"}}}
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{Key: key}, SigningKey{Key: key, Header: header}, SigningKey{Key: key})
+	require.NoError(t, err)
+	block, err := ipld.Encode(jws, Encode)
+	require.NoError(t, err)
+	return block
+}
+
+func limitedJWE(t *testing.T) []byte {
+	// RSA recipients only have an `alg` header parameter, leaving `unprotected` as the largest header
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwe, err := EncryptOptions{
+		Unprotected: map[string]interface{}{"a": "1", "b": map[string]interface{}{"c": "2"}},
+	}.EncryptBlock(make([]byte, 100), cid.Undef, RecipientKey{Key: key}, RecipientKey{Key: key}, RecipientKey{Key: &key.PublicKey})
+	require.NoError(t, err)
+	block, err := ipld.Encode(jwe, Encode)
+	require.NoError(t, err)
+	return block
+}
+
+func TestDecodeLimits(t *testing.T) {
+	jws := limitedJWS(t)
+	jwe := limitedJWE(t)
+
+	t.Run("block size", func(t *testing.T) {
+		requireLimit(t, jws, "MaxBlockSize", int64(len(jws)), "", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxBlockSize = max
+		})
+	})
+	t.Run("signatures", func(t *testing.T) {
+		requireLimit(t, jws, "MaxSignatures", 3, "signatures", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxSignatures = max
+		})
+	})
+	t.Run("recipients", func(t *testing.T) {
+		requireLimit(t, jwe, "MaxRecipients", 3, "recipients", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxRecipients = max
+		})
+	})
+	t.Run("signature header depth", func(t *testing.T) {
+		requireLimit(t, jws, "MaxHeaderDepth", 3, "signatures", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxHeaderDepth = int(max)
+		})
+	})
+	t.Run("signature header size", func(t *testing.T) {
+		// The header, `nested`, `list` and its two elements
+		requireLimit(t, jws, "MaxHeaderSize", 5, "signatures", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxHeaderSize = max
+		})
+	})
+	t.Run("unprotected header depth", func(t *testing.T) {
+		requireLimit(t, jwe, "MaxHeaderDepth", 2, "unprotected", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxHeaderDepth = int(max)
+		})
+	})
+	t.Run("unprotected header size", func(t *testing.T) {
+		// The header, `a`, `b` and `c`
+		requireLimit(t, jwe, "MaxHeaderSize", 4, "unprotected", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxHeaderSize = max
+		})
+	})
+	t.Run("ciphertext size", func(t *testing.T) {
+		requireLimit(t, jwe, "MaxCiphertextSize", 100, "ciphertext", func(cfg *DecodeOptions, max int64) {
+			cfg.MaxCiphertextSize = max
+		})
+	})
+}
+
+// Limits also apply to lists whose length is not declared up front
+func TestDecodeLimitsIndefiniteLength(t *testing.T) {
+	jws := limitedJWS(t)
+	// `signatures` is the last field of the JWS, so its list runs until the end of the block
+	key := append([]byte{0x6a}, "signatures"...)
+	idx := bytes.Index(jws, key) + len(key)
+	require.Equal(t, byte(0x83), jws[idx])
+	indefinite := append(append(append([]byte{}, jws[:idx]...), 0x9f), jws[idx+1:]...)
+	indefinite = append(indefinite, 0xff)
+
+	n, err := ipld.Decode(indefinite, Decode)
+	require.NoError(t, err)
+	signatures, err := n.LookupByString("signatures")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), signatures.Length())
+	_, err = ipld.Decode(indefinite, DecodeOptions{MaxSignatures: 2}.Decode)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+// Declared lengths are checked before anything is allocated for them
+func TestDecodeLimitsDeclaredLength(t *testing.T) {
+	// A map with `signatures` declaring a million elements, followed by none
+	block := append(append([]byte{0xa1, 0x6a}, "signatures"...), 0x9a, 0x00, 0x0f, 0x42, 0x40)
+	_, err := ipld.Decode(block, DecodeOptions{MaxSignatures: 16}.Decode)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.ErrorIs(t, err, ErrInvalidJWS)
+	var joseErr *Error
+	require.True(t, errors.As(err, &joseErr))
+	require.Equal(t, datamodel.ParsePath("signatures"), joseErr.Path)
+}
+
+// The declared length of a string is checked before the decoder allocates the string
+func TestDecodeLimitsDeclaredStringLength(t *testing.T) {
+	// A map with `ciphertext` declaring 32MiB of bytes, followed by none
+	block := append(append([]byte{0xa1, 0x6a}, "ciphertext"...), 0x5a, 0x02, 0x00, 0x00, 0x00)
+	allocated := func(cfg DecodeOptions) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := ipld.Decode(block, cfg.Decode)
+		runtime.ReadMemStats(&after)
+		require.ErrorIs(t, err, ErrLimitExceeded)
+		require.ErrorIs(t, err, ErrInvalidJWE)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	require.Less(t, allocated(DecodeOptions{MaxCiphertextSize: 1024}), uint64(1<<20))
+	require.Less(t, allocated(DecodeOptions{MaxBlockSize: 1024}), uint64(1<<20))
+
+	// The chunks of an indefinite-length ciphertext count towards its size
+	block = append(append([]byte{0xa1, 0x6a}, "ciphertext"...), 0x5f, 0x43, 'a', 'b', 'c', 0x43, 'd', 'e', 'f', 0xff)
+	_, err := ipld.Decode(block, DecodeOptions{MaxCiphertextSize: 6}.Decode)
+	require.NotErrorIs(t, err, ErrLimitExceeded)
+	_, err = ipld.Decode(block, DecodeOptions{MaxCiphertextSize: 5}.Decode)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+
+	// Other strings are not limited by MaxCiphertextSize
+	block = append(append([]byte{0xa1, 0x62}, "iv"...), 0x43, 'a', 'b', 'c')
+	_, err = ipld.Decode(block, DecodeOptions{MaxCiphertextSize: 1}.Decode)
+	require.NotErrorIs(t, err, ErrLimitExceeded)
+}
@@ -0,0 +1,119 @@
+package dagjose
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Codec is the multicodec code for dag-jose. See the multicodecs table: https://github.com/multiformats/multicodec/
+const Codec = 0x85
+
+// LinkPrototype builds CIDv1 links to dag-jose objects using the sha2-256 multihash. It can be passed to
+// ipld.LinkSystem.Store or ipld.LinkSystem.ComputeLink by users working with the underlying LinkSystem directly.
+var LinkPrototype = NewLinkPrototype(multihash.SHA2_256, -1)
+
+// NewLinkPrototype returns a link prototype which builds CIDv1 links to dag-jose objects using the given multihash
+// type. A length of -1 selects the default digest length for the hash function.
+func NewLinkPrototype(mhType uint64, mhLength int) cidlink.LinkPrototype {
+	return cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    Codec,
+		MhType:   mhType,
+		MhLength: mhLength,
+	}}
+}
+
+// StoreOptions can be used to customize the links built when storing a dag-jose object.
+type StoreOptions struct {
+	// The multihash type used to build the link. If zero, sha2-256 is used.
+	MhType uint64
+	// The multihash digest length. If zero, the default digest length for the hash function is used.
+	MhLength int
+}
+
+// StoreJOSE passes the configured dag-jose link prototype and the given dag-jose object to ipld.LinkSystem.Store.
+func (cfg StoreOptions) StoreJOSE(linkContext ipld.LinkContext, jose datamodel.Node, linkSystem ipld.LinkSystem) (ipld.Link, error) {
+	return linkSystem.Store(linkContext, cfg.linkPrototype(), jose)
+}
+
+func (cfg StoreOptions) linkPrototype() cidlink.LinkPrototype {
+	if cfg.MhType == 0 {
+		cfg.MhType = multihash.SHA2_256
+	}
+	if cfg.MhLength == 0 {
+		cfg.MhLength = -1
+	}
+	return NewLinkPrototype(cfg.MhType, cfg.MhLength)
+}
+
+// StoreJOSE is a convenience function that passes the default dag-jose link prototype and the given dag-jose object to
+// ipld.LinkSystem.Store.
+func StoreJOSE(linkContext ipld.LinkContext, jose datamodel.Node, linkSystem ipld.LinkSystem) (ipld.Link, error) {
+	return StoreOptions{}.StoreJOSE(linkContext, jose, linkSystem)
+}
+
+// LoadJOSE loads the dag-jose object at the given link and returns the representation node of either a DecodedJWE or
+// a DecodedJWS, whichever the block contains.
+func LoadJOSE(lnk ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) (datamodel.Node, error) {
+	if block, err := loadJOSEBlock(lnk, linkContext, linkSystem); err != nil {
+		return nil, err
+	} else if jwe, err := decodeJWEBlock(block); err == nil {
+		return jwe.Representation(), nil
+	} else if jws, err := decodeJWSBlock(block); err != nil {
+		return nil, err
+	} else {
+		return jws.Representation(), nil
+	}
+}
+
+// LoadJWE loads the dag-jose object at the given link and returns it as a DecodedJWE. An error is returned if the
+// block does not contain a JWE.
+func LoadJWE(lnk ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) (DecodedJWE, error) {
+	if block, err := loadJOSEBlock(lnk, linkContext, linkSystem); err != nil {
+		return nil, err
+	} else {
+		return decodeJWEBlock(block)
+	}
+}
+
+// LoadJWS loads the dag-jose object at the given link and returns it as a DecodedJWS, including the `link` field
+// corresponding to its `payload`. An error is returned if the block does not contain a JWS.
+func LoadJWS(lnk ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) (DecodedJWS, error) {
+	if block, err := loadJOSEBlock(lnk, linkContext, linkSystem); err != nil {
+		return nil, err
+	} else {
+		return decodeJWSBlock(block)
+	}
+}
+
+// loadJOSEBlock reads the raw bytes of a dag-jose block from storage. The block's hash is verified by the LinkSystem.
+func loadJOSEBlock(lnk ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) ([]byte, error) {
+	if cl, castOk := lnk.(cidlink.Link); !castOk {
+		return nil, fmt.Errorf("unsupported link type: %T", lnk)
+	} else if codec := cl.Cid.Prefix().Codec; codec != Codec {
+		return nil, fmt.Errorf("link is not to a dag-jose object: codec 0x%x", codec)
+	}
+	return linkSystem.LoadRaw(linkContext, lnk)
+}
+
+func decodeJWEBlock(block []byte) (DecodedJWE, error) {
+	jweBuilder := Type.DecodedJWE__Repr.NewBuilder()
+	if err := (DecodeOptions{}).DecodeJWE(jweBuilder, bytes.NewReader(block)); err != nil {
+		return nil, err
+	}
+	return jweBuilder.Build().(DecodedJWE), nil
+}
+
+func decodeJWSBlock(block []byte) (DecodedJWS, error) {
+	jwsBuilder := Type.DecodedJWS__Repr.NewBuilder()
+	if err := (DecodeOptions{AddLink: true}).DecodeJWS(jwsBuilder, bytes.NewReader(block)); err != nil {
+		return nil, err
+	}
+	return jwsBuilder.Build().(DecodedJWS), nil
+}
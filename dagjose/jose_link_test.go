@@ -0,0 +1,75 @@
+package dagjose
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"pgregory.net/rapid"
+)
+
+func memoryLinkSystem() ipld.LinkSystem {
+	store := cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = store.OpenRead
+	ls.StorageWriteOpener = store.OpenWrite
+	return ls
+}
+
+// Loading a JOSE object with the typed loaders must return the matching typed node, and fail for the other type
+func TestLoadTypedJOSE(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		ls := memoryLinkSystem()
+		jws := jwsGen(-1).Draw(t, "an arbitrary JWS").(datamodel.Node)
+		jwsLink, err := StoreJOSE(ipld.LinkContext{}, jws, ls)
+		require.NoError(t, err)
+		jwe := jweGen(-1).Draw(t, "an arbitrary JWE").(datamodel.Node)
+		jweLink, err := StoreJOSE(ipld.LinkContext{}, jwe, ls)
+		require.NoError(t, err)
+
+		decodedJWS, err := LoadJWS(jwsLink, ipld.LinkContext{}, ls)
+		require.NoError(t, err)
+		require.True(t, decodedJWS.FieldLink().Exists())
+		compareJOSE(t, jws, decodedJWS.Representation())
+		_, err = LoadJWE(jwsLink, ipld.LinkContext{}, ls)
+		require.Error(t, err)
+
+		decodedJWE, err := LoadJWE(jweLink, ipld.LinkContext{}, ls)
+		require.NoError(t, err)
+		compareJOSE(t, jwe, decodedJWE.Representation())
+		_, err = LoadJWS(jweLink, ipld.LinkContext{}, ls)
+		require.Error(t, err)
+	})
+}
+
+// Links built with custom store options must use the configured multihash and still be loadable
+func TestStoreJOSEWithCustomMultihash(t *testing.T) {
+	ls := memoryLinkSystem()
+	jws := &_EncodedJWS__Repr{payload: _Raw{createCid([]byte("payload")).Bytes()}}
+	link, err := StoreOptions{MhType: multihash.SHA2_512}.StoreJOSE(ipld.LinkContext{}, jws, ls)
+	require.NoError(t, err)
+	prefix := link.(cidlink.Link).Cid.Prefix()
+	require.Equal(t, uint64(Codec), prefix.Codec)
+	require.Equal(t, uint64(multihash.SHA2_512), prefix.MhType)
+	require.Equal(t, 64, prefix.MhLength)
+	_, err = LoadJWS(link, ipld.LinkContext{}, ls)
+	require.NoError(t, err)
+}
+
+// Loading a link that does not use the dag-jose codec should fail before reading any data
+func TestLoadJOSEWithWrongCodec(t *testing.T) {
+	ls := memoryLinkSystem()
+	link, err := ls.Store(
+		ipld.LinkContext{},
+		cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: 0x71, MhType: multihash.SHA2_256, MhLength: -1}},
+		basicnode.NewString("not a JOSE object"),
+	)
+	require.NoError(t, err)
+	_, err = LoadJOSE(link, ipld.LinkContext{}, ls)
+	require.ErrorContains(t, err, "not to a dag-jose object")
+}
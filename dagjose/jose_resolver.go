@@ -0,0 +1,154 @@
+package dagjose
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	gojose "github.com/go-jose/go-jose/v4"
+
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-varint"
+)
+
+// Multicodec codes of the public key types supported in did:key identifiers. See the multicodecs table:
+// https://github.com/multiformats/multicodec/
+const (
+	multicodecEd25519Pub   = 0xed
+	multicodecSecp256k1Pub = 0xe7
+	multicodecP256Pub      = 0x1200
+)
+
+// KeyResolver resolves the keys identified by a `kid` header parameter, e.g. a DID URL.
+type KeyResolver interface {
+	// ResolveKey returns the candidate keys for the given key ID. An unknown key ID is not an error and results in no
+	// keys being returned.
+	ResolveKey(kid string) ([]interface{}, error)
+}
+
+// KeyResolverFunc allows an ordinary function to be used as a KeyResolver.
+type KeyResolverFunc func(kid string) ([]interface{}, error)
+
+// ResolveKey calls f(kid).
+func (f KeyResolverFunc) ResolveKey(kid string) ([]interface{}, error) {
+	return f(kid)
+}
+
+// KeyResolvers combines several resolvers into one, returning the keys resolved by each of them in order.
+type KeyResolvers []KeyResolver
+
+// ResolveKey returns the keys resolved by each resolver. Resolution stops at the first error.
+func (resolvers KeyResolvers) ResolveKey(kid string) ([]interface{}, error) {
+	var keys []interface{}
+	for _, resolver := range resolvers {
+		if resolved, err := resolver.ResolveKey(kid); err != nil {
+			return nil, err
+		} else {
+			keys = append(keys, resolved...)
+		}
+	}
+	return keys, nil
+}
+
+// JWKSetResolver resolves keys from a static JWK set by key ID.
+type JWKSetResolver struct {
+	KeySet *gojose.JSONWebKeySet
+}
+
+// ResolveKey returns the keys of the set with the given key ID.
+func (r JWKSetResolver) ResolveKey(kid string) ([]interface{}, error) {
+	var keys []interface{}
+	if r.KeySet != nil {
+		for _, jwk := range r.KeySet.Key(kid) {
+			keys = append(keys, jwk)
+		}
+	}
+	return keys, nil
+}
+
+// DIDKeyResolver resolves did:key identifiers and DID URLs (https://w3c-ccg.github.io/did-method-key/) for ed25519,
+// secp256k1 and P-256 public keys. Key IDs using other DID methods resolve to no keys.
+type DIDKeyResolver struct{}
+
+// ResolveKey returns the public key encoded in a did:key identifier or DID URL.
+func (DIDKeyResolver) ResolveKey(kid string) ([]interface{}, error) {
+	if !strings.HasPrefix(kid, "did:key:") {
+		return nil, nil
+	}
+	if key, err := ParseDIDKey(kid); err != nil {
+		return nil, err
+	} else {
+		return []interface{}{key}, nil
+	}
+}
+
+// ParseDIDKey returns the public key encoded in a did:key identifier or DID URL. The returned key is an
+// ed25519.PublicKey, a *secp256k1.PublicKey or an *ecdsa.PublicKey on the P-256 curve.
+func ParseDIDKey(did string) (interface{}, error) {
+	if fragment := strings.IndexByte(did, '#'); fragment >= 0 {
+		did = did[:fragment]
+	}
+	if !strings.HasPrefix(did, "did:key:") {
+		return nil, fmt.Errorf("not a did:key identifier: %s", did)
+	}
+	encoding, multicodecKey, err := multibase.Decode(strings.TrimPrefix(did, "did:key:"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid did:key identifier: %v", err)
+	} else if encoding != multibase.Base58BTC {
+		return nil, errors.New("invalid did:key identifier: must be base58btc-encoded")
+	}
+	code, n, err := varint.FromUvarint(multicodecKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid did:key identifier: %v", err)
+	}
+	keyBytes := multicodecKey[n:]
+	switch code {
+	case multicodecEd25519Pub:
+		if len(keyBytes) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid did:key identifier: bad ed25519 public key length")
+		}
+		return ed25519.PublicKey(keyBytes), nil
+	case multicodecSecp256k1Pub:
+		if key, err := secp256k1.ParsePubKey(keyBytes); err != nil {
+			return nil, fmt.Errorf("invalid did:key identifier: %v", err)
+		} else {
+			return key, nil
+		}
+	case multicodecP256Pub:
+		if x, y := elliptic.UnmarshalCompressed(elliptic.P256(), keyBytes); x == nil {
+			return nil, errors.New("invalid did:key identifier: bad P-256 public key")
+		} else {
+			return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid did:key identifier: unsupported key type 0x%x", code)
+}
+
+// DIDKey returns the did:key identifier for an ed25519, secp256k1 or P-256 public key. Private keys are also
+// accepted, in which case the identifier of the corresponding public key is returned.
+func DIDKey(key interface{}) (string, error) {
+	var code uint64
+	var keyBytes []byte
+	switch publicKey := publicKeyOf(key).(type) {
+	case ed25519.PublicKey:
+		code, keyBytes = multicodecEd25519Pub, publicKey
+	case *secp256k1.PublicKey:
+		code, keyBytes = multicodecSecp256k1Pub, publicKey.SerializeCompressed()
+	case *ecdsa.PublicKey:
+		if publicKey.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported elliptic curve: %s", publicKey.Curve.Params().Name)
+		}
+		code, keyBytes = multicodecP256Pub, elliptic.MarshalCompressed(publicKey.Curve, publicKey.X, publicKey.Y)
+	default:
+		return "", fmt.Errorf("unsupported did:key key type: %T", key)
+	}
+	if encoded, err := multibase.Encode(multibase.Base58BTC, append(varint.ToUvarint(code), keyBytes...)); err != nil {
+		return "", err
+	} else {
+		return "did:key:" + encoded, nil
+	}
+}
@@ -0,0 +1,149 @@
+package dagjose
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestDIDKeyRoundTrip(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	k1Key, err := secp256k1.GeneratePrivateKey()
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	// did:key identifiers for each key type have a well-known prefix
+	for prefix, key := range map[string]interface{}{
+		"did:key:z6Mk": edKey,
+		"did:key:zQ3s": k1Key,
+		"did:key:zDn":  p256Key,
+	} {
+		did, err := DIDKey(key)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(did, prefix), did)
+		parsed, err := ParseDIDKey(did + "#" + strings.TrimPrefix(did, "did:key:"))
+		require.NoError(t, err)
+		require.Equal(t, publicKeyOf(key), parsed)
+	}
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, err = DIDKey(p384Key)
+	require.ErrorContains(t, err, "unsupported elliptic curve")
+}
+
+func TestParseDIDKeyErrors(t *testing.T) {
+	encode := func(code uint64, keyBytes []byte) string {
+		encoded, err := multibase.Encode(multibase.Base58BTC, append(varint.ToUvarint(code), keyBytes...))
+		require.NoError(t, err)
+		return "did:key:" + encoded
+	}
+	scenarios := map[string]string{
+		"not a did:key":                 "did:web:example.com",
+		"must be base58btc":             "did:key:mAQID",
+		"unsupported key type 0xec":     encode(0xec, make([]byte, 32)),
+		"bad ed25519 public key length": encode(multicodecEd25519Pub, make([]byte, 31)),
+		"bad P-256 public key":          encode(multicodecP256Pub, make([]byte, 33)),
+		"invalid public key":            encode(multicodecSecp256k1Pub, make([]byte, 33)),
+	}
+	for expected, did := range scenarios {
+		_, err := ParseDIDKey(did)
+		require.ErrorContains(t, err, expected, did)
+	}
+}
+
+// Signatures with a did:key `kid` can be verified without supplying any keys
+func TestVerifyJWSWithDIDKeyResolver(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	k1Key, err := secp256k1.GeneratePrivateKey()
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	var signingKeys []SigningKey
+	for _, key := range []interface{}{edKey, k1Key, p256Key} {
+		did, err := DIDKey(key)
+		require.NoError(t, err)
+		signingKeys = append(signingKeys, SigningKey{Key: key, KeyID: did + "#" + strings.TrimPrefix(did, "did:key:")})
+	}
+	jws, err := SignJWS(createCid([]byte("payload")), signingKeys...)
+	require.NoError(t, err)
+
+	results, err := VerifyOptions{Resolver: DIDKeyResolver{}}.Verify(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	for idx, result := range results {
+		require.True(t, result.Valid(), result.Err)
+		require.Equal(t, publicKeyOf(signingKeys[idx].Key), result.Key)
+	}
+}
+
+func TestVerifyJWSWithKeyResolvers(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jws, err := SignJWS(
+		createCid([]byte("payload")),
+		SigningKey{Key: edKey, KeyID: "jwk"},
+		SigningKey{Key: p256Key, KeyID: "failing"},
+		SigningKey{Key: p256Key, KeyID: "unknown"},
+	)
+	require.NoError(t, err)
+	resolver := KeyResolvers{
+		JWKSetResolver{KeySet: &gojose.JSONWebKeySet{Keys: []gojose.JSONWebKey{{Key: edKey.Public(), KeyID: "jwk"}}}},
+		DIDKeyResolver{},
+		KeyResolverFunc(func(kid string) ([]interface{}, error) {
+			if kid == "failing" {
+				return nil, errors.New("resolver unavailable")
+			}
+			return nil, nil
+		}),
+	}
+
+	results, err := VerifyOptions{Resolver: resolver}.Verify(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.True(t, results[0].Valid())
+	require.ErrorContains(t, results[1].Err, "resolving key failing: resolver unavailable")
+	require.ErrorIs(t, results[2].Err, ErrNoVerificationKey)
+}
+
+// Keys selected by `kid` are used exclusively, and the static keys only when the `kid` selects no keys
+func TestVerifyJWSWithResolvedKeysOnly(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	jws, err := SignJWS(
+		createCid([]byte("payload")),
+		SigningKey{Key: edKey, KeyID: "other"},
+		SigningKey{Key: edKey, KeyID: "unknown"},
+		SigningKey{Key: edKey},
+	)
+	require.NoError(t, err)
+	resolver := KeyResolverFunc(func(kid string) ([]interface{}, error) {
+		if kid == "other" {
+			return []interface{}{otherKey.Public()}, nil
+		}
+		return nil, nil
+	})
+
+	results, err := VerifyOptions{Keys: []interface{}{edKey.Public()}, Resolver: resolver}.Verify(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrInvalidSignature)
+	require.True(t, results[1].Valid(), results[1].Err)
+	require.True(t, results[2].Valid(), results[2].Err)
+
+	// The same applies to keys selected from a JWK set
+	keySet := &gojose.JSONWebKeySet{Keys: []gojose.JSONWebKey{{Key: otherKey.Public(), KeyID: "other"}}}
+	results, err = VerifyOptions{Keys: []interface{}{edKey.Public()}, KeySet: keySet}.Verify(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrInvalidSignature)
+	require.True(t, results[1].Valid(), results[1].Err)
+}
@@ -0,0 +1,28 @@
+package dagjose
+
+import (
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+// ExploreJWSPayload returns a selector spec that explores the payload of a JWS with the given selector. It explores the
+// `link` field, which is only present when the JWS is decoded with DecodeOptions.AddLink, as by the decoder registered
+// for the dag-jose multicodec and thus by LinkSystems using the default decoder chooser. The payload block is loaded by
+// the traversal like any other link, and JWS with a detached payload are not explored.
+//
+// Wrapping the spec with ExploreRecursive walks chains of signed commits, e.g. with `prev` fields linking to previous
+// JWS:
+//
+//	ssb.ExploreRecursive(selector.RecursionLimitNone(), dagjose.ExploreJWSPayload(ssb.ExploreUnion(
+//		ssb.Matcher(),
+//		ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+//			efsb.Insert("prev", ssb.ExploreRecursiveEdge())
+//		}),
+//	)))
+func ExploreJWSPayload(next builder.SelectorSpec) builder.SelectorSpec {
+	return builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).ExploreFields(
+		func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("link", next)
+		},
+	)
+}
@@ -0,0 +1,91 @@
+package dagjose
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func traversalConfig(ls ipld.LinkSystem) *traversal.Config {
+	return &traversal.Config{
+		LinkSystem: ls,
+		LinkTargetNodePrototypeChooser: func(ipld.Link, ipld.LinkContext) (datamodel.NodePrototype, error) {
+			return basicnode.Prototype.Any, nil
+		},
+	}
+}
+
+// Walking a chain of signed commits must match the payload of every commit, following each JWS into its payload and
+// each payload into the previous JWS
+func TestExploreJWSPayloadChain(t *testing.T) {
+	ls := memoryLinkSystem()
+	var head ipld.Link
+	for idx := int64(0); idx < 3; idx++ {
+		fields := map[string]datamodel.Node{"data": basicnode.NewInt(idx)}
+		if head != nil {
+			fields["prev"] = basicnode.NewLink(head)
+		}
+		_, head = storeSignedCommit(t, ls, fields)
+	}
+
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	sel, err := ssb.ExploreRecursive(selector.RecursionLimitNone(), ExploreJWSPayload(ssb.ExploreUnion(
+		ssb.Matcher(),
+		ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("prev", ssb.ExploreRecursiveEdge())
+		}),
+	))).Selector()
+	require.NoError(t, err)
+
+	root, err := ls.Load(ipld.LinkContext{}, head, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	var paths []string
+	var data []int64
+	err = traversal.Progress{Cfg: traversalConfig(ls)}.WalkMatching(root, sel, func(progress traversal.Progress, n datamodel.Node) error {
+		paths = append(paths, progress.Path.String())
+		value, err := n.LookupByString("data")
+		if err != nil {
+			return err
+		}
+		idx, err := value.AsInt()
+		data = append(data, idx)
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 1, 0}, data)
+	require.Equal(t, []string{"link", "link/prev/link", "link/prev/link/prev/link"}, paths)
+}
+
+// Only the payload of the JWS must be matched, and not its signatures
+func TestExploreJWSPayloadMatcher(t *testing.T) {
+	ls := memoryLinkSystem()
+	payloadLink, jwsLink := storeSignedCommit(t, ls, map[string]datamodel.Node{"data": basicnode.NewString("payload")})
+	sel, err := ExploreJWSPayload(builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher()).Selector()
+	require.NoError(t, err)
+
+	var visited []ipld.Link
+	progress := traversal.Progress{Cfg: traversalConfig(ls)}
+	progress.Cfg.LinkSystem.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		visited = append(visited, l)
+		return ls.StorageReadOpener(lc, l)
+	}
+	root, err := ls.Load(ipld.LinkContext{}, jwsLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	var matched []datamodel.Node
+	require.NoError(t, progress.WalkMatching(root, sel, func(_ traversal.Progress, n datamodel.Node) error {
+		matched = append(matched, n)
+		return nil
+	}))
+	require.Len(t, matched, 1)
+	require.Equal(t, []ipld.Link{payloadLink}, visited)
+	payload, err := ls.Load(ipld.LinkContext{}, payloadLink, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	require.True(t, datamodel.DeepEqual(payload, matched[0]))
+}
@@ -0,0 +1,339 @@
+package dagjose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secp256k1ecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/json"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// JWS signature algorithms supported when signing and verifying dag-jose objects. See RFC 7518 §3.1 and RFC 8812 §3.2.
+const (
+	EdDSA  = "EdDSA"
+	ES256  = "ES256"
+	ES256K = "ES256K"
+	ES384  = "ES384"
+	ES512  = "ES512"
+	RS256  = "RS256"
+	RS384  = "RS384"
+	RS512  = "RS512"
+	PS256  = "PS256"
+	PS384  = "PS384"
+	PS512  = "PS512"
+)
+
+// SigningKey describes a single signature to be added to a JWS.
+type SigningKey struct {
+	// Key used to produce the signature. The following are supported:
+	//   - ed25519.PrivateKey, *ecdsa.PrivateKey (P-256, P-384, P-521) and *rsa.PrivateKey
+	//   - *secp256k1.PrivateKey from github.com/decred/dcrd/dcrec/secp256k1/v4
+	//   - any crypto.Signer with an ed25519, ECDSA or RSA public key, e.g. a key held in an HSM or KMS
+	//   - gojose.JSONWebKey (or a pointer to one) holding one of the above
+	//   - gojose.Signer, in which case the signatures it produces are used as-is and the remaining SigningKey fields
+	//     are ignored since the go-jose signer controls its own headers
+	Key interface{}
+	// Algorithm is the `alg` protected header parameter. If empty, it is inferred from the key: EdDSA for ed25519,
+	// ES256/ES384/ES512 for ECDSA depending on the curve, ES256K for secp256k1 and RS256 for RSA.
+	Algorithm string
+	// KeyID is set as the `kid` protected header parameter, if not empty. If the key is a gojose.JSONWebKey, its key
+	// ID is used by default.
+	KeyID string
+	// ExtraHeaders are additional protected header parameters, e.g. custom claims. They must not include `alg`, `kid`
+	// or `b64`.
+	ExtraHeaders map[string]interface{}
+	// UnencodedPayload signs the raw CID bytes instead of their base64url encoding (RFC 7797). The `b64` protected
+	// header parameter is set to false and listed in `crit`. Such signatures cannot be verified from the JSON or compact
+	// serializations of the JWS, which always carry the base64url-encoded payload.
+	UnencodedPayload bool
+	// Header is the unprotected header for this signature, if not empty.
+	Header map[string]interface{}
+}
+
+// SignJWS signs the given CID with each of the given keys and returns a JWS, ready for EncodeJWS or Encode, with one
+// signature per key.
+func SignJWS(payload cid.Cid, keys ...SigningKey) (EncodedJWS, error) {
+	if !payload.Defined() {
+		return nil, errors.New("payload is not a valid CID")
+	} else if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	var signatures []datamodel.Node
+	for idx, key := range keys {
+		if keySignatures, err := key.sign(payload); err != nil {
+			return nil, fmt.Errorf("signature %d: %w", idx, err)
+		} else {
+			signatures = append(signatures, keySignatures...)
+		}
+	}
+	jws, err := fluent.BuildMap(Type.EncodedJWS__Repr, 2, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("payload").AssignBytes(payload.Bytes())
+		ma.AssembleEntry("signatures").CreateList(int64(len(signatures)), func(la fluent.ListAssembler) {
+			for _, signature := range signatures {
+				la.AssembleValue().AssignNode(signature)
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jws.(EncodedJWS), nil
+}
+
+// SignLink is the same as SignJWS but accepts the payload as a datamodel.Link, which must be a CID link.
+func SignLink(payload datamodel.Link, keys ...SigningKey) (EncodedJWS, error) {
+	if cl, castOk := payload.(cidlink.Link); !castOk {
+		return nil, fmt.Errorf("unsupported link type: %T", payload)
+	} else {
+		return SignJWS(cl.Cid, keys...)
+	}
+}
+
+// sign returns the signature nodes produced for the given payload by this key. Only a gojose.Signer can produce more
+// than one signature.
+func (sk SigningKey) sign(payload cid.Cid) ([]datamodel.Node, error) {
+	if signer, castOk := sk.Key.(gojose.Signer); castOk {
+		return signWithGoJOSE(signer, payload)
+	}
+	key, keyID := unwrapJSONWebKey(sk.Key)
+	if len(sk.KeyID) > 0 {
+		keyID = sk.KeyID
+	}
+	alg := sk.Algorithm
+	if len(alg) == 0 {
+		var err error
+		if alg, err = inferSignatureAlgorithm(key); err != nil {
+			return nil, err
+		}
+	}
+	protectedHeader := make(map[string]interface{}, len(sk.ExtraHeaders)+2)
+	for name, value := range sk.ExtraHeaders {
+		if (name == "alg") || (name == "kid") || (name == "b64") {
+			return nil, fmt.Errorf("`%s` must not be set through extra headers", name)
+		}
+		protectedHeader[name] = value
+	}
+	protectedHeader["alg"] = alg
+	if len(keyID) > 0 {
+		protectedHeader["kid"] = keyID
+	}
+	if sk.UnencodedPayload {
+		// Keep any critical header parameters set through the extra headers
+		critical := &Header{}
+		if value, exists := protectedHeader["crit"]; exists {
+			var err error
+			if critical, err = headerFromMap(map[string]interface{}{"crit": value}); err != nil {
+				return nil, err
+			}
+		}
+		protectedHeader["b64"] = false
+		protectedHeader["crit"] = appendCritical(critical.Critical, "b64")
+	}
+	protected, err := json.Marshal(protectedHeader)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signPayload(alg, key, signingInput(protected, payload.Bytes(), !sk.UnencodedPayload))
+	if err != nil {
+		return nil, err
+	}
+	var header datamodel.Node
+	if len(sk.Header) > 0 {
+		if header, err = goPrimitiveToIpldNode(sk.Header); err != nil {
+			return nil, err
+		}
+	}
+	signatureNode, err := fluent.BuildMap(Type.EncodedSignature__Repr, 3, func(ma fluent.MapAssembler) {
+		if header != nil {
+			ma.AssembleEntry("header").AssignNode(header)
+		}
+		ma.AssembleEntry("protected").AssignBytes(protected)
+		ma.AssembleEntry("signature").AssignBytes(signature)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []datamodel.Node{signatureNode.(EncodedSignature).Representation()}, nil
+}
+
+// signWithGoJOSE lets a go-jose signer sign the payload and returns the resulting signature nodes.
+func signWithGoJOSE(signer gojose.Signer, payload cid.Cid) ([]datamodel.Node, error) {
+	if signed, err := signer.Sign(payload.Bytes()); err != nil {
+		return nil, err
+	} else if jws, err := ParseJSON([]byte(signed.FullSerialize())); err != nil {
+		return nil, err
+	} else if signatures, err := jws.LookupByString("signatures"); err != nil {
+		return nil, err
+	} else {
+		var signatureNodes []datamodel.Node
+		for itr := signatures.ListIterator(); !itr.Done(); {
+			if _, signature, err := itr.Next(); err != nil {
+				return nil, err
+			} else {
+				signatureNodes = append(signatureNodes, signature)
+			}
+		}
+		return signatureNodes, nil
+	}
+}
+
+// appendCritical adds a header parameter to a `crit` list, unless it is already listed.
+func appendCritical(critical []string, name string) []string {
+	for _, listed := range critical {
+		if listed == name {
+			return critical
+		}
+	}
+	return append(critical, name)
+}
+
+// signingInput returns the JWS signing input (RFC 7515 §5.1) for the given protected header and payload. The payload is
+// used as-is instead of base64url-encoded if the `b64` header parameter is false (RFC 7797 §3).
+func signingInput(protected []byte, payload []byte, encoded bool) []byte {
+	input := []byte(encodeBase64Url(protected) + ".")
+	if encoded {
+		return append(input, encodeBase64Url(payload)...)
+	}
+	return append(input, payload...)
+}
+
+// unwrapJSONWebKey returns the key held by a gojose.JSONWebKey along with its key ID, or the passed key otherwise.
+func unwrapJSONWebKey(key interface{}) (interface{}, string) {
+	switch jwk := key.(type) {
+	case gojose.JSONWebKey:
+		return jwk.Key, jwk.KeyID
+	case *gojose.JSONWebKey:
+		return jwk.Key, jwk.KeyID
+	}
+	return key, ""
+}
+
+// inferSignatureAlgorithm returns the default signature algorithm for a private key.
+func inferSignatureAlgorithm(key interface{}) (string, error) {
+	if _, castOk := key.(*secp256k1.PrivateKey); castOk {
+		return ES256K, nil
+	} else if signer, castOk := key.(crypto.Signer); castOk {
+		switch publicKey := signer.Public().(type) {
+		case ed25519.PublicKey:
+			return EdDSA, nil
+		case *ecdsa.PublicKey:
+			switch publicKey.Curve {
+			case elliptic.P256():
+				return ES256, nil
+			case elliptic.P384():
+				return ES384, nil
+			case elliptic.P521():
+				return ES512, nil
+			}
+			return "", fmt.Errorf("unsupported elliptic curve: %s", publicKey.Curve.Params().Name)
+		case *rsa.PublicKey:
+			return RS256, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported signing key type: %T", key)
+}
+
+// signatureHash returns the hash function used by a signature algorithm. EdDSA does not pre-hash the signing input.
+func signatureHash(alg string) (crypto.Hash, error) {
+	switch alg {
+	case EdDSA:
+		return 0, nil
+	case ES256, ES256K, RS256, PS256:
+		return crypto.SHA256, nil
+	case ES384, RS384, PS384:
+		return crypto.SHA384, nil
+	case ES512, RS512, PS512:
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported signature algorithm: %s", alg)
+}
+
+// signPayload produces a JWS signature over the signing input using the given algorithm and private key.
+func signPayload(alg string, key interface{}, signingInput []byte) ([]byte, error) {
+	hash, err := signatureHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	digest := signingInput
+	if hash != 0 {
+		hasher := hash.New()
+		hasher.Write(signingInput)
+		digest = hasher.Sum(nil)
+	}
+	if privateKey, castOk := key.(*secp256k1.PrivateKey); castOk {
+		if alg != ES256K {
+			return nil, fmt.Errorf("algorithm %s cannot be used with a secp256k1 key", alg)
+		}
+		signature := secp256k1ecdsa.Sign(privateKey, digest)
+		r, s := signature.R(), signature.S()
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		return append(rBytes[:], sBytes[:]...), nil
+	}
+	signer, castOk := key.(crypto.Signer)
+	if !castOk {
+		return nil, fmt.Errorf("unsupported signing key type: %T", key)
+	}
+	switch publicKey := signer.Public().(type) {
+	case ed25519.PublicKey:
+		if alg != EdDSA {
+			return nil, fmt.Errorf("algorithm %s cannot be used with an ed25519 key", alg)
+		}
+		return signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	case *ecdsa.PublicKey:
+		if curve, err := ecdsaCurve(alg); err != nil {
+			return nil, err
+		} else if curve != publicKey.Curve {
+			return nil, fmt.Errorf("algorithm %s cannot be used with a %s key", alg, publicKey.Curve.Params().Name)
+		} else if asn1Signature, err := signer.Sign(rand.Reader, digest, hash); err != nil {
+			return nil, err
+		} else {
+			// crypto.Signer produces ASN.1 DER signatures but JWS uses the fixed-size concatenation of R and S.
+			var rs struct{ R, S *big.Int }
+			if _, err := asn1.Unmarshal(asn1Signature, &rs); err != nil {
+				return nil, err
+			}
+			size := (curve.Params().BitSize + 7) / 8
+			signature := make([]byte, 2*size)
+			rs.R.FillBytes(signature[:size])
+			rs.S.FillBytes(signature[size:])
+			return signature, nil
+		}
+	case *rsa.PublicKey:
+		switch alg {
+		case RS256, RS384, RS512:
+			return signer.Sign(rand.Reader, digest, hash)
+		case PS256, PS384, PS512:
+			return signer.Sign(rand.Reader, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash})
+		}
+		return nil, fmt.Errorf("algorithm %s cannot be used with an RSA key", alg)
+	}
+	return nil, fmt.Errorf("unsupported signing key type: %T", key)
+}
+
+// ecdsaCurve returns the NIST curve used by an ECDSA signature algorithm.
+func ecdsaCurve(alg string) (elliptic.Curve, error) {
+	switch alg {
+	case ES256:
+		return elliptic.P256(), nil
+	case ES384:
+		return elliptic.P384(), nil
+	case ES512:
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("algorithm %s cannot be used with an ECDSA key", alg)
+}
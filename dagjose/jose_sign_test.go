@@ -0,0 +1,149 @@
+package dagjose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secp256k1ecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"pgregory.net/rapid"
+)
+
+// opaqueSigner hides the concrete private key type behind crypto.Signer, like an HSM or KMS key would
+type opaqueSigner struct {
+	signer crypto.Signer
+}
+
+func (s opaqueSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.signer.Sign(rand, digest, opts)
+}
+
+// Verify a single signature of a general JSON JWS with go-jose
+func requireGoJOSEVerifies(t require.TestingT, general []byte, idx int, publicKey interface{}) {
+	parsed, err := gojose.ParseSigned(string(general), []gojose.SignatureAlgorithm{
+		gojose.EdDSA, gojose.ES256, gojose.ES384, gojose.ES512, gojose.RS256, gojose.PS384, "ES256K",
+	})
+	require.NoError(t, err)
+	parsed.Signatures = parsed.Signatures[idx : idx+1]
+	_, err = parsed.Verify(publicKey)
+	require.NoError(t, err)
+}
+
+func TestSignJWS(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		link := cidGen().Draw(t, "JWS payload").(cid.Cid)
+		edKey := ed25519PrivateKeyGen().Draw(t, "ed25519 private key").(ed25519.PrivateKey)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		k1Key, err := secp256k1.GeneratePrivateKey()
+		require.NoError(t, err)
+		goJOSESigner, err := gojose.NewSigner(gojose.SigningKey{Algorithm: gojose.EdDSA, Key: edKey}, nil)
+		require.NoError(t, err)
+
+		jws, err := SignJWS(
+			link,
+			SigningKey{Key: edKey, KeyID: "did:key:z6Mk#z6Mk", ExtraHeaders: map[string]interface{}{"typ": "JWT"}},
+			SigningKey{Key: opaqueSigner{ecKey}, Header: map[string]interface{}{"note": "unprotected"}},
+			SigningKey{Key: gojose.JSONWebKey{Key: k1Key, KeyID: "k1"}},
+			SigningKey{Key: goJOSESigner},
+		)
+		require.NoError(t, err)
+		encoded, err := ipld.Encode(jws, EncodeJWS)
+		require.NoError(t, err)
+		decoded, err := ipld.Decode(encoded, Decode)
+		require.NoError(t, err)
+		general, err := MarshalGeneralJSON(decoded)
+		require.NoError(t, err)
+
+		var generalMap struct {
+			Payload    string
+			Signatures []struct {
+				Protected string
+				Header    map[string]interface{}
+				Signature string
+			}
+		}
+		require.NoError(t, json.Unmarshal(general, &generalMap))
+		require.Equal(t, encodeBase64Url(link.Bytes()), generalMap.Payload)
+		require.Len(t, generalMap.Signatures, 4)
+		expectedProtected := []string{
+			`{"alg":"EdDSA","kid":"did:key:z6Mk#z6Mk","typ":"JWT"}`,
+			`{"alg":"ES256"}`,
+			`{"alg":"ES256K","kid":"k1"}`,
+			`{"alg":"EdDSA"}`,
+		}
+		for idx, signature := range generalMap.Signatures {
+			protected, err := decodeBase64Url(signature.Protected)
+			require.NoError(t, err)
+			require.Equal(t, expectedProtected[idx], string(protected))
+		}
+		require.Equal(t, map[string]interface{}{"note": "unprotected"}, generalMap.Signatures[1].Header)
+
+		requireGoJOSEVerifies(t, general, 0, edKey.Public())
+		requireGoJOSEVerifies(t, general, 1, &ecKey.PublicKey)
+		requireGoJOSEVerifies(t, general, 3, edKey.Public())
+
+		// go-jose does not support ES256K, so check that signature directly
+		k1Signature, err := decodeBase64Url(generalMap.Signatures[2].Signature)
+		require.NoError(t, err)
+		require.Len(t, k1Signature, 64)
+		var r, s secp256k1.ModNScalar
+		r.SetByteSlice(k1Signature[:32])
+		s.SetByteSlice(k1Signature[32:])
+		digest := sha256.Sum256([]byte(generalMap.Signatures[2].Protected + "." + generalMap.Payload))
+		require.True(t, secp256k1ecdsa.NewSignature(&r, &s).Verify(digest[:], k1Key.PubKey()))
+	})
+}
+
+func TestSignJWSWithRSA(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	link := createCid([]byte("payload"))
+	jws, err := SignLink(
+		cidlink.Link{Cid: link},
+		SigningKey{Key: rsaKey},
+		SigningKey{Key: rsaKey, Algorithm: PS384},
+	)
+	require.NoError(t, err)
+	general, err := MarshalGeneralJSON(jws)
+	require.NoError(t, err)
+	requireGoJOSEVerifies(t, general, 0, &rsaKey.PublicKey)
+	requireGoJOSEVerifies(t, general, 1, &rsaKey.PublicKey)
+}
+
+func TestSignJWSErrors(t *testing.T) {
+	link := createCid([]byte("payload"))
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	scenarios := map[string][]SigningKey{
+		"at least one signing key":      {},
+		"cannot be used with a P-384":   {{Key: ecKey, Algorithm: ES256}},
+		"unsupported signature":         {{Key: ecKey, Algorithm: "none"}},
+		"unsupported signing key type":  {{Key: []byte("secret")}},
+		"must not be set through extra": {{Key: ecKey, ExtraHeaders: map[string]interface{}{"alg": "none"}}},
+	}
+	for expected, keys := range scenarios {
+		jws, err := SignJWS(link, keys...)
+		require.ErrorContains(t, err, expected)
+		require.Nil(t, jws)
+	}
+	_, err = SignJWS(cid.Undef, SigningKey{Key: ecKey})
+	require.ErrorContains(t, err, "not a valid CID")
+}
@@ -0,0 +1,260 @@
+package dagjose
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// JWS is a plain Go view of a dag-jose JWS. Byte fields hold raw, un-encoded bytes. Nil fields correspond to absent
+// fields of the dag-jose object.
+type JWS struct {
+	// Payload is cid.Undef for a JWS with a detached payload, which cannot be converted back into a node.
+	Payload    cid.Cid
+	Signatures []Signature
+}
+
+// Signature is a single signature of a JWS.
+type Signature struct {
+	// Protected is the JSON-encoded protected header.
+	Protected []byte
+	// Header is the unprotected header.
+	Header    map[string]interface{}
+	Signature []byte
+}
+
+// JWE is a plain Go view of a dag-jose JWE. Byte fields hold raw, un-encoded bytes. Nil fields correspond to absent
+// fields of the dag-jose object.
+type JWE struct {
+	// Protected is the JSON-encoded protected header.
+	Protected []byte
+	// Unprotected is the shared unprotected header.
+	Unprotected map[string]interface{}
+	IV          []byte
+	AAD         []byte
+	Ciphertext  []byte
+	Tag         []byte
+	Recipients  []Recipient
+}
+
+// Recipient is a single recipient of a JWE.
+type Recipient struct {
+	// Header is the per-recipient unprotected header.
+	Header       map[string]interface{}
+	EncryptedKey []byte
+}
+
+// AsJWS returns a JWS struct for the given node, which may be a DecodedJWS, an EncodedJWS or any node that can be
+// assembled into one, e.g. the node returned by Decode. A JWS with a detached payload, e.g. one decoded with
+// DecodeOptions.DetachedPayload, is returned with an undefined Payload.
+func AsJWS(n datamodel.Node) (*JWS, error) {
+	var signatures DecodedSignatures
+	jws := &JWS{}
+	if decoded, err := asDecodedJWS(n); (err != nil) && isDetached(n) {
+		if detached, err := jwsSignatures(n, Type.DecodedSignatures__Repr); err != nil {
+			return nil, err
+		} else if detached != nil {
+			signatures = detached.(DecodedSignatures)
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		if jws.Payload, err = cid.Cast([]byte(decoded.payload.x)); err != nil {
+			return nil, fmt.Errorf("payload is not a valid CID: %v", err)
+		}
+		if decoded.signatures.Exists() {
+			signatures = &decoded.signatures.v
+		}
+	}
+	if signatures != nil {
+		jws.Signatures = make([]Signature, len(signatures.x))
+		for idx, signature := range signatures.x {
+			jws.Signatures[idx].Signature = []byte(signature.signature.x)
+			if signature.protected.Exists() {
+				jws.Signatures[idx].Protected = []byte(signature.protected.v.x)
+			}
+			var err error
+			if jws.Signatures[idx].Header, err = headerMap(&signature.header); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return jws, nil
+}
+
+// AsJWE returns a JWE struct for the given node, which may be a DecodedJWE, an EncodedJWE or any node that can be
+// assembled into one, e.g. the node returned by Decode.
+func AsJWE(n datamodel.Node) (*JWE, error) {
+	decoded, err := asDecodedJWE(n)
+	if err != nil {
+		return nil, err
+	}
+	jwe := &JWE{Ciphertext: []byte(decoded.ciphertext.x)}
+	for _, field := range []struct {
+		value *_Base64Url__Maybe
+		dest  *[]byte
+	}{
+		{&decoded.protected, &jwe.Protected},
+		{&decoded.iv, &jwe.IV},
+		{&decoded.aad, &jwe.AAD},
+		{&decoded.tag, &jwe.Tag},
+	} {
+		if field.value.Exists() {
+			*field.dest = []byte(field.value.v.x)
+		}
+	}
+	if jwe.Unprotected, err = headerMap(&decoded.unprotected); err != nil {
+		return nil, err
+	}
+	if decoded.recipients.Exists() {
+		jwe.Recipients = make([]Recipient, len(decoded.recipients.v.x))
+		for idx, recipient := range decoded.recipients.v.x {
+			if recipient.encrypted_key.Exists() {
+				jwe.Recipients[idx].EncryptedKey = []byte(recipient.encrypted_key.v.x)
+			}
+			if jwe.Recipients[idx].Header, err = headerMap(&recipient.header); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return jwe, nil
+}
+
+// Encoded returns the JWS as an EncodedJWS, ready for EncodeJWS or Encode.
+func (jws *JWS) Encoded() (EncodedJWS, error) {
+	if n, err := jws.build(Type.EncodedJWS__Repr, false); err != nil {
+		return nil, err
+	} else {
+		return n.(EncodedJWS), nil
+	}
+}
+
+// Decoded returns the JWS as a DecodedJWS, including the `link` field corresponding to the payload.
+func (jws *JWS) Decoded() (DecodedJWS, error) {
+	if n, err := jws.build(Type.DecodedJWS__Repr, true); err != nil {
+		return nil, err
+	} else {
+		return n.(DecodedJWS), nil
+	}
+}
+
+// Encoded returns the JWE as an EncodedJWE, ready for EncodeJWE or Encode.
+func (jwe *JWE) Encoded() (EncodedJWE, error) {
+	if n, err := jwe.build(Type.EncodedJWE__Repr); err != nil {
+		return nil, err
+	} else {
+		return n.(EncodedJWE), nil
+	}
+}
+
+// Decoded returns the JWE as a DecodedJWE.
+func (jwe *JWE) Decoded() (DecodedJWE, error) {
+	if n, err := jwe.build(Type.DecodedJWE__Repr); err != nil {
+		return nil, err
+	} else {
+		return n.(DecodedJWE), nil
+	}
+}
+
+// build assembles the JWS using the given prototype, which may be that of an encoded or decoded representation since
+// both accept raw bytes.
+func (jws *JWS) build(np datamodel.NodePrototype, addLink bool) (datamodel.Node, error) {
+	if !jws.Payload.Defined() {
+		return nil, errors.New("payload is not a valid CID")
+	}
+	headers := make([]datamodel.Node, len(jws.Signatures))
+	for idx, signature := range jws.Signatures {
+		var err error
+		if headers[idx], err = headerNode(signature.Header); err != nil {
+			return nil, err
+		}
+	}
+	return fluent.BuildMap(np, 3, func(ma fluent.MapAssembler) {
+		if addLink {
+			ma.AssembleEntry("link").AssignLink(cidlink.Link{Cid: jws.Payload})
+		}
+		ma.AssembleEntry("payload").AssignBytes(jws.Payload.Bytes())
+		if jws.Signatures != nil {
+			ma.AssembleEntry("signatures").CreateList(int64(len(jws.Signatures)), func(la fluent.ListAssembler) {
+				for idx, signature := range jws.Signatures {
+					la.AssembleValue().CreateMap(3, func(ma fluent.MapAssembler) {
+						if headers[idx] != nil {
+							ma.AssembleEntry("header").AssignNode(headers[idx])
+						}
+						if signature.Protected != nil {
+							ma.AssembleEntry("protected").AssignBytes(signature.Protected)
+						}
+						ma.AssembleEntry("signature").AssignBytes(signature.Signature)
+					})
+				}
+			})
+		}
+	})
+}
+
+// build assembles the JWE using the given prototype, which may be that of an encoded or decoded representation since
+// both accept raw bytes.
+func (jwe *JWE) build(np datamodel.NodePrototype) (datamodel.Node, error) {
+	unprotected, err := headerNode(jwe.Unprotected)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]datamodel.Node, len(jwe.Recipients))
+	for idx, recipient := range jwe.Recipients {
+		if headers[idx], err = headerNode(recipient.Header); err != nil {
+			return nil, err
+		}
+	}
+	return fluent.BuildMap(np, 7, func(ma fluent.MapAssembler) {
+		if jwe.AAD != nil {
+			ma.AssembleEntry("aad").AssignBytes(jwe.AAD)
+		}
+		ma.AssembleEntry("ciphertext").AssignBytes(jwe.Ciphertext)
+		if jwe.IV != nil {
+			ma.AssembleEntry("iv").AssignBytes(jwe.IV)
+		}
+		if jwe.Protected != nil {
+			ma.AssembleEntry("protected").AssignBytes(jwe.Protected)
+		}
+		if jwe.Recipients != nil {
+			ma.AssembleEntry("recipients").CreateList(int64(len(jwe.Recipients)), func(la fluent.ListAssembler) {
+				for idx, recipient := range jwe.Recipients {
+					la.AssembleValue().CreateMap(2, func(ma fluent.MapAssembler) {
+						if headers[idx] != nil {
+							ma.AssembleEntry("header").AssignNode(headers[idx])
+						}
+						if recipient.EncryptedKey != nil {
+							ma.AssembleEntry("encrypted_key").AssignBytes(recipient.EncryptedKey)
+						}
+					})
+				}
+			})
+		}
+		if jwe.Tag != nil {
+			ma.AssembleEntry("tag").AssignBytes(jwe.Tag)
+		}
+		if unprotected != nil {
+			ma.AssembleEntry("unprotected").AssignNode(unprotected)
+		}
+	})
+}
+
+// headerMap returns an unprotected header as a Go map, or nil if the header is absent.
+func headerMap(header MaybeAny) (map[string]interface{}, error) {
+	if !header.Exists() {
+		return nil, nil
+	}
+	return ipldNodeToGoMap(header.Must().Representation())
+}
+
+// headerNode returns an unprotected header as an IPLD node, or nil if the header is nil.
+func headerNode(header map[string]interface{}) (datamodel.Node, error) {
+	if header == nil {
+		return nil, nil
+	}
+	return goPrimitiveToIpldNode(header)
+}
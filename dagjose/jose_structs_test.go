@@ -0,0 +1,145 @@
+package dagjose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"pgregory.net/rapid"
+)
+
+func TestJWSStruct(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		encodedJWS := jwsGen(-1).Draw(t, "JWS").(*_EncodedJWS__Repr)
+		jws, err := AsJWS(roundTripJWS(t, encodedJWS))
+		require.NoError(t, err)
+		require.Equal(t, encodedJWS.payload.x, jws.Payload.Bytes())
+		require.Len(t, jws.Signatures, len(encodedJWS.signatures.v.x))
+		for idx, signature := range encodedJWS.signatures.v.x {
+			require.Equal(t, signature.signature.x, jws.Signatures[idx].Signature)
+			if signature.protected.Exists() {
+				require.Equal(t, signature.protected.v.x, jws.Signatures[idx].Protected)
+			} else {
+				require.Nil(t, jws.Signatures[idx].Protected)
+			}
+			require.Equal(t, signature.header.Exists(), jws.Signatures[idx].Header != nil)
+		}
+	})
+}
+
+// Converting a JWS to a struct and back does not change its encoding
+func TestJWSStructRoundTrip(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	link := createCid([]byte("payload"))
+	signed, err := SignJWS(
+		link,
+		SigningKey{Key: edKey, Header: map[string]interface{}{"kid": "ed", "nested": map[string]interface{}{"list": []interface{}{"a", "b"}}}},
+		SigningKey{Key: ecKey},
+	)
+	require.NoError(t, err)
+	expected, err := ipld.Encode(signed, Encode)
+	require.NoError(t, err)
+
+	jws, err := AsJWS(signed)
+	require.NoError(t, err)
+	require.Equal(t, link, jws.Payload)
+	require.Equal(t, map[string]interface{}{"kid": "ed", "nested": map[string]interface{}{"list": []interface{}{"a", "b"}}}, jws.Signatures[0].Header)
+	require.Nil(t, jws.Signatures[1].Header)
+	require.Equal(t, `{"alg":"ES256"}`, string(jws.Signatures[1].Protected))
+
+	encoded, err := jws.Encoded()
+	require.NoError(t, err)
+	actual, err := ipld.Encode(encoded, Encode)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	decoded, err := jws.Decoded()
+	require.NoError(t, err)
+	require.True(t, datamodel.DeepEqual(roundTripJWS(t, signed), decoded.Representation()))
+	require.Equal(t, cidlink.Link{Cid: link}, decoded.link.v.x)
+
+	// Header values of every kind survive the conversion
+	header := map[string]interface{}{"bytes": []byte("bytes"), "int": int64(1), "link": link, "null": nil}
+	jws.Signatures[0].Header = header
+	encoded, err = jws.Encoded()
+	require.NoError(t, err)
+	jws, err = AsJWS(roundTripJWS(t, encoded))
+	require.NoError(t, err)
+	require.Equal(t, header, jws.Signatures[0].Header)
+
+	_, err = (&JWS{}).Encoded()
+	require.ErrorContains(t, err, "not a valid CID")
+}
+
+func TestJWEStruct(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		encodedJWE := jweGen(-1).Draw(t, "JWE").(*_EncodedJWE__Repr)
+		jwe, err := AsJWE(roundTripJWE(t, encodedJWE))
+		require.NoError(t, err)
+		require.Equal(t, encodedJWE.ciphertext.x, jwe.Ciphertext)
+		for field, value := range map[*_Raw__Maybe][]byte{
+			&encodedJWE.aad:       jwe.AAD,
+			&encodedJWE.iv:        jwe.IV,
+			&encodedJWE.protected: jwe.Protected,
+			&encodedJWE.tag:       jwe.Tag,
+		} {
+			if field.Exists() {
+				require.Equal(t, field.v.x, value)
+			} else {
+				require.Nil(t, value)
+			}
+		}
+		require.Equal(t, encodedJWE.unprotected.Exists(), jwe.Unprotected != nil)
+		require.Equal(t, encodedJWE.recipients.Exists(), jwe.Recipients != nil)
+		for idx, recipient := range encodedJWE.recipients.v.x {
+			if recipient.encrypted_key.Exists() {
+				require.Equal(t, recipient.encrypted_key.v.x, jwe.Recipients[idx].EncryptedKey)
+			} else {
+				require.Nil(t, jwe.Recipients[idx].EncryptedKey)
+			}
+			require.Equal(t, recipient.header.Exists(), jwe.Recipients[idx].Header != nil)
+		}
+	})
+}
+
+// Converting a JWE to a struct and back does not change its encoding
+func TestJWEStructRoundTrip(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	encrypted, err := EncryptOptions{
+		Unprotected: map[string]interface{}{"note": "unprotected"},
+		AAD:         []byte("additional data"),
+	}.EncryptBlock([]byte("cleartext block"), cid.Undef, RecipientKey{Key: ecKey}, RecipientKey{Key: ecKey, KeyID: "ec"})
+	require.NoError(t, err)
+	expected, err := ipld.Encode(encrypted, Encode)
+	require.NoError(t, err)
+
+	jwe, err := AsJWE(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"note": "unprotected"}, jwe.Unprotected)
+	require.Equal(t, []byte("additional data"), jwe.AAD)
+	require.Len(t, jwe.Recipients, 2)
+	require.Equal(t, "ec", jwe.Recipients[1].Header["kid"])
+
+	encoded, err := jwe.Encoded()
+	require.NoError(t, err)
+	actual, err := ipld.Encode(encoded, Encode)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	// The decoded form can be decrypted directly
+	decoded, err := jwe.Decoded()
+	require.NoError(t, err)
+	block, err := DecryptBlock(decoded, ecKey)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cleartext block"), block)
+}
@@ -2,9 +2,9 @@ package dagjose
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"testing"
 
@@ -15,11 +15,9 @@ import (
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
-	"github.com/ipld/go-ipld-prime/codec/dagjson"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/fluent"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	"github.com/ipld/go-ipld-prime/multicodec"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/schema"
 	"github.com/multiformats/go-multihash"
@@ -32,96 +30,6 @@ import (
 // In order to test this property we use the `rapid` property testing library. We start by defining a series of
 // generators, used to generate arbitrary JOSE objects.
 
-// A link prototype which will build CIDs using the dag-jose multicodec and the sha-384 multihash
-var dagJOSELink = cidlink.LinkPrototype{Prefix: cid.Prefix{
-	Version:  1,    // Usually '1'.
-	Codec:    0x85, // 0x85 means "dag-jose" -- See the multicodecs table: https://github.com/multiformats/multicodec/
-	MhType:   0x12, // 0x12 means "sha2-256" -- See the multicodecs table: https://github.com/multiformats/multicodec/
-	MhLength: 32,   // sha2-256 hash has a 32-byte sum.
-}}
-
-// storeJOSE is a convenience function that passes the correct DAG-JOSE link prototype and DAG-JOSE object to
-// ipld.LinkSystem.Store
-func storeJOSE(linkContext ipld.LinkContext, jose datamodel.Node, linkSystem ipld.LinkSystem) (ipld.Link, error) {
-	return linkSystem.Store(linkContext, dagJOSELink, jose)
-}
-
-// loadJOSE is a convenience function that provides the DAG-JOSE node prototype to ipld.LinkSystem.Load and attempts to
-// cast the result to a DAG-JOSE object.
-func loadJOSE(lnk ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) (_ datamodel.Node, err error) {
-	n, err := loadJWE(lnk, linkContext, linkSystem)
-	if err != nil {
-		// If there was an error during JWE decode, try decoding as JWS
-		n, err = loadJWS(lnk, linkContext, linkSystem)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return n.(schema.TypedNode).Representation(), nil
-}
-
-func loadJWE(lnk ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) (_ datamodel.Node, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if rerr, ok := r.(error); ok {
-				err = rerr
-			} else {
-				// A reasonable fallback, for e.g. strings.
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
-	return linkSystem.Load(
-		linkContext,
-		lnk,
-		Type.DecodedJWE__Repr,
-	)
-}
-
-func loadJWS(lnk ipld.Link, linkContext ipld.LinkContext, linkSystem ipld.LinkSystem) (_ datamodel.Node, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if rerr, ok := r.(error); ok {
-				err = rerr
-			} else {
-				// A reasonable fallback, for e.g. strings.
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
-	return linkSystem.Load(
-		linkContext,
-		lnk,
-		Type.DecodedJWS__Repr,
-	)
-}
-
-// parseJOSE will return a general form JWE/JWS node given a JSON string representing a JWE/JWS in flattened or general
-// serialization
-func parseJOSE(jsonBytes []byte) (datamodel.Node, error) {
-	buf := bytes.NewReader(jsonBytes)
-	anyBuilder := basicnode.Prototype.Any.NewBuilder()
-	if err := (dagjson.DecodeOptions{
-		ParseLinks: false,
-		ParseBytes: false,
-	}.Decode(anyBuilder, buf)); err != nil {
-		return nil, err
-	} else {
-		anyNode := anyBuilder.Build()
-		if jwe, err := isJWE(anyNode); err != nil {
-			return nil, err
-		} else if jwe {
-			return unflattenJWE(anyNode)
-		} else if jws, err := isJWS(anyNode); err != nil {
-			return nil, err
-		} else if jws {
-			return unflattenJWS(anyNode)
-		} else {
-			return nil, errors.New("invalid JOSE object")
-		}
-	}
-}
-
 // Generate an arbitrary CID
 func cidGen() *rapid.Generator {
 	return rapid.Custom(func(t *rapid.T) cid.Cid {
@@ -159,7 +67,7 @@ func validJWSGen() *rapid.Generator {
 		} else {
 			if joseJws, err := signer.Sign(link.Bytes()); err != nil {
 				panic(fmt.Errorf("error signing JWS: %v", err))
-			} else if joseNode, err := parseJOSE([]byte(joseJws.FullSerialize())); err != nil {
+			} else if joseNode, err := ParseJSON([]byte(joseJws.FullSerialize())); err != nil {
 				panic(fmt.Errorf("error creating dagjose: %v", err))
 			} else {
 				return joseNode
@@ -259,25 +167,69 @@ func nonNilSliceOfEncodedBytes() *rapid.Generator {
 	})
 }
 
+// Header values nested deeper than this are not generated, so that header generation terminates
+const maxHeaderDepth = 2
+
 // Generate a map of ipld nodes with string keys. This is used for the top
 // level of the unprotected header of JOSE objects.
 func mapGen() *rapid.Generator {
 	return rapid.Custom(func(t *rapid.T) _Any__Maybe {
+		return _Any__Maybe{schema.Maybe_Value, &_Any{headerMapGen(maxHeaderDepth).Draw(t, "header").(*_Map)}}
+	})
+}
+
+// Generate a map of header values with string keys, nesting maps and lists at most depth levels deep
+func headerMapGen(depth int) *rapid.Generator {
+	return rapid.Custom(func(t *rapid.T) *_Map {
 		keys := rapid.SliceOfDistinct(
 			rapid.StringN(1, -1, -1),
 			func(k string) string {
 				return k
 			},
 		).Draw(t, "map keys").([]string)
-		header := make(map[_String]Any)
+		header := make(map[_String]MaybeAny)
 		entries := make([]_Map__entry, 0, len(keys))
 		for _, key := range keys {
 			k := _String{key}
-			v := _Any{&_String{string(nonNilSliceOfBytes().Draw(t, "string").([]byte))}}
+			v := headerValueGen(depth).Draw(t, "header value").(_Any__Maybe)
 			header[k] = &v
 			entries = append(entries, _Map__entry{k, v})
 		}
-		return _Any__Maybe{schema.Maybe_Value, &_Any{&_Map{header, entries}}}
+		return &_Map{header, entries}
+	})
+}
+
+// Generate a value of the unprotected header of JOSE objects, i.e. a value of any data model kind, nesting maps and
+// lists at most depth levels deep
+func headerValueGen(depth int) *rapid.Generator {
+	return rapid.Custom(func(t *rapid.T) _Any__Maybe {
+		// Maps and lists are only generated above the maximum depth
+		maxKind := 6
+		if depth > 0 {
+			maxKind = 8
+		}
+		switch rapid.IntRange(0, maxKind).Draw(t, "header value kind").(int) {
+		case 0:
+			return _Any__Maybe{schema.Maybe_Value, &_Any{&_Bool{rapid.Bool().Draw(t, "bool").(bool)}}}
+		case 1:
+			return _Any__Maybe{schema.Maybe_Value, &_Any{&_Int{rapid.Int64().Draw(t, "int").(int64)}}}
+		case 2:
+			return _Any__Maybe{schema.Maybe_Null, nil}
+		case 3:
+			return _Any__Maybe{schema.Maybe_Value, &_Any{&_Link{cidlink.Link{Cid: cidGen().Draw(t, "link").(cid.Cid)}}}}
+		case 4:
+			return _Any__Maybe{schema.Maybe_Value, &_Any{&_Bytes{nonNilSliceOfBytes().Draw(t, "bytes").([]byte)}}}
+		case 5:
+			float := rapid.Float64Range(-math.MaxFloat64, math.MaxFloat64).Draw(t, "float").(float64)
+			return _Any__Maybe{schema.Maybe_Value, &_Any{&_Float{float}}}
+		case 6:
+			return _Any__Maybe{schema.Maybe_Value, &_Any{&_String{string(nonNilSliceOfBytes().Draw(t, "string").([]byte))}}}
+		case 7:
+			return _Any__Maybe{schema.Maybe_Value, &_Any{headerMapGen(depth-1).Draw(t, "map").(*_Map)}}
+		default:
+			values := rapid.SliceOf(headerValueGen(depth-1)).Draw(t, "list").([]_Any__Maybe)
+			return _Any__Maybe{schema.Maybe_Value, &_Any{&_List{values}}}
+		}
 	})
 }
 
@@ -357,18 +309,14 @@ func roundTripJose(storeJose datamodel.Node) (datamodel.Node, error) {
 		return bytes.NewReader(buf.Bytes()), nil
 	}
 
-	// We're going from JOSE -> JOSE, so we don't need to complicate things with `link`.
-	multicodec.RegisterDecoder(0x85, DecodeOptions{AddLink: false}.Decode)
-	multicodec.RegisterEncoder(0x85, Encode)
-
-	if link, err := storeJOSE(
+	if link, err := StoreJOSE(
 		ipld.LinkContext{},
 		storeJose,
 		ls,
 	); err != nil {
 		panic(fmt.Errorf("error storing DagJOSE: %v", err))
 	} else {
-		if loadJose, err := loadJOSE(
+		if loadJose, err := LoadJOSE(
 			link,
 			ipld.LinkContext{},
 			ls,
@@ -387,11 +335,9 @@ func compareJOSE(t *rapid.T, encoded datamodel.Node, decoded datamodel.Node) {
 	compareJOSEField(t, "payload", encoded, decoded)
 	compareJOSEField(t, "protected", encoded, decoded)
 	compareJOSEField(t, "tag", encoded, decoded)
-
-	// TODO: `ipldNodeToGoPrimitive` doesn't currently work for IPLD nodes with (nested) `bytes` type fields
-	//compareJOSEField(t, "recipients", encoded, decoded)
-	//compareJOSEField(t, "signatures", encoded, decoded)
-	//compareJOSEField(t, "unprotected", encoded, decoded)
+	compareJOSEField(t, "recipients", encoded, decoded)
+	compareJOSEField(t, "signatures", encoded, decoded)
+	compareJOSEField(t, "unprotected", encoded, decoded)
 }
 
 func compareJOSEField(t *rapid.T, key string, encoded datamodel.Node, decoded datamodel.Node) {
@@ -405,36 +351,59 @@ func compareJOSEField(t *rapid.T, key string, encoded datamodel.Node, decoded da
 		if (encodedField.Kind() == decodedField.Kind()) ||
 			(encodedField.Kind() == datamodel.Kind_Bytes && decodedField.Kind() == datamodel.Kind_String) ||
 			(encodedField.Kind() == datamodel.Kind_String && decodedField.Kind() == datamodel.Kind_Bytes) {
-			compareNodes(t, encodedField.Kind(), encodedField, decodedField)
+			compareNodes(t, key, encodedField.Kind(), encodedField, decodedField)
 		} else {
 			t.Errorf("fields must be of the same or compatible kind:\nencoded{%s}\ndecoded{%s}", encodedField.Kind(), decodedField.Kind())
 		}
 	}
 }
 
-func compareNodes(t *rapid.T, kind datamodel.Kind, f1 datamodel.Node, f2 datamodel.Node) {
-	var goF1, goF2 interface{}
+func compareNodes(t *rapid.T, key string, kind datamodel.Kind, f1 datamodel.Node, f2 datamodel.Node) {
 	switch kind {
-	case datamodel.Kind_List:
-		goF1 = []interface{}{}
-		goF2 = []interface{}{}
-	case datamodel.Kind_Map:
-	case datamodel.Kind_Link:
-		goF1 = map[string]interface{}{}
-		goF2 = map[string]interface{}{}
+	case datamodel.Kind_List, datamodel.Kind_Map, datamodel.Kind_Link:
 	default:
 		compareJOSEBytes(t, f1, f2)
 		return
 	}
-	if err := ipldNodeToGoPrimitive(f1, &goF1); err != nil {
+	goF1, err := ipldNodeToGoPrimitive(f1)
+	if err != nil {
 		t.Errorf("error converting field: %v/%v", f1, err)
-	} else if err := ipldNodeToGoPrimitive(f2, &goF2); err != nil {
+		return
+	}
+	goF2, err := ipldNodeToGoPrimitive(f2)
+	if err != nil {
 		t.Errorf("error converting field: %v/%v", f2, err)
-	} else if !reflect.DeepEqual(goF1, goF2) {
+		return
+	}
+	// Headers keep the kinds of their values, so only the other fields of signatures and recipients are converted
+	if key != "unprotected" {
+		goF1 = bytesAsBase64Url(goF1)
+	}
+	if !reflect.DeepEqual(goF1, goF2) {
 		t.Errorf("fields do not match:\n%s\n%s", goF1, goF2)
 	}
 }
 
+// Encoded objects hold the binary fields of signatures and recipients as bytes, while decoded objects hold them as
+// base64url-encoded strings. Headers are left as they are.
+func bytesAsBase64Url(g interface{}) interface{} {
+	switch value := g.(type) {
+	case []byte:
+		return encodeBase64Url(value)
+	case map[string]interface{}:
+		for key, entry := range value {
+			if key != "header" {
+				value[key] = bytesAsBase64Url(entry)
+			}
+		}
+	case []interface{}:
+		for idx, entry := range value {
+			value[idx] = bytesAsBase64Url(entry)
+		}
+	}
+	return g
+}
+
 func compareJOSEBytes(t *rapid.T, f1 datamodel.Node, f2 datamodel.Node) {
 	if f1String, err := stringOrBytesAsString(f1); err != nil {
 		t.Errorf("error fetching field: %v", err)
@@ -505,7 +474,7 @@ func TestAlwaysDeserializesToEitherJWSOrJWE(t *testing.T) {
 // A JWS without a signature is not valid
 func TestMissingPayloadErrorParsingJWS(t *testing.T) {
 	jsonStr := "{\"signatures\": []}"
-	jws, err := parseJOSE([]byte(jsonStr))
+	jws, err := ParseJSON([]byte(jsonStr))
 	require.NotNil(t, err)
 	require.Nil(t, jws)
 }
@@ -513,7 +482,7 @@ func TestMissingPayloadErrorParsingJWS(t *testing.T) {
 // A JWE without ciphertext is not valid
 func TestMissingCiphertextErrorParsingJWE(t *testing.T) {
 	jsonStr := "{\"header\": {}}"
-	jwe, err := parseJOSE([]byte(jsonStr))
+	jwe, err := ParseJSON([]byte(jsonStr))
 	require.NotNil(t, err)
 	require.Nil(t, jwe)
 }
@@ -522,7 +491,7 @@ func TestMissingCiphertextErrorParsingJWE(t *testing.T) {
 func TestFlattenedJWSErrorIfSignatureAndSignaturesDefined(t *testing.T) {
 	payload := encodeBase64Url(createCid([]byte("payload")).Bytes())
 	jsonStr := "{\"signature\": \"sig\", \"signatures\": [], \"payload\": \"" + payload + "\"}"
-	jws, err := parseJOSE([]byte(jsonStr))
+	jws, err := ParseJSON([]byte(jsonStr))
 	require.NotNil(t, err)
 	require.Contains(t, err.Error(), "invalid JWS serialization")
 	require.Nil(t, jws)
@@ -551,7 +520,7 @@ func TestLoadingJWSWithNonCIDPayloadReturnsError(t *testing.T) {
 		ls.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
 			return bytes.NewReader(buf.Bytes()), nil
 		}
-		if _, err := storeJOSE(
+		if _, err := StoreJOSE(
 			ipld.LinkContext{},
 			node,
 			ls,
@@ -570,7 +539,7 @@ func TestFlattenedJWEErrorIfEncryptedKeyOrHeaderAndRecipientsDefined(t *testing.
 		[]byte("{\"ciphertext\": \"\", \"header\": {}, \"recipients\": []}"),
 	}
 	for _, scenario := range scenarios {
-		jwe, err := parseJOSE(scenario)
+		jwe, err := ParseJSON(scenario)
 		require.NotNil(t, err)
 		require.Contains(t, err.Error(), "invalid JWE serialization")
 		require.Nil(t, jwe)
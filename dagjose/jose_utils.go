@@ -2,21 +2,29 @@ package dagjose
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
 
 	"github.com/go-jose/go-jose/v4/json"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime/codec/dagjson"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/schema"
 	"github.com/multiformats/go-multibase"
 )
 
 func unflattenJWE(n datamodel.Node) (datamodel.Node, error) {
+	// Typed nodes (e.g. a `DecodedJWS` returned by `LoadJWS`) must be looked at through their "representation" node so
+	// that the `Any` unions in headers are seen as the values they contain.
+	if tn, castOk := n.(schema.TypedNode); castOk {
+		n = tn.Representation()
+	}
 	// Check for the fastpath where the passed node is already of type `_EncodedJWE__Repr` or `_EncodedJWE`
 	if _, castOk := n.(*_EncodedJWE__Repr); !castOk {
 		// This could still be `_EncodedJWE`, so check for that.
@@ -24,110 +32,54 @@ func unflattenJWE(n datamodel.Node) (datamodel.Node, error) {
 			if ciphertext, err := n.LookupByString("ciphertext"); err != nil {
 				// `ciphertext` is mandatory so if any error occurs, return from here
 				return nil, err
-			} else if ciphertextString, err := ciphertext.AsString(); err != nil {
-				return nil, err
 			} else if recipients, err := lookupIgnoreAbsent("recipients", n); err != nil {
 				return nil, err
 			} else {
-				jwe := map[string]interface{}{
-					"ciphertext": ciphertextString,
-				}
 				// If `recipients` is absent, this must be a "flattened" JWE.
 				if recipients == nil {
-					recipientList := make([]map[string]interface{}, 1)
-					recipientList[0] = make(map[string]interface{}, 0) // all recipient fields are optional
-					if encryptedKey, err := lookupIgnoreNoSuchField("encrypted_key", n); err != nil {
+					// All recipient fields are optional
+					if recipientFields, err := appendFields(nil, n, "encrypted_key", "header"); err != nil {
 						return nil, err
-					} else if encryptedKey != nil {
-						if encryptedKeyString, err := encryptedKey.AsString(); err != nil {
+					} else if len(recipientFields) > 0 {
+						// Only add `recipients` to the JWE if one or more fields were present
+						if recipients, err = buildList(buildMap(recipientFields)); err != nil {
 							return nil, err
-						} else {
-							recipientList[0]["encrypted_key"] = encryptedKeyString
 						}
 					}
-					if header, err := lookupIgnoreNoSuchField("header", n); err != nil {
-						return nil, err
-					} else if header != nil {
-						var headerMap map[string]interface{}
-						if err := ipldNodeToGoPrimitive(header, &headerMap); err != nil {
-							return nil, err
-						} else {
-							recipientList[0]["header"] = headerMap
-						}
-					}
-					// Only add `recipients` to the JWE if one or more fields were present in the first list entry
-					if len(recipientList[0]) > 0 {
-						jwe["recipients"] = recipientList
-					}
 				} else {
 					// If `recipients` is present, this must be a "general" JWE and no changes are needed but make sure
 					// that `header` and/or `encrypted_key` are not present since that would be a violation of the spec.
 					if encryptedKey, err := lookupIgnoreNoSuchField("encrypted_key", n); err != nil {
 						return nil, err
 					} else if encryptedKey != nil {
-						return nil, errors.New("invalid JWE serialization")
+						return nil, invalidSerialization(ErrInvalidJWE, "encrypted_key", "recipients")
 					}
 					if header, err := lookupIgnoreNoSuchField("header", n); err != nil {
 						return nil, err
 					} else if header != nil {
-						return nil, errors.New("invalid JWE serialization")
-					}
-					var recipientList []map[string]interface{}
-					if err = ipldNodeToGoPrimitive(recipients, &recipientList); err != nil {
-						return nil, err
+						return nil, invalidSerialization(ErrInvalidJWE, "header", "recipients")
 					}
 					// Only add `recipients` to the JWE if one or more fields were present in the first list entry
-					if len(recipientList[0]) > 0 {
-						jwe["recipients"] = recipientList
-					}
-				}
-				if aad, err := lookupIgnoreAbsent("aad", n); err != nil {
-					return nil, err
-				} else if aad != nil {
-					if aadString, err := aad.AsString(); err != nil {
-						return nil, err
-					} else {
-						jwe["aad"] = aadString
-					}
-				}
-				if iv, err := lookupIgnoreAbsent("iv", n); err != nil {
-					return nil, err
-				} else if iv != nil {
-					if ivString, err := iv.AsString(); err != nil {
-						return nil, err
-					} else {
-						jwe["iv"] = ivString
+					if recipient, err := recipients.LookupByIndex(0); err != nil {
+						if _, notFoundErr := err.(datamodel.ErrNotExists); !notFoundErr {
+							return nil, err
+						}
+						recipients = nil
+					} else if recipient.Length() == 0 {
+						recipients = nil
 					}
 				}
-				if protected, err := lookupIgnoreAbsent("protected", n); err != nil {
+				jweFields := []nodeField{{"ciphertext", ciphertext}}
+				if jweFields, err = appendFields(jweFields, n, "aad", "iv", "protected"); err != nil {
 					return nil, err
-				} else if protected != nil {
-					if protectedString, err := protected.AsString(); err != nil {
-						return nil, err
-					} else {
-						jwe["protected"] = protectedString
-					}
 				}
-				if tag, err := lookupIgnoreAbsent("tag", n); err != nil {
-					return nil, err
-				} else if tag != nil {
-					if tagString, err := tag.AsString(); err != nil {
-						return nil, err
-					} else {
-						jwe["tag"] = tagString
-					}
+				if recipients != nil {
+					jweFields = append(jweFields, nodeField{"recipients", recipients})
 				}
-				if unprotected, err := lookupIgnoreAbsent("unprotected", n); err != nil {
+				if jweFields, err = appendFields(jweFields, n, "tag", "unprotected"); err != nil {
 					return nil, err
-				} else if unprotected != nil {
-					var unprotectedMap map[string]interface{}
-					if err := ipldNodeToGoPrimitive(unprotected, &unprotectedMap); err != nil {
-						return nil, err
-					} else {
-						jwe["unprotected"] = unprotectedMap
-					}
 				}
-				if n, err = goPrimitiveToIpldNode(jwe); err != nil {
+				if n, err = buildMap(jweFields); err != nil {
 					return nil, err
 				}
 			}
@@ -143,58 +95,33 @@ func unflattenJWE(n datamodel.Node) (datamodel.Node, error) {
 // Always ignore `link`, if it was present. That is part of the "presentation" of the JWS but doesn't need to be part of
 // the schema.
 func unflattenJWS(n datamodel.Node) (datamodel.Node, error) {
+	// Typed nodes (e.g. a `DecodedJWS` returned by `LoadJWS`) must be looked at through their "representation" node so
+	// that the `Any` unions in headers are seen as the values they contain.
+	if tn, castOk := n.(schema.TypedNode); castOk {
+		n = tn.Representation()
+	}
 	// Check for the fastpath where the passed node is already of type `_EncodedJWES__Repr` or `_EncodedJWS`
 	if _, castOk := n.(*_EncodedJWS__Repr); !castOk {
 		// This could still be `_EncodedJWS`, so check for that.
 		if _, castOk := n.(*_EncodedJWS); !castOk {
-			if payload, err := n.LookupByString("payload"); err != nil {
-				// `payload` is mandatory so if any error occurs, return from here
+			// `payload` is only absent from JWS with a detached payload
+			if payload, err := lookupIgnoreAbsent("payload", n); err != nil {
 				return nil, err
-			} else if payloadString, err := payload.AsString(); err != nil {
+			} else if err := validatePayload(payload); err != nil {
 				return nil, err
-			} else if _, err := cid.Decode(string(multibase.Base64url) + payloadString); err != nil {
-				return nil, errors.New(fmt.Sprintf("payload is not a valid CID: %v", err))
-			} else if payloadString, err := payload.AsString(); err != nil {
+			} else if err := validateLink(n); err != nil {
+				// `link` is dropped here, so make sure it matches `payload` first
 				return nil, err
 			} else if signatures, err := lookupIgnoreAbsent("signatures", n); err != nil {
 				return nil, err
 			} else {
-				jws := map[string]interface{}{
-					"payload": payloadString,
-				}
 				// If `signatures` is absent, this must be a "flattened" JWS.
 				if signatures == nil {
-					signaturesList := make([]map[string]interface{}, 1)
-					signaturesList[0] = make(map[string]interface{}, 1) // at least one `signature` must be present
-					if header, err := lookupIgnoreNoSuchField("header", n); err != nil {
-						return nil, err
-					} else if header != nil {
-						var headerMap map[string]interface{}
-						if err := ipldNodeToGoPrimitive(header, &headerMap); err != nil {
-							return nil, err
-						} else {
-							signaturesList[0]["header"] = headerMap
-						}
-					}
-					if protected, err := lookupIgnoreNoSuchField("protected", n); err != nil {
+					if signatureFields, err := appendFields(nil, n, "header", "protected", "signature"); err != nil {
 						return nil, err
-					} else if protected != nil {
-						if protectedString, err := protected.AsString(); err != nil {
-							return nil, err
-						} else {
-							signaturesList[0]["protected"] = protectedString
-						}
-					}
-					if signature, err := lookupIgnoreNoSuchField("signature", n); err != nil {
+					} else if signatures, err = buildList(buildMap(signatureFields)); err != nil {
 						return nil, err
-					} else if signature != nil {
-						if signatureString, err := signature.AsString(); err != nil {
-							return nil, err
-						} else {
-							signaturesList[0]["signature"] = signatureString
-						}
 					}
-					jws["signatures"] = signaturesList
 				} else {
 					// If `signatures` is present, this must be a "general" JWS and no changes are needed but make sure
 					// that `header`, `protected`, and/or `signature` are not also present since that would be a
@@ -202,25 +129,24 @@ func unflattenJWS(n datamodel.Node) (datamodel.Node, error) {
 					if header, err := lookupIgnoreNoSuchField("header", n); err != nil {
 						return nil, err
 					} else if header != nil {
-						return nil, errors.New("invalid JWS serialization")
+						return nil, invalidSerialization(ErrInvalidJWS, "header", "signatures")
 					}
 					if protected, err := lookupIgnoreNoSuchField("protected", n); err != nil {
 						return nil, err
 					} else if protected != nil {
-						return nil, errors.New("invalid JWS serialization")
+						return nil, invalidSerialization(ErrInvalidJWS, "protected", "signatures")
 					}
 					if signature, err := lookupIgnoreNoSuchField("signature", n); err != nil {
 						return nil, err
 					} else if signature != nil {
-						return nil, errors.New("invalid JWS serialization")
+						return nil, invalidSerialization(ErrInvalidJWS, "signature", "signatures")
 					}
-					var signatureList []map[string]interface{}
-					if err = ipldNodeToGoPrimitive(signatures, &signatureList); err != nil {
-						return nil, err
-					}
-					jws["signatures"] = signatureList
 				}
-				if n, err = goPrimitiveToIpldNode(jws); err != nil {
+				jwsFields := []nodeField{{"signatures", signatures}}
+				if payload != nil {
+					jwsFields = append([]nodeField{{"payload", payload}}, jwsFields...)
+				}
+				if n, err = buildMap(jwsFields); err != nil {
 					return nil, err
 				}
 			}
@@ -233,71 +159,276 @@ func unflattenJWS(n datamodel.Node) (datamodel.Node, error) {
 	return n, nil
 }
 
+// jwsSignatures assembles the signatures of a JWS in general or flattened form with the given prototype, or returns nil
+// if the JWS has none. As `payload` is required, this is the only way to assemble the signatures of a JWS with a
+// detached payload.
+func jwsSignatures(n datamodel.Node, np datamodel.NodePrototype) (datamodel.Node, error) {
+	n, err := unflattenJWS(n)
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := lookupIgnoreAbsent("signatures", n)
+	if (err != nil) || (signatures == nil) {
+		return nil, err
+	}
+	signaturesBuilder := np.NewBuilder()
+	if err := datamodel.Copy(signatures, signaturesBuilder); err != nil {
+		return nil, &Error{Kind: ErrInvalidJWS, Path: datamodel.ParsePath("signatures"), Causes: []error{err}}
+	}
+	return signaturesBuilder.Build(), nil
+}
+
+// isDetached returns true for a JWS without `payload`, i.e. with a detached payload.
+func isDetached(n datamodel.Node) bool {
+	if jws, err := isJWS(n); (err != nil) || !jws {
+		return false
+	}
+	payload, err := lookupIgnoreAbsent("payload", n)
+	return (err == nil) && (payload == nil)
+}
+
+// validatePayload checks that a `payload` field, if present, holds a CID.
+func validatePayload(payload datamodel.Node) error {
+	if payload == nil {
+		return nil
+	} else if payloadString, err := payload.AsString(); err != nil {
+		return err
+	} else if _, err := cid.Decode(string(multibase.Base64url) + payloadString); err != nil {
+		return &Error{
+			Kind:   ErrInvalidJWS,
+			Path:   datamodel.ParsePath("payload"),
+			Causes: []error{fmt.Errorf("payload is not a valid CID: %v", err)},
+		}
+	}
+	return nil
+}
+
+// isJWS returns true for a JWS in general or flattened form, including JWS with a detached payload, which are told
+// apart by their signatures.
 func isJWS(n datamodel.Node) (bool, error) {
-	if payload, err := lookupIgnoreNoSuchField("payload", n); err != nil {
-		return false, err
-	} else {
-		return payload != nil, nil
+	if n.Kind() != datamodel.Kind_Map {
+		return false, nil
+	}
+	for _, key := range []string{"payload", "signatures", "signature"} {
+		if value, err := lookupIgnoreNoSuchField(key, n); err != nil {
+			return false, err
+		} else if value != nil {
+			return true, nil
+		}
 	}
+	return false, nil
 }
 
 func isJWE(n datamodel.Node) (bool, error) {
-	if ciphertext, err := lookupIgnoreNoSuchField("ciphertext", n); err != nil {
+	if n.Kind() != datamodel.Kind_Map {
+		return false, nil
+	} else if ciphertext, err := lookupIgnoreNoSuchField("ciphertext", n); err != nil {
 		return false, err
 	} else {
 		return ciphertext != nil, nil
 	}
 }
 
+// nodeField is a field of a map being assembled by buildMap.
+type nodeField struct {
+	key   string
+	value datamodel.Node
+}
+
+// appendFields appends the given fields of a map node, if present, to a list of fields.
+func appendFields(fields []nodeField, n datamodel.Node, keys ...string) ([]nodeField, error) {
+	for _, key := range keys {
+		if value, err := lookupIgnoreNoSuchField(key, n); err != nil {
+			return nil, err
+		} else if value != nil {
+			fields = append(fields, nodeField{key, value})
+		}
+	}
+	return fields, nil
+}
+
+// buildMap assembles a map from the given fields, copying their values structurally.
+func buildMap(fields []nodeField) (datamodel.Node, error) {
+	return fluent.BuildMap(basicnode.Prototype.Map, int64(len(fields)), func(ma fluent.MapAssembler) {
+		for _, field := range fields {
+			ma.AssembleEntry(field.key).AssignNode(field.value)
+		}
+	})
+}
+
+// buildList assembles a list containing a single node, which is passed along with the error from creating it.
+func buildList(n datamodel.Node, err error) (datamodel.Node, error) {
+	if err != nil {
+		return nil, err
+	}
+	return fluent.BuildList(basicnode.Prototype.List, 1, func(la fluent.ListAssembler) {
+		la.AssembleValue().AssignNode(n)
+	})
+}
+
+// goPrimitiveToIpldNode copies plain Go values into an IPLD node. It is the reverse of ipldNodeToGoPrimitive, so
+// `[]byte` becomes bytes, `cid.Cid` a link and nil null. Values of other types, e.g. structs, are converted through
+// their JSON encoding.
 func goPrimitiveToIpldNode(g interface{}) (datamodel.Node, error) {
-	if jsonBytes, err := json.Marshal(g); err != nil {
+	na := basicnode.Prototype.Any.NewBuilder()
+	if err := assignGoPrimitive(na, g); err != nil {
 		return nil, err
-	} else {
-		na := basicnode.Prototype.Any.NewBuilder()
-		if err := dagjson.Decode(na, bytes.NewReader(jsonBytes)); err != nil {
-			return nil, err
+	}
+	return na.Build(), nil
+}
+
+func assignGoPrimitive(na datamodel.NodeAssembler, g interface{}) error {
+	switch value := g.(type) {
+	case nil:
+		return na.AssignNull()
+	case datamodel.Node:
+		return na.AssignNode(value)
+	case datamodel.Link:
+		return na.AssignLink(value)
+	case cid.Cid:
+		return na.AssignLink(cidlink.Link{Cid: value})
+	case json.Marshaler:
+		return assignJSON(na, value)
+	}
+	v := reflect.ValueOf(g)
+	switch v.Kind() {
+	case reflect.Bool:
+		return na.AssignBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return na.AssignInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return fmt.Errorf("integer %d is too large", v.Uint())
+		}
+		return na.AssignInt(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return na.AssignFloat(v.Float())
+	case reflect.String:
+		return na.AssignString(v.String())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return na.AssignNull()
+		}
+		return assignGoPrimitive(na, v.Elem().Interface())
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		} else if v.IsNil() {
+			return na.AssignNull()
+		}
+		// Sort keys so that the node does not depend on the iteration order of the map
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		if ma, err := na.BeginMap(int64(len(keys))); err != nil {
+			return err
+		} else {
+			for _, key := range keys {
+				if va, err := ma.AssembleEntry(key.String()); err != nil {
+					return err
+				} else if err := assignGoPrimitive(va, v.MapIndex(key).Interface()); err != nil {
+					return err
+				}
+			}
+			return ma.Finish()
+		}
+	case reflect.Slice, reflect.Array:
+		if (v.Kind() == reflect.Slice) && v.IsNil() {
+			return na.AssignNull()
+		} else if (v.Kind() == reflect.Slice) && (v.Type().Elem().Kind() == reflect.Uint8) {
+			return na.AssignBytes(v.Bytes())
+		}
+		if la, err := na.BeginList(int64(v.Len())); err != nil {
+			return err
 		} else {
-			return na.Build(), nil
+			for idx := 0; idx < v.Len(); idx++ {
+				if err := assignGoPrimitive(la.AssembleValue(), v.Index(idx).Interface()); err != nil {
+					return err
+				}
+			}
+			return la.Finish()
 		}
 	}
+	return assignJSON(na, g)
 }
 
-// TODO: Doesn't currently work for IPLD nodes with (nested) `bytes` type fields
-func ipldNodeToGoPrimitive(n datamodel.Node, g interface{}) error {
-	jsonBytes := bytes.NewBuffer([]byte{})
-	if err := (dagjson.EncodeOptions{
-		EncodeLinks: false,
-		EncodeBytes: false,
-	}.Encode(n, jsonBytes)); err != nil {
+// assignJSON assigns a Go value through its JSON encoding.
+func assignJSON(na datamodel.NodeAssembler, g interface{}) error {
+	if jsonBytes, err := json.Marshal(g); err != nil {
 		return err
 	} else {
-		if err := json.Unmarshal(jsonBytes.Bytes(), &g); err != nil {
-			return err
+		return dagjson.Decode(na, bytes.NewReader(jsonBytes))
+	}
+}
+
+// ipldNodeToGoPrimitive copies an IPLD node into plain Go values. Maps become `map[string]interface{}`, lists
+// `[]interface{}`, bytes `[]byte`, links `cid.Cid` (or the `datamodel.Link` itself if it is not a CID link), and null
+// nil. Integers and floats are kept apart as `int64` and `float64`.
+func ipldNodeToGoPrimitive(n datamodel.Node) (interface{}, error) {
+	switch n.Kind() {
+	case datamodel.Kind_Null:
+		return nil, nil
+	case datamodel.Kind_Bool:
+		return n.AsBool()
+	case datamodel.Kind_Int:
+		return n.AsInt()
+	case datamodel.Kind_Float:
+		return n.AsFloat()
+	case datamodel.Kind_String:
+		return n.AsString()
+	case datamodel.Kind_Bytes:
+		if b, err := n.AsBytes(); err != nil {
+			return nil, err
+		} else {
+			return append([]byte{}, b...), nil
+		}
+	case datamodel.Kind_Link:
+		if link, err := n.AsLink(); err != nil {
+			return nil, err
+		} else if cl, castOk := link.(cidlink.Link); castOk {
+			return cl.Cid, nil
 		} else {
-			if reflect.TypeOf(g).Kind() == reflect.Map {
-				g = sanitizeMap(g.(map[string]interface{}))
+			return link, nil
+		}
+	case datamodel.Kind_Map:
+		m := make(map[string]interface{}, n.Length())
+		for itr := n.MapIterator(); !itr.Done(); {
+			if k, v, err := itr.Next(); err != nil {
+				return nil, err
+			} else if key, err := k.AsString(); err != nil {
+				return nil, err
+			} else if m[key], err = ipldNodeToGoPrimitive(v); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case datamodel.Kind_List:
+		l := make([]interface{}, 0, n.Length())
+		for itr := n.ListIterator(); !itr.Done(); {
+			if _, v, err := itr.Next(); err != nil {
+				return nil, err
+			} else if value, err := ipldNodeToGoPrimitive(v); err != nil {
+				return nil, err
+			} else {
+				l = append(l, value)
 			}
-			return nil
 		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("cannot convert node of kind %s", n.Kind())
 	}
 }
 
-// Remove all `nil` values from the top-level structure or from within nested maps or slices
-func sanitizeMap(m map[string]interface{}) map[string]interface{} {
-	for key, value := range m {
-		if value == nil {
-			delete(m, key)
-		} else if reflect.ValueOf(value).Kind() == reflect.Slice {
-			for idx, entry := range value.([]interface{}) {
-				if reflect.ValueOf(entry).Kind() == reflect.Map {
-					m[key].([]interface{})[idx] = sanitizeMap(m[key].([]interface{})[idx].(map[string]interface{}))
-				}
-			}
-		} else if reflect.ValueOf(value).Kind() == reflect.Map {
-			m[key] = sanitizeMap(value.(map[string]interface{}))
-		}
+// ipldNodeToGoMap copies an IPLD map, e.g. a header, into a Go map. Null results in a nil map.
+func ipldNodeToGoMap(n datamodel.Node) (map[string]interface{}, error) {
+	if g, err := ipldNodeToGoPrimitive(n); err != nil {
+		return nil, err
+	} else if g == nil {
+		return nil, nil
+	} else if m, castOk := g.(map[string]interface{}); !castOk {
+		return nil, fmt.Errorf("expected a map, found %s", n.Kind())
+	} else {
+		return m, nil
 	}
-	return m
 }
 
 func lookupIgnoreAbsent(key string, n datamodel.Node) (datamodel.Node, error) {
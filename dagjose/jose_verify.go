@@ -0,0 +1,333 @@
+package dagjose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secp256k1ecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	gojose "github.com/go-jose/go-jose/v4"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+var (
+	// ErrNoVerificationKey is reported for a signature when none of the available keys could be used to verify it.
+	ErrNoVerificationKey = errors.New("no key available to verify signature")
+	// ErrInvalidSignature is reported for a signature when none of the available keys verified it.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// SignatureResult reports the outcome of verifying a single signature of a JWS.
+type SignatureResult struct {
+	// Index of the signature in the JWS `signatures`.
+	Index int
+	// Algorithm is the `alg` header parameter of the signature.
+	Algorithm string
+	// KeyID is the `kid` header parameter of the signature, if any.
+	KeyID string
+	// Key is the public key that verified the signature, or nil if the signature was not verified.
+	Key interface{}
+	// Err is nil if the signature is valid, and describes why it could not be verified otherwise.
+	Err error
+}
+
+// Valid returns true if the signature was verified.
+func (r SignatureResult) Valid() bool {
+	return r.Err == nil
+}
+
+// VerifyOptions can be used to customize how the signatures of a JWS are verified. The Verify method on this struct
+// verifies every signature of a JWS against the configured keys.
+type VerifyOptions struct {
+	// Keys are the public keys to verify signatures with. The following are supported: ed25519.PublicKey,
+	// *ecdsa.PublicKey, *rsa.PublicKey, *secp256k1.PublicKey, gojose.JSONWebKey (or a pointer to one) holding one of
+	// these, and any private key from which one of these can be derived. Keys are only used for signatures without a
+	// `kid` header parameter, or with one for which KeySet and Resolver select no keys.
+	Keys []interface{}
+	// KeySet is a JWK set to verify signatures with. If a signature has a `kid` header parameter, only keys from the
+	// set with a matching key ID are used.
+	KeySet *gojose.JSONWebKeySet
+	// Resolver is consulted for the keys of signatures with a `kid` header parameter, e.g. DIDKeyResolver for did:key
+	// DID URLs. Keys it resolves are used instead of Keys.
+	Resolver KeyResolver
+	// Algorithms restricts the accepted `alg` header parameters. If empty, all supported algorithms are accepted.
+	Algorithms []string
+}
+
+// Verify verifies every signature of the given JWS and returns one result per signature. The JWS may be a
+// DecodedJWS or any node that can be assembled into one, e.g. the node returned by Decode. An error is only returned
+// if the JWS itself is invalid; failures to verify individual signatures are reported in the results.
+func (cfg VerifyOptions) Verify(n datamodel.Node) ([]SignatureResult, error) {
+	jws, err := asDecodedJWS(n)
+	if (err != nil) && isDetached(n) {
+		return nil, errors.New("JWS has a detached payload, use VerifyDetached")
+	} else if err != nil {
+		return nil, err
+	} else if !jws.signatures.Exists() {
+		return nil, errors.New("JWS has no signatures")
+	}
+	return cfg.verifySignatures([]byte(jws.payload.x), &jws.signatures.v)
+}
+
+// VerifyDetached verifies every signature of a JWS with a detached payload against the given payload CID, and returns
+// one result per signature. The JWS may be in general or flattened form, e.g. the node returned by Decode with
+// DecodeOptions.DetachedPayload set. A `payload` field, if present, is ignored.
+func (cfg VerifyOptions) VerifyDetached(payload cid.Cid, n datamodel.Node) ([]SignatureResult, error) {
+	if !payload.Defined() {
+		return nil, errors.New("payload is not a valid CID")
+	}
+	signatures, err := jwsSignatures(n, Type.DecodedSignatures__Repr)
+	if err != nil {
+		return nil, err
+	} else if signatures == nil {
+		return nil, errors.New("JWS has no signatures")
+	}
+	return cfg.verifySignatures(payload.Bytes(), signatures.(DecodedSignatures))
+}
+
+// verifySignatures verifies every signature over the given raw payload bytes.
+func (cfg VerifyOptions) verifySignatures(payload []byte, signatures DecodedSignatures) ([]SignatureResult, error) {
+	if len(signatures.x) == 0 {
+		return nil, errors.New("JWS has no signatures")
+	}
+	results := make([]SignatureResult, len(signatures.x))
+	for idx := range signatures.x {
+		results[idx] = cfg.verifySignature(idx, &signatures.x[idx], payload)
+	}
+	return results, nil
+}
+
+// Verify verifies every signature of the given JWS against the given public keys and returns one result per
+// signature. See VerifyOptions.Verify.
+func Verify(jws datamodel.Node, keys ...interface{}) ([]SignatureResult, error) {
+	return VerifyOptions{Keys: keys}.Verify(jws)
+}
+
+// VerifyDetached verifies every signature of a JWS with a detached payload against the given payload CID and public
+// keys, and returns one result per signature. See VerifyOptions.VerifyDetached.
+func VerifyDetached(payload cid.Cid, jws datamodel.Node, keys ...interface{}) ([]SignatureResult, error) {
+	return VerifyOptions{Keys: keys}.VerifyDetached(payload, jws)
+}
+
+func (cfg VerifyOptions) verifySignature(idx int, signature DecodedSignature, payload []byte) SignatureResult {
+	result := SignatureResult{Index: idx}
+	var protected []byte
+	if signature.protected.Exists() {
+		protected = []byte(signature.protected.v.x)
+	}
+	header, err := signature.JOSEHeader()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	protectedHeader, err := signature.ProtectedHeader()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	encoded, err := payloadEncoding(protectedHeader, header)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if len(header.Algorithm) == 0 {
+		result.Err = errors.New("missing `alg` header parameter")
+		return result
+	} else if !cfg.allowsAlgorithm(header.Algorithm) {
+		result.Err = fmt.Errorf("algorithm %s is not allowed", header.Algorithm)
+		return result
+	}
+	result.Algorithm = header.Algorithm
+	result.KeyID = header.KeyID
+	signingInput := signingInput(protected, payload, encoded)
+	keys, err := cfg.candidateKeys(result.KeyID)
+	if err != nil {
+		result.Err = fmt.Errorf("resolving key %s: %w", result.KeyID, err)
+		return result
+	}
+	result.Err = ErrNoVerificationKey
+	for _, key := range keys {
+		if err := verifyPayload(result.Algorithm, key, signingInput, []byte(signature.signature.x)); err == nil {
+			result.Key = key
+			result.Err = nil
+			break
+		} else if errors.Is(err, ErrInvalidSignature) {
+			// Keys that can't be used with the algorithm are skipped, but a key that can and doesn't verify the
+			// signature means that the signature is invalid unless another key verifies it.
+			result.Err = err
+		}
+	}
+	return result
+}
+
+// understoodCritical holds the header parameters that can be listed in `crit` (RFC 7515 §4.1.11).
+var understoodCritical = map[string]bool{"b64": true}
+
+// payloadEncoding returns whether the payload is base64url-encoded in the signing input of a signature, i.e. whether its
+// `b64` header parameter (RFC 7797 §3) is absent or true, given its protected header and the union of its headers.
+// Critical header parameters must be understood, and `b64` and `crit` must both be protected.
+func payloadEncoding(protected *Header, header *Header) (bool, error) {
+	if (header.Critical != nil) && (protected.Critical == nil) {
+		return false, errors.New("`crit` header parameter must be protected")
+	}
+	for _, name := range protected.Critical {
+		if !understoodCritical[name] {
+			return false, fmt.Errorf("unsupported critical header parameter `%s`", name)
+		}
+	}
+	b64, exists := header.Extra["b64"]
+	if !exists {
+		return true, nil
+	} else if _, protectedB64 := protected.Extra["b64"]; !protectedB64 {
+		return false, errors.New("`b64` header parameter must be protected")
+	}
+	encoded, castOk := b64.(bool)
+	if !castOk {
+		return false, errors.New("`b64` header parameter must be a boolean")
+	}
+	for _, name := range protected.Critical {
+		if name == "b64" {
+			return encoded, nil
+		}
+	}
+	return false, errors.New("`b64` header parameter must be listed in `crit`")
+}
+
+func (cfg VerifyOptions) allowsAlgorithm(alg string) bool {
+	if len(cfg.Algorithms) == 0 {
+		return true
+	}
+	for _, allowed := range cfg.Algorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateKeys returns the public keys that may have produced a signature with the given key ID. When the key ID
+// selects keys from the KeySet or the Resolver, only those keys are returned. The configured Keys are only used for
+// signatures without a key ID or with one that selects no keys.
+func (cfg VerifyOptions) candidateKeys(kid string) ([]interface{}, error) {
+	var keys []interface{}
+	if len(kid) == 0 {
+		if cfg.KeySet != nil {
+			for _, jwk := range cfg.KeySet.Keys {
+				keys = append(keys, jwk)
+			}
+		}
+		return append(keys, cfg.Keys...), nil
+	}
+	keys, _ = JWKSetResolver{cfg.KeySet}.ResolveKey(kid)
+	if cfg.Resolver != nil {
+		if resolved, err := cfg.Resolver.ResolveKey(kid); err != nil {
+			return nil, err
+		} else {
+			keys = append(keys, resolved...)
+		}
+	}
+	if len(keys) > 0 {
+		return keys, nil
+	}
+	return cfg.Keys, nil
+}
+
+// asDecodedJWS returns the given node as a DecodedJWS, assembling a new one if needed.
+func asDecodedJWS(n datamodel.Node) (DecodedJWS, error) {
+	switch jws := n.(type) {
+	case *_DecodedJWS:
+		return jws, nil
+	case *_DecodedJWS__Repr:
+		return (*_DecodedJWS)(jws), nil
+	}
+	if tn, castOk := n.(schema.TypedNode); castOk {
+		// The "representation" node gives an accurate view of fields that are actually present
+		n = tn.Representation()
+	}
+	jwsBuilder := Type.DecodedJWS__Repr.NewBuilder()
+	if err := datamodel.Copy(n, jwsBuilder); err != nil {
+		return nil, err
+	}
+	return jwsBuilder.Build().(DecodedJWS), nil
+}
+
+// publicKeyOf returns the public key to verify signatures with for any of the supported key types.
+func publicKeyOf(key interface{}) interface{} {
+	key, _ = unwrapJSONWebKey(key)
+	switch k := key.(type) {
+	case *secp256k1.PrivateKey:
+		return k.PubKey()
+	case crypto.Signer:
+		return k.Public()
+	}
+	return key
+}
+
+// verifyPayload verifies a JWS signature over the signing input using the given algorithm and public key. An error
+// wrapping ErrInvalidSignature is returned if the key can be used with the algorithm but the signature is not valid.
+func verifyPayload(alg string, key interface{}, signingInput []byte, signature []byte) error {
+	hash, err := signatureHash(alg)
+	if err != nil {
+		return err
+	}
+	digest := signingInput
+	if hash != 0 {
+		hasher := hash.New()
+		hasher.Write(signingInput)
+		digest = hasher.Sum(nil)
+	}
+	valid := false
+	switch publicKey := publicKeyOf(key).(type) {
+	case ed25519.PublicKey:
+		if alg != EdDSA {
+			return fmt.Errorf("algorithm %s cannot be used with an ed25519 key", alg)
+		} else if len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ed25519 public key size: %d", len(publicKey))
+		}
+		valid = ed25519.Verify(publicKey, digest, signature)
+	case *ecdsa.PublicKey:
+		curve, err := ecdsaCurve(alg)
+		if err != nil {
+			return err
+		} else if curve != publicKey.Curve {
+			return fmt.Errorf("algorithm %s cannot be used with a %s key", alg, publicKey.Curve.Params().Name)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(publicKey, digest, r, s)
+		}
+	case *secp256k1.PublicKey:
+		if alg != ES256K {
+			return fmt.Errorf("algorithm %s cannot be used with a secp256k1 key", alg)
+		}
+		var r, s secp256k1.ModNScalar
+		if (len(signature) == 64) && !r.SetByteSlice(signature[:32]) && !s.SetByteSlice(signature[32:]) {
+			valid = secp256k1ecdsa.NewSignature(&r, &s).Verify(digest, publicKey)
+		}
+	case *rsa.PublicKey:
+		switch alg {
+		case RS256, RS384, RS512:
+			valid = rsa.VerifyPKCS1v15(publicKey, hash, digest, signature) == nil
+		case PS256, PS384, PS512:
+			valid = rsa.VerifyPSS(publicKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		default:
+			return fmt.Errorf("algorithm %s cannot be used with an RSA key", alg)
+		}
+	default:
+		return fmt.Errorf("unsupported verification key type: %T", key)
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
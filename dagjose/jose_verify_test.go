@@ -0,0 +1,264 @@
+package dagjose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	gojose "github.com/go-jose/go-jose/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"pgregory.net/rapid"
+)
+
+// Encode and decode a JWS so that verification runs against what would be read from a block
+func roundTripJWS(t require.TestingT, jws datamodel.Node) datamodel.Node {
+	encoded, err := ipld.Encode(jws, Encode)
+	require.NoError(t, err)
+	decoded, err := ipld.Decode(encoded, Decode)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestVerifyJWS(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		link := cidGen().Draw(t, "JWS payload").(cid.Cid)
+		edKey := ed25519PrivateKeyGen().Draw(t, "ed25519 private key").(ed25519.PrivateKey)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+		k1Key, err := secp256k1.GeneratePrivateKey()
+		require.NoError(t, err)
+		jws, err := SignJWS(link, SigningKey{Key: edKey}, SigningKey{Key: ecKey}, SigningKey{Key: k1Key})
+		require.NoError(t, err)
+		decoded := roundTripJWS(t, jws)
+
+		results, err := Verify(decoded, k1Key.PubKey(), &ecKey.PublicKey, edKey.Public())
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		for idx, expected := range []SignatureResult{
+			{Index: 0, Algorithm: EdDSA, Key: edKey.Public()},
+			{Index: 1, Algorithm: ES384, Key: &ecKey.PublicKey},
+			{Index: 2, Algorithm: ES256K, Key: k1Key.PubKey()},
+		} {
+			require.Equal(t, expected, results[idx])
+			require.True(t, results[idx].Valid())
+		}
+
+		// Only the signature for which a key is available can be verified
+		results, err = Verify(decoded, ecKey)
+		require.NoError(t, err)
+		require.ErrorIs(t, results[0].Err, ErrNoVerificationKey)
+		require.True(t, results[1].Valid())
+		require.ErrorIs(t, results[2].Err, ErrNoVerificationKey)
+	})
+}
+
+func TestVerifyJWSWithInvalidSignature(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{Key: edKey}, SigningKey{Key: rsaKey, Algorithm: PS256})
+	require.NoError(t, err)
+	// Corrupt the first signature
+	jws.signatures.v.x[0].signature.x[0] ^= 0xff
+
+	results, err := Verify(roundTripJWS(t, jws), edKey.Public(), &rsaKey.PublicKey)
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrInvalidSignature)
+	require.False(t, results[0].Valid())
+	require.True(t, results[1].Valid())
+
+	// Restricting the accepted algorithms rejects the other signature without trying any key
+	results, err = VerifyOptions{Keys: []interface{}{edKey, rsaKey}, Algorithms: []string{EdDSA}}.Verify(jws)
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrInvalidSignature)
+	require.ErrorContains(t, results[1].Err, "algorithm PS256 is not allowed")
+}
+
+// A malformed ed25519 public key must fail the signature rather than panic
+func TestVerifyJWSWithMalformedKey(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{Key: edKey})
+	require.NoError(t, err)
+	malformedKey := edKey.Public().(ed25519.PublicKey)[:16]
+	results, err := Verify(roundTripJWS(t, jws), malformedKey)
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrNoVerificationKey)
+	err = verifyPayload(EdDSA, malformedKey, []byte("signing input"), make([]byte, ed25519.SignatureSize))
+	require.ErrorContains(t, err, "invalid ed25519 public key size: 16")
+}
+
+// Keys from a JWK set are selected by `kid` when the signature has one
+func TestVerifyJWSWithKeySet(t *testing.T) {
+	firstKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	secondKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keySet := &gojose.JSONWebKeySet{Keys: []gojose.JSONWebKey{
+		{Key: &firstKey.PublicKey, KeyID: "first"},
+		{Key: &secondKey.PublicKey, KeyID: "second"},
+	}}
+	jws, err := SignJWS(
+		createCid([]byte("payload")),
+		SigningKey{Key: firstKey, KeyID: "first"},
+		SigningKey{Key: secondKey, KeyID: "first"},
+		SigningKey{Key: secondKey},
+	)
+	require.NoError(t, err)
+
+	results, err := VerifyOptions{KeySet: keySet}.Verify(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.True(t, results[0].Valid())
+	require.Equal(t, "first", results[0].KeyID)
+	require.Equal(t, keySet.Keys[0], results[0].Key)
+	require.ErrorIs(t, results[1].Err, ErrInvalidSignature)
+	require.True(t, results[2].Valid())
+	require.Equal(t, keySet.Keys[1], results[2].Key)
+}
+
+// Signatures produced by go-jose, including ones with an unprotected `kid`, can be verified
+func TestVerifyGoJOSEJWS(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	signer, err := gojose.NewSigner(gojose.SigningKey{Algorithm: gojose.EdDSA, Key: edKey}, nil)
+	require.NoError(t, err)
+	jws, err := SignJWS(createCid([]byte("payload")), SigningKey{Key: signer}, SigningKey{Key: edKey, Header: map[string]interface{}{"kid": "unprotected"}})
+	require.NoError(t, err)
+
+	results, err := VerifyOptions{KeySet: &gojose.JSONWebKeySet{Keys: []gojose.JSONWebKey{
+		{Key: edKey.Public(), KeyID: "unprotected"},
+	}}}.Verify(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.True(t, results[0].Valid())
+	require.True(t, results[1].Valid())
+	require.Equal(t, "unprotected", results[1].KeyID)
+}
+
+func TestVerifyJWSWithoutSignatures(t *testing.T) {
+	jws := &_EncodedJWS__Repr{payload: _Raw{createCid([]byte("payload")).Bytes()}}
+	_, err := Verify(roundTripJWS(t, jws))
+	require.ErrorContains(t, err, "no signatures")
+}
+
+// Signatures over the raw payload bytes (RFC 7797) interoperate with go-jose
+func TestVerifyUnencodedPayload(t *testing.T) {
+	link := createCid([]byte("payload"))
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jws, err := SignJWS(
+		link,
+		SigningKey{Key: edKey, UnencodedPayload: true},
+		SigningKey{Key: ecKey, UnencodedPayload: true, ExtraHeaders: map[string]interface{}{"crit": []string{"b64"}}},
+		SigningKey{Key: edKey},
+	)
+	require.NoError(t, err)
+	decoded, err := asDecodedJWS(roundTripJWS(t, jws))
+	require.NoError(t, err)
+	require.Equal(t, `{"alg":"EdDSA","b64":false,"crit":["b64"]}`, decoded.signatures.v.x[0].protected.v.x)
+	require.Equal(t, `{"alg":"ES256","b64":false,"crit":["b64"]}`, decoded.signatures.v.x[1].protected.v.x)
+
+	results, err := Verify(decoded, edKey.Public())
+	require.NoError(t, err)
+	require.True(t, results[0].Valid())
+	require.True(t, results[2].Valid())
+
+	// go-jose verifies the detached signature over the raw CID bytes
+	signature := decoded.signatures.v.x[0]
+	detached := encodeBase64Url([]byte(signature.protected.v.x)) + ".." + encodeBase64Url([]byte(signature.signature.x))
+	parsed, err := gojose.ParseDetached(detached, link.Bytes(), []gojose.SignatureAlgorithm{gojose.EdDSA})
+	require.NoError(t, err)
+	require.NoError(t, parsed.DetachedVerify(link.Bytes(), edKey.Public()))
+
+	// and produces signatures that verify here
+	signer, err := gojose.NewSigner(gojose.SigningKey{Algorithm: gojose.EdDSA, Key: edKey}, (&gojose.SignerOptions{}).WithBase64(false))
+	require.NoError(t, err)
+	signed, err := signer.Sign(link.Bytes())
+	require.NoError(t, err)
+	detached, err = signed.DetachedCompactSerialize()
+	require.NoError(t, err)
+	parts := strings.Split(detached, ".")
+	protected, err := decodeBase64Url(parts[0])
+	require.NoError(t, err)
+	signatureBytes, err := decodeBase64Url(parts[2])
+	require.NoError(t, err)
+	jwsStruct := &JWS{Payload: link, Signatures: []Signature{{Protected: protected, Signature: signatureBytes}}}
+	goJOSE, err := jwsStruct.Encoded()
+	require.NoError(t, err)
+	results, err = Verify(roundTripJWS(t, goJOSE), edKey.Public())
+	require.NoError(t, err)
+	require.True(t, results[0].Valid())
+}
+
+// The `b64` and `crit` header parameters must be used as RFC 7797 §6 and RFC 7515 §4.1.11 require
+func TestVerifyUnencodedPayloadErrors(t *testing.T) {
+	link := createCid([]byte("payload"))
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	scenarios := map[string]struct {
+		protected string
+		header    map[string]interface{}
+	}{
+		"`b64` header parameter must be listed in `crit`": {`{"alg":"EdDSA","b64":false}`, nil},
+		"`b64` header parameter must be a boolean":        {`{"alg":"EdDSA","b64":"false","crit":["b64"]}`, nil},
+		"`b64` header parameter must be protected":        {`{"alg":"EdDSA","crit":["b64"]}`, map[string]interface{}{"b64": false}},
+		"`crit` header parameter must be protected":       {`{"alg":"EdDSA","b64":false}`, map[string]interface{}{"crit": []string{"b64"}}},
+		"unsupported critical header parameter `exp`":     {`{"alg":"EdDSA","crit":["exp"],"exp":0}`, nil},
+	}
+	for expected, scenario := range scenarios {
+		signature, err := signPayload(EdDSA, edKey, signingInput([]byte(scenario.protected), link.Bytes(), false))
+		require.NoError(t, err)
+		jws, err := (&JWS{Payload: link, Signatures: []Signature{{
+			Header:    scenario.header,
+			Protected: []byte(scenario.protected),
+			Signature: signature,
+		}}}).Encoded()
+		require.NoError(t, err)
+		results, err := Verify(roundTripJWS(t, jws), edKey.Public())
+		require.NoError(t, err)
+		require.EqualError(t, results[0].Err, expected)
+	}
+
+	_, err := SignJWS(link, SigningKey{Key: edKey, ExtraHeaders: map[string]interface{}{"b64": false}})
+	require.ErrorContains(t, err, "must not be set through extra headers")
+}
+
+// Signatures of a JWS with a detached payload are verified against a CID kept elsewhere
+func TestVerifyDetached(t *testing.T) {
+	link := createCid([]byte("payload"))
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jws, err := SignJWS(link, SigningKey{Key: edKey}, SigningKey{Key: ecKey, UnencodedPayload: true})
+	require.NoError(t, err)
+	block, err := ipld.Encode(jws, EncodeOptions{DetachedPayload: true}.Encode)
+	require.NoError(t, err)
+	decoded, err := ipld.Decode(block, DecodeOptions{DetachedPayload: true}.Decode)
+	require.NoError(t, err)
+
+	results, err := VerifyDetached(link, decoded, edKey.Public(), &ecKey.PublicKey)
+	require.NoError(t, err)
+	require.True(t, results[0].Valid())
+	require.True(t, results[1].Valid())
+
+	results, err = VerifyDetached(createCid([]byte("other payload")), decoded, edKey, ecKey)
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, ErrInvalidSignature)
+	require.ErrorIs(t, results[1].Err, ErrInvalidSignature)
+
+	_, err = Verify(decoded, edKey)
+	require.ErrorContains(t, err, "detached payload")
+	_, err = VerifyDetached(cid.Undef, decoded, edKey)
+	require.ErrorContains(t, err, "not a valid CID")
+
+	// The signatures of a JWS with a payload can be verified against it as well
+	results, err = VerifyDetached(link, jws, edKey, ecKey)
+	require.NoError(t, err)
+	require.True(t, results[0].Valid())
+	require.True(t, results[1].Valid())
+}
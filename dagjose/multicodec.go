@@ -8,6 +8,6 @@ import (
 )
 
 func init() {
-	multicodec.RegisterDecoder(0x85, Decode)
-	multicodec.RegisterEncoder(0x85, Encode)
+	multicodec.RegisterDecoder(Codec, Decode)
+	multicodec.RegisterEncoder(Codec, Encode)
 }
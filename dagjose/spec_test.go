@@ -68,7 +68,7 @@ func TestSpecFixtures(t *testing.T) {
 				if fixtureCid, exists := dir.Children["serial.dag-jose.cid"]; exists {
 					t.Run("match-cid", func(t *testing.T) {
 						var linkSystem = cidlink.DefaultLinkSystem()
-						if lnk, err := linkSystem.ComputeLink(dagJOSELink, n); err != nil {
+						if lnk, err := linkSystem.ComputeLink(LinkPrototype, n); err != nil {
 							t.Fatalf("%s", err)
 						} else {
 							fixtureCidString := strings.TrimSpace(string(fixtureCid.Hunk.Body))